@@ -0,0 +1,114 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/handlers"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+)
+
+const usage = `Usage:
+  local-container-endpoints              Run the Local Endpoints server
+  local-container-endpoints mfa <code>   Start an MFA session in the running server with a code from your MFA device
+`
+
+// runCommand runs a subcommand against the server that is already running in this container,
+// and returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "mfa":
+		if len(args) != 2 {
+			break
+		}
+		return postAdminRequest(config.MFAAdminPath, &handlers.MFARequest{
+			TokenCode: args[1],
+		})
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+	return 2
+}
+
+// postAdminRequest sends the request to the admin endpoint and prints the response
+func postAdminRequest(path string, request interface{}) int {
+	body, err := json.Marshal(request)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	port := utils.GetValue(config.DefaultPort, config.PortVar)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://127.0.0.1:%s%s", port, path), bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// the admin paths require the same token as the credentials paths, which the server read from this container's environment
+	token, err := adminAuthorizationToken()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read the authorization token: ", err)
+		return 1
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to reach the Local Endpoints server: ", err)
+		return 1
+	}
+	defer res.Body.Close()
+
+	response, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read the response: ", err)
+		return 1
+	}
+
+	if res.StatusCode != http.StatusOK {
+		fmt.Fprint(os.Stderr, string(response))
+		return 1
+	}
+
+	fmt.Print(string(response))
+	return 0
+}
+
+// adminAuthorizationToken returns the value of AUTHORIZATION_TOKEN, or the contents of AUTHORIZATION_TOKEN_FILE
+func adminAuthorizationToken() (string, error) {
+	if tokenFile := utils.GetValue("", config.AuthorizationTokenFileVar); tokenFile != "" {
+		bits, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(bits)), nil
+	}
+	return utils.GetValue("", config.AuthorizationTokenVar), nil
+}
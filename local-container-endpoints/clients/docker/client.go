@@ -15,9 +15,15 @@
 package docker
 
 import (
+	"archive/tar"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
@@ -28,12 +34,20 @@ const (
 	// v1.27 is the oldest API version
 	// which has all the latest changes to the APIs we use.
 	minDockerAPIVersion = "1.27"
+
+	// containers are stopped like 'docker stop', which waits 10 seconds before killing them
+	containerStopTimeout = 10 * time.Second
+
+	// files read from containers are small, such as tokens
+	maxContainerFileSize = 1024 * 1024
 )
 
 // Client is a wrapper for Docker SDK Client
 type Client interface {
 	ContainerList(context.Context) ([]types.Container, error)
 	ContainerStats(ctx context.Context, longContainerID string) (*types.Stats, error)
+	ContainerStop(ctx context.Context, longContainerID string) error
+	ReadContainerFile(ctx context.Context, longContainerID, path string) ([]byte, error)
 }
 
 type dockerClient struct {
@@ -77,3 +91,56 @@ func (c *dockerClient) ContainerStats(ctx context.Context, longContainerID strin
 	}
 	return data, nil
 }
+
+// ContainerStop stops a container, killing it if it does not exit within the timeout
+func (c *dockerClient) ContainerStop(ctx context.Context, longContainerID string) error {
+	timeout := containerStopTimeout
+	if err := c.sdkClient.ContainerStop(ctx, longContainerID, &timeout); err != nil {
+		return errors.Wrapf(err, "failed to stop container %s", longContainerID)
+	}
+	return nil
+}
+
+// ReadContainerFile returns the contents of a file in a container, including its mounts, following symbolic links
+func (c *dockerClient) ReadContainerFile(ctx context.Context, longContainerID, filePath string) ([]byte, error) {
+	stat, err := c.sdkClient.ContainerStatPath(ctx, longContainerID, filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find %s in container %s", filePath, longContainerID)
+	}
+	if stat.LinkTarget != "" {
+		filePath = stat.LinkTarget
+		stat, err = c.sdkClient.ContainerStatPath(ctx, longContainerID, filePath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find %s in container %s", filePath, longContainerID)
+		}
+	}
+	if stat.Mode.IsDir() {
+		return nil, fmt.Errorf("%s in container %s is a directory, not a file", filePath, longContainerID)
+	}
+
+	reader, _, err := c.sdkClient.CopyFromContainer(ctx, longContainerID, filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s from container %s", filePath, longContainerID)
+	}
+	defer reader.Close()
+
+	// the archive of a file has a single entry, named after the file
+	name := path.Base(filePath)
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s in container %s is not a file", filePath, longContainerID)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s from container %s", filePath, longContainerID)
+		}
+		if header.Typeflag != tar.TypeReg || header.Name != name {
+			continue
+		}
+		if header.Size > maxContainerFileSize {
+			return nil, fmt.Errorf("%s in container %s is larger than %d bytes", filePath, longContainerID, maxContainerFileSize)
+		}
+		return ioutil.ReadAll(archive)
+	}
+}
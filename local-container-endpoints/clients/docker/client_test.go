@@ -0,0 +1,137 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+)
+
+const testContainerID = "0123456789abcdef"
+
+// containerFile is a file or directory in the fake container, with the entries of its archive
+type containerFile struct {
+	stat    types.ContainerPathStat
+	entries map[string]string
+}
+
+// newFakeDockerServer answers the archive API for the files in the fake container
+func newFakeDockerServer(t *testing.T, files map[string]containerFile) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/containers/"+testContainerID+"/archive") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		file, ok := files[r.URL.Query().Get("path")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		stat, err := json.Marshal(file.stat)
+		assert.NoError(t, err, "Unexpected error marshalling stat")
+		w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(stat))
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		var buf bytes.Buffer
+		archive := tar.NewWriter(&buf)
+		for name, contents := range file.entries {
+			archive.WriteHeader(&tar.Header{
+				Name:     name,
+				Typeflag: tar.TypeReg,
+				Mode:     0644,
+				Size:     int64(len(contents)),
+			})
+			archive.Write([]byte(contents))
+		}
+		archive.Close()
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Write(buf.Bytes())
+	}))
+}
+
+func newDockerClientInTest(t *testing.T, server *httptest.Server) Client {
+	sdkClient, err := client.NewClientWithOpts(client.WithHost(strings.Replace(server.URL, "http://", "tcp://", 1)), client.WithVersion(minDockerAPIVersion))
+	assert.NoError(t, err, "Unexpected error creating Docker client")
+	return &dockerClient{
+		sdkClient: sdkClient,
+	}
+}
+
+func TestReadContainerFile(t *testing.T) {
+	server := newFakeDockerServer(t, map[string]containerFile{
+		"/tokens/token": {
+			stat:    types.ContainerPathStat{Name: "token", Mode: 0644},
+			entries: map[string]string{"token": "pudding"},
+		},
+		"/run/token": {
+			stat: types.ContainerPathStat{Name: "token", Mode: os.ModeSymlink | 0777, LinkTarget: "/tokens/token"},
+		},
+	})
+	defer server.Close()
+	dockerClient := newDockerClientInTest(t, server)
+
+	for _, path := range []string{"/tokens/token", "/run/token"} {
+		contents, err := dockerClient.ReadContainerFile(context.Background(), testContainerID, path)
+		assert.NoError(t, err, "Unexpected error reading %s", path)
+		assert.Equal(t, "pudding", string(contents), "Expected contents of %s to match", path)
+	}
+}
+
+func TestReadContainerFileDirectory(t *testing.T) {
+	server := newFakeDockerServer(t, map[string]containerFile{
+		"/tokens": {
+			stat:    types.ContainerPathStat{Name: "tokens", Mode: os.ModeDir | 0755},
+			entries: map[string]string{"tokens/token": "pudding"},
+		},
+		"/run/tokens": {
+			stat: types.ContainerPathStat{Name: "tokens", Mode: os.ModeSymlink | 0777, LinkTarget: "/tokens"},
+		},
+	})
+	defer server.Close()
+	dockerClient := newDockerClientInTest(t, server)
+
+	for _, path := range []string{"/tokens", "/run/tokens"} {
+		_, err := dockerClient.ReadContainerFile(context.Background(), testContainerID, path)
+		assert.Error(t, err, "Expected an error reading directory %s", path)
+	}
+}
+
+func TestReadContainerFileIgnoresOtherEntries(t *testing.T) {
+	server := newFakeDockerServer(t, map[string]containerFile{
+		"/tokens/token": {
+			stat:    types.ContainerPathStat{Name: "token", Mode: 0644},
+			entries: map[string]string{"other": "pudding"},
+		},
+	})
+	defer server.Close()
+	dockerClient := newDockerClientInTest(t, server)
+
+	_, err := dockerClient.ReadContainerFile(context.Background(), testContainerID, "/tokens/token")
+	assert.Error(t, err, "Expected an error when the archive does not contain the file")
+}
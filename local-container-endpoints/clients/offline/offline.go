@@ -0,0 +1,283 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package offline provides IAM, STS, and IAM Roles Anywhere clients which never call AWS, and instead
+// vend random, well-formed credentials
+package offline
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/rolesanywhere"
+)
+
+const (
+	// temporary credentials from STS have access key IDs beginning with ASIA
+	accessKeyIDPrefix = "ASIA"
+	accessKeyIDLength = 20
+	secretKeyBytes    = 30
+	sessionTokenBytes = 264
+
+	defaultDurationInS = 3600
+	// maxSessionDuration is reported for every role, so that configured durations are not capped
+	maxSessionDuration = 43200
+
+	userName = "ecs-local-offline"
+)
+
+// Record describes the credentials which were vended for an access key ID
+type Record struct {
+	AccessKeyID     string
+	Operation       string
+	RoleArn         string `json:",omitempty"`
+	RoleSessionName string `json:",omitempty"`
+	// FederatedUserArn is set for credentials from GetFederationToken
+	FederatedUserArn string `json:",omitempty"`
+	Expiration       time.Time
+}
+
+// Client implements the IAM, STS, and IAM Roles Anywhere APIs used by Local Endpoints without calling AWS.
+// Calling any other API will panic.
+type Client struct {
+	iamiface.IAMAPI
+	stsiface.STSAPI
+
+	accountID string
+	duration  time.Duration
+	now       func() time.Time
+
+	lock    sync.RWMutex
+	records map[string]*Record
+}
+
+// New returns a Client for roles in the given account. If duration is not zero, all credentials
+// expire after it; otherwise the duration in each request is used.
+func New(accountID string, duration time.Duration) *Client {
+	return &Client{
+		accountID: accountID,
+		duration:  duration,
+		now:       time.Now,
+		records:   make(map[string]*Record),
+	}
+}
+
+// Lookup returns the record for the access key ID, or nil if this client did not vend it
+func (client *Client) Lookup(accessKeyID string) *Record {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+
+	return client.records[accessKeyID]
+}
+
+// Records returns every record, ordered by expiration, soonest first
+func (client *Client) Records() []*Record {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+
+	records := make([]*Record, 0, len(client.records))
+	for _, record := range client.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Expiration.Before(records[j].Expiration)
+	})
+	return records
+}
+
+// GetRole returns a role without a path in the client's account
+func (client *Client) GetRole(input *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	return &iam.GetRoleOutput{
+		Role: &iam.Role{
+			Arn:                aws.String(client.arn(iam.ServiceName, "role/"+aws.StringValue(input.RoleName))),
+			RoleName:           input.RoleName,
+			MaxSessionDuration: aws.Int64(maxSessionDuration),
+		},
+	}, nil
+}
+
+// AssumeRole returns new credentials, and records that they belong to the role
+func (client *Client) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	creds, err := client.newCredentials(input.DurationSeconds, &Record{
+		Operation:       "AssumeRole",
+		RoleArn:         aws.StringValue(input.RoleArn),
+		RoleSessionName: aws.StringValue(input.RoleSessionName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sts.AssumeRoleOutput{
+		Credentials: creds,
+	}, nil
+}
+
+// AssumeRoleWithWebIdentity returns new credentials, and records that they belong to the role.
+// The token is not verified.
+func (client *Client) AssumeRoleWithWebIdentity(input *sts.AssumeRoleWithWebIdentityInput) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	creds, err := client.newCredentials(input.DurationSeconds, &Record{
+		Operation:       "AssumeRoleWithWebIdentity",
+		RoleArn:         aws.StringValue(input.RoleArn),
+		RoleSessionName: aws.StringValue(input.RoleSessionName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sts.AssumeRoleWithWebIdentityOutput{
+		Credentials: creds,
+	}, nil
+}
+
+// CreateSession returns new credentials for the role without verifying the certificate
+func (client *Client) CreateSession(input *rolesanywhere.CreateSessionInput, signer *rolesanywhere.Signer) (*rolesanywhere.CreateSessionOutput, error) {
+	creds, err := client.newCredentials(input.DurationSeconds, &Record{
+		Operation:       "CreateSession",
+		RoleArn:         input.RoleArn,
+		RoleSessionName: input.RoleSessionName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &rolesanywhere.CreateSessionOutput{
+		CredentialSet: []rolesanywhere.CredentialSet{
+			{
+				Credentials: &rolesanywhere.Credentials{
+					AccessKeyID:     aws.StringValue(creds.AccessKeyId),
+					SecretAccessKey: aws.StringValue(creds.SecretAccessKey),
+					SessionToken:    aws.StringValue(creds.SessionToken),
+					Expiration:      aws.TimeValue(creds.Expiration),
+				},
+				RoleArn: input.RoleArn,
+			},
+		},
+	}, nil
+}
+
+// GetFederationToken returns new credentials for a federated user in the client's account
+func (client *Client) GetFederationToken(input *sts.GetFederationTokenInput) (*sts.GetFederationTokenOutput, error) {
+	federatedUserArn := client.arn(sts.ServiceName, "federated-user/"+aws.StringValue(input.Name))
+	creds, err := client.newCredentials(input.DurationSeconds, &Record{
+		Operation:        "GetFederationToken",
+		FederatedUserArn: federatedUserArn,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sts.GetFederationTokenOutput{
+		Credentials: creds,
+		FederatedUser: &sts.FederatedUser{
+			Arn:             aws.String(federatedUserArn),
+			FederatedUserId: aws.String(client.accountID + ":" + aws.StringValue(input.Name)),
+		},
+	}, nil
+}
+
+// GetSessionToken returns new credentials
+func (client *Client) GetSessionToken(input *sts.GetSessionTokenInput) (*sts.GetSessionTokenOutput, error) {
+	creds, err := client.newCredentials(input.DurationSeconds, &Record{
+		Operation: "GetSessionToken",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sts.GetSessionTokenOutput{
+		Credentials: creds,
+	}, nil
+}
+
+// GetCallerIdentity returns an IAM user in the client's account
+func (client *Client) GetCallerIdentity(input *sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String(client.accountID),
+		Arn:     aws.String(client.arn(iam.ServiceName, "user/"+userName)),
+		UserId:  aws.String(userName),
+	}, nil
+}
+
+func (client *Client) arn(service, resource string) string {
+	return arn.ARN{
+		Partition: "aws",
+		Service:   service,
+		AccountID: client.accountID,
+		Resource:  resource,
+	}.String()
+}
+
+func (client *Client) newCredentials(durationSeconds *int64, record *Record) (*sts.Credentials, error) {
+	duration := client.duration
+	if duration == 0 {
+		duration = time.Duration(aws.Int64Value(durationSeconds)) * time.Second
+	}
+	if duration == 0 {
+		duration = defaultDurationInS * time.Second
+	}
+
+	accessKeyID, err := randomAccessKeyID()
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := randomString(secretKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	sessionToken, err := randomString(sessionTokenBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	record.AccessKeyID = accessKeyID
+	record.Expiration = client.now().Add(duration).UTC().Truncate(time.Second)
+
+	client.lock.Lock()
+	client.records[accessKeyID] = record
+	client.lock.Unlock()
+
+	return &sts.Credentials{
+		AccessKeyId:     aws.String(accessKeyID),
+		SecretAccessKey: aws.String(secretKey),
+		SessionToken:    aws.String(sessionToken),
+		Expiration:      aws.Time(record.Expiration),
+	}, nil
+}
+
+// randomAccessKeyID returns an ID in the format of an STS access key ID: ASIA followed by 16 upper case letters and digits
+func randomAccessKeyID() (string, error) {
+	bits := make([]byte, 10)
+	if _, err := rand.Read(bits); err != nil {
+		return "", fmt.Errorf("failed to generate access key ID: %s", err)
+	}
+	return (accessKeyIDPrefix + base32.StdEncoding.EncodeToString(bits))[:accessKeyIDLength], nil
+}
+
+func randomString(length int) (string, error) {
+	bits := make([]byte, length)
+	if _, err := rand.Read(bits); err != nil {
+		return "", fmt.Errorf("failed to generate credentials: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(bits), nil
+}
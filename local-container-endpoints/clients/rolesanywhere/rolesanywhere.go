@@ -0,0 +1,330 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package rolesanywhere provides a client for the IAM Roles Anywhere CreateSession API, which is authenticated
+// with an X.509 certificate and its private key instead of AWS credentials
+package rolesanywhere
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/pkg/errors"
+)
+
+const (
+	// EndpointsID is the ID used to look up the IAM Roles Anywhere endpoint
+	EndpointsID = "rolesanywhere"
+
+	sessionsPath = "/sessions"
+	httpTimeout  = 30 * time.Second
+
+	rsaAlgorithm   = "AWS4-X509-RSA-SHA256"
+	ecdsaAlgorithm = "AWS4-X509-ECDSA-SHA256"
+	timeFormat     = "20060102T150405Z"
+	dateFormat     = "20060102"
+
+	authorizationHeader = "Authorization"
+	contentTypeHeader   = "Content-Type"
+	dateHeader          = "X-Amz-Date"
+	x509Header          = "X-Amz-X509"
+	x509ChainHeader     = "X-Amz-X509-Chain"
+	errorTypeHeader     = "X-Amzn-Errortype"
+	requestIDHeader     = "X-Amzn-Requestid"
+)
+
+// API is the subset of IAM Roles Anywhere used by Local Endpoints
+type API interface {
+	CreateSession(input *CreateSessionInput, signer *Signer) (*CreateSessionOutput, error)
+}
+
+// CreateSessionInput is the body of a CreateSession request
+type CreateSessionInput struct {
+	DurationSeconds *int64 `json:"durationSeconds,omitempty"`
+	ProfileArn      string `json:"profileArn"`
+	RoleArn         string `json:"roleArn"`
+	RoleSessionName string `json:"roleSessionName,omitempty"`
+	TrustAnchorArn  string `json:"trustAnchorArn"`
+}
+
+// CreateSessionOutput is the body of a CreateSession response
+type CreateSessionOutput struct {
+	CredentialSet []CredentialSet `json:"credentialSet"`
+	SubjectArn    string          `json:"subjectArn,omitempty"`
+}
+
+// CredentialSet is the credentials for a role
+type CredentialSet struct {
+	AssumedRoleUser *AssumedRoleUser `json:"assumedRoleUser,omitempty"`
+	Credentials     *Credentials     `json:"credentials"`
+	RoleArn         string           `json:"roleArn,omitempty"`
+	SourceIdentity  string           `json:"sourceIdentity,omitempty"`
+}
+
+// AssumedRoleUser identifies the session
+type AssumedRoleUser struct {
+	Arn           string `json:"arn"`
+	AssumedRoleID string `json:"assumedRoleId"`
+}
+
+// Credentials are temporary AWS credentials
+type Credentials struct {
+	AccessKeyID     string    `json:"accessKeyId"`
+	SecretAccessKey string    `json:"secretAccessKey"`
+	SessionToken    string    `json:"sessionToken"`
+	Expiration      time.Time `json:"expiration"`
+}
+
+// Client calls IAM Roles Anywhere
+type Client struct {
+	resolver   endpoints.Resolver
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// New returns a Client which looks up the endpoint for each region with the resolver
+func New(resolver endpoints.Resolver) *Client {
+	return &Client{
+		resolver:   resolver,
+		httpClient: &http.Client{Timeout: httpTimeout},
+		now:        time.Now,
+	}
+}
+
+// CreateSession exchanges the signer's certificate for credentials. The request is sent to the region of the trust anchor.
+func (client *Client) CreateSession(input *CreateSessionInput, signer *Signer) (*CreateSessionOutput, error) {
+	trustAnchor, err := arn.Parse(input.TrustAnchorArn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid trust anchor ARN %s", input.TrustAnchorArn)
+	}
+	endpoint, err := client.resolver.EndpointFor(EndpointsID, trustAnchor.Region)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find the IAM Roles Anywhere endpoint in %s", trustAnchor.Region)
+	}
+
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(endpoint.URL, "/")+sessionsPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set(contentTypeHeader, "application/json")
+	if err = signer.Sign(request, body, trustAnchor.Region, client.now()); err != nil {
+		return nil, err
+	}
+
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to call IAM Roles Anywhere")
+	}
+	defer response.Body.Close()
+	bits, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the IAM Roles Anywhere response")
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		apiError := struct {
+			Message string `json:"message"`
+		}{}
+		json.Unmarshal(bits, &apiError)
+		// the error type may be followed by a colon and more details
+		code := strings.SplitN(response.Header.Get(errorTypeHeader), ":", 2)[0]
+		return nil, awserr.NewRequestFailure(awserr.New(code, apiError.Message, nil), response.StatusCode, response.Header.Get(requestIDHeader))
+	}
+
+	output := &CreateSessionOutput{}
+	if err = json.Unmarshal(bits, output); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the IAM Roles Anywhere response")
+	}
+	return output, nil
+}
+
+// Signer signs requests with the AWS Signature Version 4 for X.509 scheme
+type Signer struct {
+	Certificate *x509.Certificate
+	// Chain is the intermediate CAs between the certificate and the trust anchor
+	Chain      []*x509.Certificate
+	PrivateKey crypto.Signer
+}
+
+// LoadSigner reads the certificate, followed by its chain, and the RSA or EC private key from PEM files
+func LoadSigner(certificatePath, privateKeyPath string) (*Signer, error) {
+	bits, err := ioutil.ReadFile(certificatePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read certificate")
+	}
+	var certificates []*x509.Certificate
+	for block, rest := pem.Decode(bits); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse certificate %s", certificatePath)
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("No PEM encoded certificate found in %s", certificatePath)
+	}
+
+	bits, err = ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read private key")
+	}
+	block, _ := pem.Decode(bits)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM encoded private key found in %s", privateKeyPath)
+	}
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse private key %s", privateKeyPath)
+	}
+
+	return &Signer{
+		Certificate: certificates[0],
+		Chain:       certificates[1:],
+		PrivateKey:  key,
+	}, nil
+}
+
+// parsePrivateKey accepts PKCS #1 RSA keys, SEC 1 EC keys, and PKCS #8 keys of either type
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("Unsupported private key type %T", key)
+	}
+}
+
+// Sign adds the certificate, the time, and the signature to the request's headers
+func (signer *Signer) Sign(request *http.Request, body []byte, region string, signingTime time.Time) error {
+	var algorithm string
+	switch signer.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		algorithm = rsaAlgorithm
+	case *ecdsa.PublicKey:
+		algorithm = ecdsaAlgorithm
+	default:
+		return fmt.Errorf("Unsupported private key type %T", signer.PrivateKey)
+	}
+
+	amzDate := signingTime.UTC().Format(timeFormat)
+	request.Header.Set(dateHeader, amzDate)
+	request.Header.Set(x509Header, base64.StdEncoding.EncodeToString(signer.Certificate.Raw))
+	if len(signer.Chain) > 0 {
+		chain := make([]string, len(signer.Chain))
+		for i, certificate := range signer.Chain {
+			chain[i] = base64.StdEncoding.EncodeToString(certificate.Raw)
+		}
+		request.Header.Set(x509ChainHeader, strings.Join(chain, ","))
+	}
+
+	scope := strings.Join([]string{signingTime.UTC().Format(dateFormat), region, EndpointsID, "aws4_request"}, "/")
+	signedHeaders := signedHeaderNames(request)
+	canonicalRequest := CanonicalRequest(request, body, signedHeaders)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{algorithm, amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := signer.PrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign request")
+	}
+
+	request.Header.Set(authorizationHeader, fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, signer.Certificate.SerialNumber.String(), scope, strings.Join(signedHeaders, ";"), hex.EncodeToString(signature)))
+	return nil
+}
+
+// signedHeaderNames returns the lower case names of the headers to sign, which is every header except Authorization
+// and User-Agent, and the host
+func signedHeaderNames(request *http.Request) []string {
+	names := []string{"host"}
+	for name := range request.Header {
+		name = strings.ToLower(name)
+		if name != "authorization" && name != "user-agent" && name != "host" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CanonicalRequest returns the canonical form of the request with the given signed headers, as in Signature Version 4
+func CanonicalRequest(request *http.Request, body []byte, signedHeaders []string) string {
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := request.Host
+		if value == "" {
+			value = request.URL.Host
+		}
+		if name != "host" {
+			values := request.Header.Values(name)
+			trimmed := make([]string, len(values))
+			for i, value := range values {
+				trimmed[i] = strings.Join(strings.Fields(value), " ")
+			}
+			value = strings.Join(trimmed, ",")
+		}
+		canonicalHeaders.WriteString(name + ":" + value + "\n")
+	}
+
+	path := request.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	return strings.Join([]string{
+		request.Method,
+		path,
+		request.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+}
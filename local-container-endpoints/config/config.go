@@ -30,10 +30,61 @@ const (
 	// Custom endpoint related
 	IAMCustomEndpointVar = "IAM_ENDPOINT"
 	STSCustomEndpointVar = "STS_ENDPOINT"
+	// The SSO portal, which exchanges SSO tokens for credentials, and the SSO OIDC service, which issues SSO tokens
+	SSOCustomEndpointVar     = "SSO_ENDPOINT"
+	SSOOIDCCustomEndpointVar = "SSO_OIDC_ENDPOINT"
+	// IAM Roles Anywhere, which exchanges X.509 certificates for credentials
+	RolesAnywhereCustomEndpointVar = "ROLES_ANYWHERE_ENDPOINT"
 
 	// Shared credentials default expiration value when a token is detected.
 	SharedTokenExpirationVar = "SHARED_TOKEN_EXPIRATION"
 
+	// How long before expiration cached credentials are refreshed.
+	CredentialsRefreshMarginVar = "CREDENTIALS_REFRESH_MARGIN"
+
+	// How often the shared AWS config files are checked for changes to the base credentials.
+	CredentialsReloadIntervalVar = "CREDENTIALS_RELOAD_INTERVAL"
+
+	// Path to the JSON credentials configuration file
+	CredentialsConfigPathVar = "CREDENTIALS_CONFIG_PATH"
+
+	// Token that callers must present in the Authorization header to obtain credentials
+	AuthorizationTokenVar     = "AUTHORIZATION_TOKEN"
+	AuthorizationTokenFileVar = "AUTHORIZATION_TOKEN_FILE"
+
+	// MFA device which must be used to obtain a session before any credentials are vended
+	MFASerialVar          = "MFA_SERIAL"
+	MFASessionDurationVar = "MFA_SESSION_DURATION"
+
+	// How /role/{role name} resolves role names to ARNs: "iam" (iam:GetRole) or "caller-identity" (sts:GetCallerIdentity)
+	RoleResolutionVar = "ROLE_RESOLUTION"
+
+	// Audit log of every credentials request, written as JSON lines to a file and/or stdout
+	AuditLogPathVar   = "AUDIT_LOG_PATH"
+	AuditLogStdoutVar = "AUDIT_LOG_STDOUT"
+
+	// Vend random credentials without calling AWS
+	OfflineModeVar                = "OFFLINE_MODE"
+	OfflineAccountIDVar           = "OFFLINE_ACCOUNT_ID"
+	OfflineCredentialsDurationVar = "OFFLINE_CREDENTIALS_DURATION"
+
+	// EC2 Instance Metadata Service (IMDS) emulation
+	IMDSDisableV1Var = "IMDS_DISABLE_V1"
+	IMDSRoleVar      = "IMDS_ROLE"
+	// JSON or YAML file which overrides the emulated instance metadata
+	IMDSMetadataPathVar = "IMDS_METADATA_PATH"
+	// PEM files with the RSA key and certificate which sign instance identity documents
+	IMDSSigningKeyPathVar         = "IMDS_SIGNING_KEY_PATH"
+	IMDSSigningCertificatePathVar = "IMDS_SIGNING_CERTIFICATE_PATH"
+
+	// Built-in OIDC issuer for web identity federation
+	OIDCIssuerURLVar      = "OIDC_ISSUER_URL"
+	OIDCTokenAudienceVar  = "OIDC_TOKEN_AUDIENCE"
+	OIDCTokenDurationVar  = "OIDC_TOKEN_DURATION"
+	OIDCTokenDirectoryVar = "OIDC_TOKEN_DIRECTORY"
+	// PEM file with the RSA key which signs OIDC tokens
+	OIDCSigningKeyPathVar = "OIDC_SIGNING_KEY_PATH"
+
 	// User-defined, static metadata that overrides/augments the normal response
 	ContainerMetadataPathVar = "CONTAINER_METADATA_PATH"
 	TaskMetadataPathVar      = "TASK_METADATA_PATH"
@@ -53,6 +104,36 @@ const (
 
 	// Expire shared credentials with a token in 12.5 minutes.
 	DefaultSharedTokenExpiration = 750
+
+	// Refresh cached credentials 20 minutes before they expire, which is
+	// earlier than the SDKs start trying to refresh credentials themselves.
+	DefaultCredentialsRefreshMargin = 1200
+
+	// Check for changes to the base credentials every 5 seconds.
+	DefaultCredentialsReloadInterval = 5
+
+	// Role names are resolved with iam:GetRole unless another mode is configured.
+	RoleResolutionIAM            = "iam"
+	RoleResolutionCallerIdentity = "caller-identity"
+	DefaultRoleResolution        = RoleResolutionIAM
+
+	// Roles are in this account in offline mode, unless another is configured.
+	DefaultOfflineAccountID = "111111111111"
+
+	// IMDS session tokens last at most 6 hours, like those of EC2.
+	IMDSMaxTokenTTL = 21600
+
+	// Emulated instance metadata; the region and account come from the task ARN.
+	DefaultIMDSImageID      = "ami-0123456789abcdef0"
+	DefaultIMDSInstanceType = "m5.large"
+
+	// MFA sessions last 12 hours, which is the sts:GetSessionToken default.
+	DefaultMFASessionDuration = 43200
+
+	// The OIDC issuer is reached at the address of the ECS credentials endpoint, and its tokens are for STS and last 15 minutes.
+	DefaultOIDCIssuerURL     = "http://169.254.170.2"
+	DefaultOIDCTokenAudience = "sts.amazonaws.com"
+	DefaultOIDCTokenDuration = 900
 )
 
 // Settings
@@ -69,17 +150,103 @@ const (
 	// RoleCredentialsPathWithSlash adds a trailing slash
 	RoleCredentialsPathWithSlash = RoleCredentialsPath + "/"
 
+	// RoleAccountCredentialsPath is the path for obtaining credentials from a role in another account, given by ID or alias
+	RoleAccountCredentialsPath = "/role/{account}/{role}"
+	// RoleAccountCredentialsPathWithSlash adds a trailing slash
+	RoleAccountCredentialsPathWithSlash = RoleAccountCredentialsPath + "/"
+
 	//RoleArnCredentialsPath is the path for obtaining credentials from a role ARN
-	RoleArnCredentialsPath = "/role-arn/{roleArn}/{roleName}"
+	RoleArnCredentialsPath = "/role-arn/{roleArn:.+}"
 	// RoleArnCredentialsPathWithSlash adds a trailing slash
 	RoleArnCredentialsPathWithSlash = RoleArnCredentialsPath + "/"
 
+	// RoleChainCredentialsPath is the path for obtaining credentials by assuming each role in a configured chain
+	RoleChainCredentialsPath = "/role-chain/{chain}"
+	// RoleChainCredentialsPathWithSlash adds a trailing slash
+	RoleChainCredentialsPathWithSlash = RoleChainCredentialsPath + "/"
+
+	// ProcessCredentialsPath is the path for obtaining credentials from a configured credential process
+	ProcessCredentialsPath = "/process/{process}"
+	// ProcessCredentialsPathWithSlash adds a trailing slash
+	ProcessCredentialsPathWithSlash = ProcessCredentialsPath + "/"
+
+	// WebIdentityCredentialsPath is the path for obtaining credentials for a configured web identity
+	WebIdentityCredentialsPath = "/web-identity/{identity}"
+	// WebIdentityCredentialsPathWithSlash adds a trailing slash
+	WebIdentityCredentialsPathWithSlash = WebIdentityCredentialsPath + "/"
+
+	// RolesAnywhereCredentialsPath is the path for obtaining credentials for a configured IAM Roles Anywhere profile
+	RolesAnywhereCredentialsPath = "/roles-anywhere/{profile}"
+	// RolesAnywhereCredentialsPathWithSlash adds a trailing slash
+	RolesAnywhereCredentialsPathWithSlash = RolesAnywhereCredentialsPath + "/"
+
+	// TaskRoleCredentialsPath is the path for obtaining credentials from the role in the caller container's labels
+	TaskRoleCredentialsPath = "/task-role"
+	// TaskRoleCredentialsPathWithSlash adds a trailing slash
+	TaskRoleCredentialsPathWithSlash = TaskRoleCredentialsPath + "/"
+
+	// PodIdentityCredentialsPath is the path at which the EKS Pod Identity Agent vends credentials
+	PodIdentityCredentialsPath = "/v1/credentials"
+	// PodIdentityCredentialsPathWithSlash adds a trailing slash
+	PodIdentityCredentialsPathWithSlash = PodIdentityCredentialsPath + "/"
+
+	// ProfileCredentialsPath is the path for obtaining credentials for a profile in the shared AWS config files
+	ProfileCredentialsPath = "/profile/{profile}"
+	// ProfileCredentialsPathWithSlash adds a trailing slash
+	ProfileCredentialsPathWithSlash = ProfileCredentialsPath + "/"
+
 	// TempCredentialsPath is the path for obtaining temp creds from sts:GetSessionsToken
 	TempCredentialsPath = "/creds"
 	// TempCredentialsPathWithSlash adds a trailing slash
 	TempCredentialsPathWithSlash = TempCredentialsPath + "/"
 )
 
+// Admin
+const (
+	// MFAAdminPath is the path for submitting MFA codes and checking the MFA session
+	MFAAdminPath = "/admin/mfa"
+	// OfflineCredentialsAdminPath is the path for listing the credentials vended in offline mode
+	OfflineCredentialsAdminPath = "/admin/offline/credentials"
+	// OfflineCredentialsAdminPathWithAccessKey is the path for looking up one access key vended in offline mode
+	OfflineCredentialsAdminPathWithAccessKey = OfflineCredentialsAdminPath + "/{accessKeyId}"
+	// IMDSCertificateAdminPath is the path for the certificate which verifies signed instance identity documents
+	IMDSCertificateAdminPath = "/admin/imds/certificate"
+	// IMDSEventsAdminPath is the path for scheduling simulated Spot interruptions and maintenance events
+	IMDSEventsAdminPath = "/admin/imds/events"
+	// SSOLoginAdminPath is the path for starting and checking the IAM Identity Center device authorization login
+	SSOLoginAdminPath = "/admin/sso"
+)
+
+// OIDC issuer
+const (
+	// OIDCDiscoveryPath is the path of the OpenID Connect discovery document
+	OIDCDiscoveryPath = "/.well-known/openid-configuration"
+	// OIDCJWKSPath is the path of the JSON Web Key Set which verifies tokens
+	OIDCJWKSPath = "/.well-known/jwks.json"
+	// OIDCTokenPath is the path for obtaining a token for the calling container
+	OIDCTokenPath = "/oidc/token"
+)
+
+// IMDS
+const (
+	// IMDSTokenPath is the path for obtaining IMDSv2 session tokens
+	IMDSTokenPath = "/latest/api/token"
+
+	// IMDSRootPath is the root of the instance metadata tree
+	IMDSRootPath = "/latest"
+	// IMDSMetadataPath is the path for any item in the instance metadata tree
+	IMDSMetadataPath = IMDSRootPath + "/{path:.*}"
+
+	// IMDSSecurityCredentialsPath is the path which lists the instance's role
+	IMDSSecurityCredentialsPath = "/latest/meta-data/iam/security-credentials"
+	// IMDSSecurityCredentialsPathWithSlash adds a trailing slash
+	IMDSSecurityCredentialsPathWithSlash = IMDSSecurityCredentialsPath + "/"
+	// IMDSRoleCredentialsPath is the path for obtaining credentials for the instance's role
+	IMDSRoleCredentialsPath = IMDSSecurityCredentialsPath + "/{role}"
+	// IMDSRoleCredentialsPathWithSlash adds a trailing slash
+	IMDSRoleCredentialsPathWithSlash = IMDSRoleCredentialsPath + "/"
+)
+
 // V3
 const (
 	// V3ContainerMetadataPath is the path for V3 container metadata
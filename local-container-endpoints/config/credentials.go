@@ -0,0 +1,133 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// CredentialsConfig is the user defined configuration for vending credentials,
+// read from the JSON file at CREDENTIALS_CONFIG_PATH
+type CredentialsConfig struct {
+	// AuthorizationTokens maps role names or role ARNs to the token that must be
+	// presented in the Authorization header to obtain credentials for that role
+	AuthorizationTokens map[string]string
+
+	// RoleDefaults customizes every AssumeRole request
+	RoleDefaults RoleConfig
+	// Roles customizes AssumeRole requests for particular roles, keyed by role name or role ARN.
+	// Settings for a role take precedence over RoleDefaults.
+	Roles map[string]RoleConfig
+
+	// AccountAliases maps names to AWS account IDs, for use in /role/{account}/{role name}
+	AccountAliases map[string]string
+
+	// RoleChains maps chain names to a list of role ARNs. Each role is assumed with the credentials of the previous role.
+	RoleChains map[string][]string
+
+	// CredentialProcesses maps names to commands which print credentials in the credential_process JSON format,
+	// for use in /process/{name}
+	CredentialProcesses map[string]string
+
+	// WebIdentities maps names to roles which are assumed with a web identity token, for use in /web-identity/{name}
+	WebIdentities map[string]WebIdentityConfig
+
+	// RolesAnywhere maps names to X.509 certificates which are exchanged for credentials with IAM Roles Anywhere,
+	// for use in /roles-anywhere/{name}
+	RolesAnywhere map[string]RolesAnywhereConfig
+
+	// FederationPolicies maps names to session policies which down-scope the credentials from /creds
+	// with GetFederationToken, selected with /creds?policy={name} or a label on the caller container
+	FederationPolicies map[string]FederationPolicyConfig
+}
+
+// RoleConfig customizes the AssumeRole requests made for a role.
+// SessionName, SourceIdentity and the values of SessionTags are Go templates; see docs/features.md.
+type RoleConfig struct {
+	SessionName       string
+	SourceIdentity    string
+	SessionTags       map[string]string
+	TransitiveTagKeys []string
+
+	// DurationSeconds is capped by the role's maximum session duration when it is known
+	DurationSeconds int64
+	ExternalID      string `json:"ExternalId"`
+	// Policy is an inline session policy, given either as a JSON object or as a string
+	Policy     json.RawMessage
+	PolicyArns []string
+}
+
+// WebIdentityConfig configures the AssumeRoleWithWebIdentity requests made for a web identity.
+// The token is read from exactly one of TokenFile and ContainerTokenFile.
+type WebIdentityConfig struct {
+	RoleArn string
+	// TokenFile is the path of the token in the Local Endpoints container
+	TokenFile string
+	// ContainerTokenFile is the path of the token in the container which requests credentials
+	ContainerTokenFile string
+
+	SessionName     string
+	DurationSeconds int64
+}
+
+// RolesAnywhereConfig configures the IAM Roles Anywhere CreateSession requests made for a certificate
+type RolesAnywhereConfig struct {
+	// Certificate is the path of a PEM file in the Local Endpoints container. Any certificates after the first
+	// are sent as the chain of intermediate CAs.
+	Certificate string
+	// PrivateKey is the path of a PEM file with the certificate's RSA or EC private key
+	PrivateKey string
+
+	TrustAnchorArn string
+	ProfileArn     string
+	RoleArn        string
+
+	SessionName     string
+	DurationSeconds int64
+}
+
+// FederationPolicyConfig configures the GetFederationToken requests made for a session policy.
+// At least one of PolicyFile and PolicyArns must be set, since a federated user has no permissions without them.
+type FederationPolicyConfig struct {
+	// PolicyFile is the path of an inline session policy document in the Local Endpoints container
+	PolicyFile string
+	PolicyArns []string
+
+	// Name is the name of the federated user, which defaults to ecs-local-{policy name}
+	Name            string
+	DurationSeconds int64
+}
+
+// LoadCredentialsConfig reads the credentials configuration file; an empty path results in an empty configuration
+func LoadCredentialsConfig(path string) (*CredentialsConfig, error) {
+	credsConfig := &CredentialsConfig{}
+	if path == "" {
+		return credsConfig, nil
+	}
+
+	bits, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read credentials configuration file")
+	}
+
+	err = json.Unmarshal(bits, credsConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse credentials configuration file %s", path)
+	}
+
+	return credsConfig, nil
+}
@@ -0,0 +1,309 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Labels on the caller container which customize AssumeRole requests
+const (
+	sessionNameLabel       = "ecs-local.session-name"
+	sourceIdentityLabel    = "ecs-local.source-identity"
+	sessionTagLabelPrefix  = "ecs-local.session-tag."
+	transitiveTagKeysLabel = "ecs-local.transitive-tag-keys"
+
+	composeServiceNameLabel = "com.docker.compose.service"
+)
+
+const (
+	defaultSessionNameTemplate = "ecs-local-{{.RoleName}}"
+	sourceIdentityLength       = 64
+)
+
+// characters which are not allowed in role session names and source identities
+var invalidSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// sessionTemplateData is made available to the session name, source identity and session tag templates
+type sessionTemplateData struct {
+	RoleName       string
+	RoleArn        string
+	ContainerName  string
+	ContainerID    string
+	ComposeProject string
+	ComposeService string
+}
+
+// roleSettings merges the settings for a role: labels on the caller container take precedence
+// over the settings for the role in the credentials configuration, which take precedence over the defaults
+func (service *CredentialService) roleSettings(roleArn, roleName string, caller *types.Container) config.RoleConfig {
+	settings := service.credsConfig.RoleDefaults
+	if roleConfig, ok := service.credsConfig.Roles[roleArn]; ok {
+		settings = mergeRoleConfig(settings, roleConfig)
+	} else if roleConfig, ok := service.credsConfig.Roles[roleName]; ok {
+		settings = mergeRoleConfig(settings, roleConfig)
+	}
+
+	if caller != nil {
+		settings = mergeRoleConfig(settings, roleConfigFromLabels(caller.Labels))
+	}
+
+	return settings
+}
+
+// mergeRoleConfig returns base with every setting that is present in override replaced
+func mergeRoleConfig(base, override config.RoleConfig) config.RoleConfig {
+	if override.SessionName != "" {
+		base.SessionName = override.SessionName
+	}
+	if override.SourceIdentity != "" {
+		base.SourceIdentity = override.SourceIdentity
+	}
+	if len(override.SessionTags) > 0 {
+		tags := make(map[string]string)
+		for key, value := range base.SessionTags {
+			tags[key] = value
+		}
+		for key, value := range override.SessionTags {
+			tags[key] = value
+		}
+		base.SessionTags = tags
+	}
+	if len(override.TransitiveTagKeys) > 0 {
+		base.TransitiveTagKeys = override.TransitiveTagKeys
+	}
+	if override.DurationSeconds != 0 {
+		base.DurationSeconds = override.DurationSeconds
+	}
+	if override.ExternalID != "" {
+		base.ExternalID = override.ExternalID
+	}
+	if len(override.Policy) > 0 {
+		base.Policy = override.Policy
+	}
+	if len(override.PolicyArns) > 0 {
+		base.PolicyArns = override.PolicyArns
+	}
+	return base
+}
+
+func roleConfigFromLabels(labels map[string]string) config.RoleConfig {
+	roleConfig := config.RoleConfig{
+		SessionName:    labels[sessionNameLabel],
+		SourceIdentity: labels[sourceIdentityLabel],
+	}
+
+	for label, value := range labels {
+		if strings.HasPrefix(label, sessionTagLabelPrefix) {
+			if roleConfig.SessionTags == nil {
+				roleConfig.SessionTags = make(map[string]string)
+			}
+			roleConfig.SessionTags[strings.TrimPrefix(label, sessionTagLabelPrefix)] = value
+		}
+	}
+
+	if keys := labels[transitiveTagKeysLabel]; keys != "" {
+		for _, key := range strings.Split(keys, ",") {
+			roleConfig.TransitiveTagKeys = append(roleConfig.TransitiveTagKeys, strings.TrimSpace(key))
+		}
+	}
+
+	return roleConfig
+}
+
+// newAssumeRoleInput builds the AssumeRole request for a role, as customized for the caller container.
+// maxSessionDuration is the role's maximum session duration in seconds, or 0 if it is not known.
+func (service *CredentialService) newAssumeRoleInput(roleArn, roleName string, caller *types.Container, maxSessionDuration int64) (*sts.AssumeRoleInput, error) {
+	settings := service.roleSettings(roleArn, roleName, caller)
+	data := newSessionTemplateData(roleArn, roleName, caller)
+
+	sessionNameTemplate := settings.SessionName
+	if sessionNameTemplate == "" {
+		sessionNameTemplate = defaultSessionNameTemplate
+	}
+	sessionName, err := renderSessionTemplate(sessionNameTemplate, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid session name")
+	}
+
+	if maxSessionDuration == 0 && settings.DurationSeconds > temporaryCredentialsDurationInS {
+		maxSessionDuration = service.roleMaxSessionDuration(roleArn, roleName)
+	}
+
+	input := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleArn),
+		DurationSeconds: aws.Int64(sessionDuration(settings.DurationSeconds, maxSessionDuration)),
+		RoleSessionName: aws.String(utils.Truncate(sanitizeSessionName(sessionName), roleSessionNameLength)),
+	}
+
+	if settings.ExternalID != "" {
+		input.ExternalId = aws.String(settings.ExternalID)
+	}
+
+	if len(settings.Policy) > 0 {
+		policy, err := sessionPolicy(settings.Policy)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid session policy")
+		}
+		input.Policy = aws.String(policy)
+	}
+
+	for _, policyArn := range settings.PolicyArns {
+		input.PolicyArns = append(input.PolicyArns, &sts.PolicyDescriptorType{
+			Arn: aws.String(policyArn),
+		})
+	}
+
+	if settings.SourceIdentity != "" {
+		sourceIdentity, err := renderSessionTemplate(settings.SourceIdentity, data)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid source identity")
+		}
+		input.SourceIdentity = aws.String(utils.Truncate(sanitizeSessionName(sourceIdentity), sourceIdentityLength))
+	}
+
+	// sort the tags, so that the same settings always result in the same request
+	var keys []string
+	for key := range settings.SessionTags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := renderSessionTemplate(settings.SessionTags[key], data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value for session tag %s", key)
+		}
+		input.Tags = append(input.Tags, &sts.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(value),
+		})
+	}
+
+	if len(settings.TransitiveTagKeys) > 0 {
+		input.TransitiveTagKeys = aws.StringSlice(settings.TransitiveTagKeys)
+	}
+
+	return input, nil
+}
+
+// sessionDuration returns the configured duration, capped by the role's maximum session duration if it is known
+func sessionDuration(configured, maxSessionDuration int64) int64 {
+	if configured == 0 {
+		configured = temporaryCredentialsDurationInS
+	}
+	if maxSessionDuration > 0 && configured > maxSessionDuration {
+		logrus.Debugf("Session duration of %d seconds exceeds the role's maximum session duration, using %d seconds", configured, maxSessionDuration)
+		return maxSessionDuration
+	}
+	return configured
+}
+
+// maxSessionDurations caches the maximum session durations of roles, which are looked up with iam:GetRole
+type maxSessionDurations struct {
+	lock      sync.Mutex
+	durations map[string]int64
+}
+
+// roleMaxSessionDuration returns the role's maximum session duration, for when the role was requested by ARN.
+// iam:GetRole can only find roles in the account of the base credentials, so if the role can not be found,
+// one hour is returned, which every role allows.
+func (service *CredentialService) roleMaxSessionDuration(roleArn, roleName string) int64 {
+	cache := service.maxSessionDurations
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if duration, ok := cache.durations[roleArn]; ok {
+		return duration
+	}
+
+	duration := int64(temporaryCredentialsDurationInS)
+	iamClient, _, err := service.baseClients()
+	if err != nil {
+		return duration
+	}
+	output, err := iamClient.GetRole(&iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil {
+		logrus.Warnf("Failed to look up the maximum session duration of %s, so sessions are limited to %d seconds: %s", roleArn, duration, err)
+	} else if aws.StringValue(output.Role.Arn) != roleArn {
+		logrus.Warnf("Role %s is not in the account of the base credentials, so sessions are limited to %d seconds", roleArn, duration)
+	} else if maxSessionDuration := aws.Int64Value(output.Role.MaxSessionDuration); maxSessionDuration > 0 {
+		duration = maxSessionDuration
+	}
+
+	cache.durations[roleArn] = duration
+	return duration
+}
+
+// sessionPolicy returns the policy document, which may be configured either as a JSON object or as a string
+func sessionPolicy(raw json.RawMessage) (string, error) {
+	var policy string
+	if err := json.Unmarshal(raw, &policy); err == nil {
+		return policy, nil
+	}
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func newSessionTemplateData(roleArn, roleName string, caller *types.Container) sessionTemplateData {
+	data := sessionTemplateData{
+		RoleName: roleName,
+		RoleArn:  roleArn,
+	}
+	if caller != nil {
+		data.ContainerName = containerName(caller)
+		data.ContainerID = utils.Truncate(caller.ID, 12)
+		data.ComposeProject = caller.Labels[composeProjectNameLabel]
+		data.ComposeService = caller.Labels[composeServiceNameLabel]
+	}
+	return data
+}
+
+func renderSessionTemplate(text string, data sessionTemplateData) (string, error) {
+	tmpl, err := template.New("session").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// sanitizeSessionName replaces the characters which STS does not accept in session names
+func sanitizeSessionName(name string) string {
+	return invalidSessionNameChars.ReplaceAllString(name, "-")
+}
@@ -0,0 +1,218 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAssumeRoleInputDefaults(t *testing.T) {
+	credsService := NewCredentialServiceWithClients(nil, nil, nil, nil)
+
+	input, err := credsService.newAssumeRoleInput(roleARN, roleName, nil, 0)
+	assert.NoError(t, err, "Unexpected error building AssumeRole input")
+	assert.Equal(t, roleARN, aws.StringValue(input.RoleArn), "Expected role ARN to match")
+	assert.Equal(t, "ecs-local-"+roleName, aws.StringValue(input.RoleSessionName), "Expected session name to match")
+	assert.Nil(t, input.SourceIdentity, "Expected no source identity")
+	assert.Empty(t, input.Tags, "Expected no session tags")
+	assert.Empty(t, input.TransitiveTagKeys, "Expected no transitive tag keys")
+}
+
+func TestNewAssumeRoleInputFromConfig(t *testing.T) {
+	credsService := NewCredentialServiceWithClients(nil, nil, nil, nil)
+	credsService.credsConfig = &config.CredentialsConfig{
+		RoleDefaults: config.RoleConfig{
+			SessionTags: map[string]string{
+				"team": "clyde",
+				"env":  "local",
+			},
+		},
+		Roles: map[string]config.RoleConfig{
+			roleName: {
+				SessionName:       "{{.ComposeProject}}.{{.ComposeService}}",
+				SourceIdentity:    "clyde@example.com",
+				SessionTags:       map[string]string{"env": "test"},
+				TransitiveTagKeys: []string{"team"},
+			},
+		},
+	}
+
+	caller := testingutils.BaseDockerContainer(containerName1, longID1).WithComposeProject(projectName).Get()
+
+	input, err := credsService.newAssumeRoleInput(roleARN, roleName, &caller, 0)
+	assert.NoError(t, err, "Unexpected error building AssumeRole input")
+	assert.Equal(t, "project.ecs-local", aws.StringValue(input.RoleSessionName), "Expected session name to match")
+	assert.Equal(t, "clyde@example.com", aws.StringValue(input.SourceIdentity), "Expected source identity to match")
+	assert.Equal(t, []*sts.Tag{
+		{Key: aws.String("env"), Value: aws.String("test")},
+		{Key: aws.String("team"), Value: aws.String("clyde")},
+	}, input.Tags, "Expected session tags to match")
+	assert.Equal(t, []string{"team"}, aws.StringValueSlice(input.TransitiveTagKeys), "Expected transitive tag keys to match")
+}
+
+func TestNewAssumeRoleInputFromLabels(t *testing.T) {
+	credsService := NewCredentialServiceWithClients(nil, nil, nil, nil)
+	credsService.credsConfig = &config.CredentialsConfig{
+		Roles: map[string]config.RoleConfig{
+			roleARN: {
+				SessionName: "from-config",
+				SessionTags: map[string]string{"env": "test"},
+			},
+		},
+	}
+
+	caller := testingutils.BaseDockerContainer(containerName1, longID1).
+		WithComposeProject(projectName).
+		WithLabel(sessionNameLabel, "{{.ContainerName}} {{.ContainerID}}").
+		WithLabel(sourceIdentityLabel, "{{.ComposeService}}").
+		WithLabel(sessionTagLabelPrefix+"service", "{{.ComposeService}}").
+		WithLabel(transitiveTagKeysLabel, "service, env").
+		Get()
+
+	input, err := credsService.newAssumeRoleInput(roleARN, roleName, &caller, 0)
+	assert.NoError(t, err, "Unexpected error building AssumeRole input")
+	assert.Equal(t, containerName1+"-"+shortID1, aws.StringValue(input.RoleSessionName), "Expected session name to match")
+	assert.Equal(t, "ecs-local", aws.StringValue(input.SourceIdentity), "Expected source identity to match")
+	assert.Equal(t, []*sts.Tag{
+		{Key: aws.String("env"), Value: aws.String("test")},
+		{Key: aws.String("service"), Value: aws.String("ecs-local")},
+	}, input.Tags, "Expected session tags to match")
+	assert.Equal(t, []string{"service", "env"}, aws.StringValueSlice(input.TransitiveTagKeys), "Expected transitive tag keys to match")
+}
+
+func TestNewAssumeRoleInputParameters(t *testing.T) {
+	credsService := NewCredentialServiceWithClients(nil, nil, nil, nil)
+	credsService.credsConfig = &config.CredentialsConfig{
+		Roles: map[string]config.RoleConfig{
+			roleName: {
+				DurationSeconds: 900,
+				ExternalID:      "clyde-external-id",
+				Policy:          []byte(`{ "Version": "2012-10-17", "Statement": [] }`),
+				PolicyArns:      []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+			},
+		},
+	}
+
+	input, err := credsService.newAssumeRoleInput(roleARN, roleName, nil, 3600)
+	assert.NoError(t, err, "Unexpected error building AssumeRole input")
+	assert.Equal(t, int64(900), aws.Int64Value(input.DurationSeconds), "Expected duration to match")
+	assert.Equal(t, "clyde-external-id", aws.StringValue(input.ExternalId), "Expected external ID to match")
+	assert.Equal(t, `{"Version":"2012-10-17","Statement":[]}`, aws.StringValue(input.Policy), "Expected policy to match")
+	assert.Equal(t, []*sts.PolicyDescriptorType{
+		{Arn: aws.String("arn:aws:iam::aws:policy/ReadOnlyAccess")},
+	}, input.PolicyArns, "Expected policy ARNs to match")
+}
+
+func TestNewAssumeRoleInputPolicyString(t *testing.T) {
+	credsService := NewCredentialServiceWithClients(nil, nil, nil, nil)
+	credsService.credsConfig = &config.CredentialsConfig{
+		RoleDefaults: config.RoleConfig{
+			Policy: []byte(`"{\"Version\":\"2012-10-17\"}"`),
+		},
+	}
+
+	input, err := credsService.newAssumeRoleInput(roleARN, roleName, nil, 0)
+	assert.NoError(t, err, "Unexpected error building AssumeRole input")
+	assert.Equal(t, `{"Version":"2012-10-17"}`, aws.StringValue(input.Policy), "Expected policy to match")
+}
+
+func TestSessionDuration(t *testing.T) {
+	var testCases = []struct {
+		name               string
+		configured         int64
+		maxSessionDuration int64
+		expected           int64
+	}{
+		{
+			name:     "default",
+			expected: 3600,
+		},
+		{
+			name:       "configured without a known maximum",
+			configured: 43200,
+			expected:   43200,
+		},
+		{
+			name:               "configured within the maximum",
+			configured:         7200,
+			maxSessionDuration: 43200,
+			expected:           7200,
+		},
+		{
+			name:               "capped by the maximum",
+			configured:         43200,
+			maxSessionDuration: 7200,
+			expected:           7200,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, sessionDuration(testCase.configured, testCase.maxSessionDuration), "Expected duration to match")
+		})
+	}
+}
+
+func TestNewAssumeRoleInputInvalidTemplate(t *testing.T) {
+	credsService := NewCredentialServiceWithClients(nil, nil, nil, nil)
+	credsService.credsConfig = &config.CredentialsConfig{
+		RoleDefaults: config.RoleConfig{
+			SessionName: "{{.Clyde}}",
+		},
+	}
+
+	_, err := credsService.newAssumeRoleInput(roleARN, roleName, nil, 0)
+	assert.Error(t, err, "Expected error for a session name template with an unknown field")
+}
+
+func TestGetRoleCredentialsSendsSessionTags(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.credsConfig = &config.CredentialsConfig{
+		RoleDefaults: config.RoleConfig{
+			SourceIdentity: "clyde",
+			SessionTags:    map[string]string{"team": "clyde"},
+		},
+	}
+
+	expiration := time.Now().Add(time.Hour)
+
+	stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+		assert.Equal(t, "clyde", aws.StringValue(input.SourceIdentity), "Expected source identity to match")
+		assert.Len(t, input.Tags, 1, "Expected one session tag")
+	}).Return(&sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil)
+
+	_, err := credsService.getRoleCredentialsFromArn(roleARN, roleName, nil, 0)
+	assert.NoError(t, err, "Unexpected error calling getRoleCredentialsFromArn")
+}
+
+func TestSanitizeSessionName(t *testing.T) {
+	assert.Equal(t, "clyde-the-cat_1+=,.@-", sanitizeSessionName("clyde the/cat_1+=,.@-"), "Expected invalid characters to be replaced")
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// AuditRecord is a line in the audit log, written for each credentials request. It never contains secrets.
+type AuditRecord struct {
+	Time           time.Time
+	CallerIP       string `json:",omitempty"`
+	ContainerName  string `json:",omitempty"`
+	ContainerID    string `json:",omitempty"`
+	ComposeProject string `json:",omitempty"`
+	Path           string
+	Status         int
+	RoleArn        string `json:",omitempty"`
+	SessionName    string `json:",omitempty"`
+	AccessKeyID    string `json:"AccessKeyId,omitempty"`
+	Expiration     string `json:",omitempty"`
+	Error          string `json:",omitempty"`
+
+	// caller is the container which made the request, once a handler has looked it up
+	caller         *types.Container
+	callerLookedUp bool
+}
+
+// auditRecordKey is the request context key of the record for a credentials request
+type auditRecordKey struct{}
+
+// auditLog writes audit records as JSON lines
+type auditLog struct {
+	lock    sync.Mutex
+	writers []io.Writer
+}
+
+// newAuditLog returns the audit log configured by AUDIT_LOG_PATH and AUDIT_LOG_STDOUT, or nil if it is not enabled
+func newAuditLog() (*auditLog, error) {
+	log := &auditLog{}
+	if path := utils.GetValue("", config.AuditLogPathVar); path != "" {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open audit log %s", path)
+		}
+		logrus.Infof("Writing an audit log of credentials requests to %s", path)
+		log.writers = append(log.writers, file)
+	}
+	if utils.GetValue("", config.AuditLogStdoutVar) == "true" {
+		log.writers = append(log.writers, os.Stdout)
+	}
+
+	if len(log.writers) == 0 {
+		return nil, nil
+	}
+	return log, nil
+}
+
+// write appends the record to every writer; a failure to write is logged, and does not fail the request
+func (log *auditLog) write(record *AuditRecord) {
+	bits, err := json.Marshal(record)
+	if err != nil {
+		logrus.Errorf("Failed to marshal audit record: %s", err)
+		return
+	}
+	bits = append(bits, '\n')
+
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	for _, writer := range log.writers {
+		if _, err := writer.Write(bits); err != nil {
+			logrus.Errorf("Failed to write audit record: %s", err)
+		}
+	}
+}
+
+// audit wraps a credentials handler so that a record of each request, and the credentials or the error it resulted in,
+// is written to the audit log
+func (service *CredentialService) audit(handler func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if service.auditLog == nil {
+			return handler(w, r)
+		}
+
+		record := &AuditRecord{
+			Time:     time.Now().UTC(),
+			CallerIP: getCallerIP(r),
+			Path:     r.URL.Path,
+			Status:   http.StatusOK,
+		}
+		r = r.WithContext(context.WithValue(r.Context(), auditRecordKey{}, record))
+		err := handler(w, r)
+		if err != nil {
+			record.Status, _ = errorStatus(err)
+			record.Error = err.Error()
+		}
+
+		// reuses the container found by the handler, so that Docker is only asked once per request
+		if caller := service.lookupCallerContainer(r); caller != nil {
+			record.ContainerName = containerName(caller)
+			record.ContainerID = caller.ID
+			record.ComposeProject = caller.Labels[composeProjectNameLabel]
+		}
+		service.auditLog.write(record)
+		return err
+	}
+}
+
+// auditCredentials adds the credentials vended in response to the request to its audit record, if it has one
+func auditCredentials(r *http.Request, response *CredentialResponse) {
+	record, ok := r.Context().Value(auditRecordKey{}).(*AuditRecord)
+	if !ok {
+		return
+	}
+	record.RoleArn = response.RoleArn
+	record.SessionName = response.sessionName
+	record.AccessKeyID = response.AccessKeyID
+	record.Expiration = response.Expiration
+}
+
+// auditCaller adds the container which made the request to its audit record, if it has one
+func auditCaller(r *http.Request, caller *types.Container) {
+	record, ok := r.Context().Value(auditRecordKey{}).(*AuditRecord)
+	if !ok {
+		return
+	}
+	record.caller = caller
+	record.callerLookedUp = true
+}
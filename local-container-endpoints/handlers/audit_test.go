@@ -0,0 +1,128 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker/mock_docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func readAuditRecords(t *testing.T, bits []byte) []*AuditRecord {
+	var records []*AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(bits)), "\n") {
+		record := &AuditRecord{}
+		assert.NoError(t, json.Unmarshal([]byte(line), record), "Unexpected error parsing audit record")
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditCredentialsRequests(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+	service := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+	buf := &bytes.Buffer{}
+	service.auditLog = &auditLog{writers: []io.Writer{buf}}
+	router := mux.NewRouter()
+	service.SetupRoutes(router)
+
+	caller := testingutils.BaseDockerContainer(containerName1, longID1).WithComposeProject(projectName).WithNetwork(network1, ipAddress1).Get()
+	// the handler and the audit log share one lookup of the caller for each request
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{caller}, nil).Times(2)
+	var sessionName string
+	stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+		sessionName = aws.StringValue(input.RoleSessionName)
+	}).Return(assumeRoleOutput(time.Now().Add(time.Hour)), nil)
+
+	for _, path := range []string{"/role-arn/" + roleARN, "/role-chain/undefined"} {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.RemoteAddr = ipAddress1 + ":34567"
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	assert.NotContains(t, buf.String(), secretKey, "Expected no secret keys in the audit log")
+	assert.NotContains(t, buf.String(), sessionToken, "Expected no session tokens in the audit log")
+
+	records := readAuditRecords(t, buf.Bytes())
+	assert.Len(t, records, 2, "Expected a record for each request")
+	for _, record := range records {
+		assert.Equal(t, ipAddress1, record.CallerIP, "Expected caller IP to match")
+		assert.Equal(t, containerName1, record.ContainerName, "Expected container name to match")
+		assert.Equal(t, longID1, record.ContainerID, "Expected container ID to match")
+		assert.Equal(t, projectName, record.ComposeProject, "Expected Compose project to match")
+	}
+
+	success := records[0]
+	assert.Equal(t, "/role-arn/"+roleARN, success.Path, "Expected path to match")
+	assert.Equal(t, http.StatusOK, success.Status, "Expected status code 200")
+	assert.Equal(t, roleARN, success.RoleArn, "Expected role ARN to match")
+	assert.Equal(t, sessionName, success.SessionName, "Expected session name to match")
+	assert.Equal(t, accessKey, success.AccessKeyID, "Expected access key to match")
+	assert.NotEmpty(t, success.Expiration, "Expected an expiration")
+	assert.Empty(t, success.Error, "Expected no error")
+
+	failure := records[1]
+	assert.Equal(t, "/role-chain/undefined", failure.Path, "Expected path to match")
+	assert.Equal(t, http.StatusNotFound, failure.Status, "Expected status code 404")
+	assert.NotEmpty(t, failure.Error, "Expected the error")
+	assert.Empty(t, failure.AccessKeyID, "Expected no access key")
+}
+
+func TestAuditLogDisabled(t *testing.T) {
+	auditLog, err := newAuditLog()
+	assert.NoError(t, err, "Unexpected error creating audit log")
+	assert.Nil(t, auditLog, "Expected no audit log by default")
+}
+
+func TestAuditLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	os.Setenv(config.AuditLogPathVar, path)
+	defer os.Unsetenv(config.AuditLogPathVar)
+	auditLog, err := newAuditLog()
+	assert.NoError(t, err, "Unexpected error creating audit log")
+
+	// records are appended
+	auditLog.write(&AuditRecord{Path: "/creds", Status: http.StatusOK, AccessKeyID: accessKey})
+	auditLog.write(&AuditRecord{Path: "/creds", Status: http.StatusInternalServerError, Error: "Some API Error"})
+
+	bits, err := ioutil.ReadFile(path)
+	assert.NoError(t, err, "Unexpected error reading audit log")
+	records := readAuditRecords(t, bits)
+	assert.Len(t, records, 2, "Expected two records")
+	assert.Equal(t, accessKey, records[0].AccessKeyID, "Expected access key to match")
+	assert.Equal(t, "Some API Error", records[1].Error, "Expected error to match")
+}
@@ -0,0 +1,194 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const authorizationHeader = "Authorization"
+
+// authorization holds the tokens which callers must present in the Authorization header,
+// as the SDKs do when AWS_CONTAINER_AUTHORIZATION_TOKEN(_FILE) is set.
+// An empty authorization allows every request.
+type authorization struct {
+	// token is required for all credentials requests
+	token string
+	// roleTokens are required for particular roles, and take precedence over token
+	roleTokens map[string]string
+}
+
+// newAuthorization reads the authorization token from the environment and the per role
+// tokens from the credentials configuration
+func newAuthorization(credsConfig *config.CredentialsConfig) (*authorization, error) {
+	auth := &authorization{
+		token:      utils.GetValue("", config.AuthorizationTokenVar),
+		roleTokens: credsConfig.AuthorizationTokens,
+	}
+
+	if tokenFile := utils.GetValue("", config.AuthorizationTokenFileVar); tokenFile != "" {
+		if auth.token != "" {
+			return nil, fmt.Errorf("Only one of %s and %s may be set", config.AuthorizationTokenVar, config.AuthorizationTokenFileVar)
+		}
+		bits, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read authorization token file")
+		}
+		auth.token = strings.TrimSpace(string(bits))
+	}
+
+	if auth.token != "" || len(auth.roleTokens) > 0 {
+		logrus.Info("Credentials requests must present an authorization token")
+	}
+
+	return auth, nil
+}
+
+// expectedToken returns the token required to obtain credentials for the role, if any
+func (auth *authorization) expectedToken(roleNameOrArns ...string) string {
+	if token, ok := auth.roleToken(roleNameOrArns...); ok {
+		return token
+	}
+	return auth.token
+}
+
+// roleToken returns the token for the first of the role names or ARNs which has its own token
+func (auth *authorization) roleToken(roleNameOrArns ...string) (string, bool) {
+	for _, role := range roleNameOrArns {
+		if token, ok := auth.roleTokens[role]; ok {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+// roleArnKeys returns the ARNs with tokens for roles with the given name, in the given account unless it is empty,
+// so that a token for a role ARN also protects the role when it is requested by name. They are matched by name,
+// since resolving the ARN would mean calling AWS before the request is authorized.
+func (auth *authorization) roleArnKeys(account, roleName string) []string {
+	if auth == nil {
+		return nil
+	}
+
+	var keys []string
+	for key := range auth.roleTokens {
+		parsed, err := arn.Parse(key)
+		if err == nil && roleNameFromArn(key) == roleName && (account == "" || parsed.AccountID == account) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// requireAuthorization wraps a credentials handler so that requests without the expected
+// Authorization header are rejected
+func (service *CredentialService) requireAuthorization(handler func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var err error
+		vars := mux.Vars(r)
+		if vars["chain"] != "" {
+			err = service.checkRoleChainAuthorization(r, service.credsConfig.RoleChains[vars["chain"]])
+		} else {
+			err = service.checkAuthorization(r, service.requestRoleKeys(vars)...)
+		}
+		if err != nil {
+			return err
+		}
+		return handler(w, r)
+	}
+}
+
+// requestRoleKeys returns the names and ARNs of the role requested by the path, or of the role configured for the
+// web identity or IAM Roles Anywhere profile it names, which may have their own token
+func (service *CredentialService) requestRoleKeys(vars map[string]string) []string {
+	var roles []string
+	if vars["roleArn"] != "" {
+		if roleArn, roleName, err := parseRoleArn(vars["roleArn"]); err == nil {
+			roles = append(roles, roleArn, roleName)
+		}
+	} else if vars["account"] != "" {
+		roles = append(service.authorization.roleArnKeys(service.aliasAccount(vars["account"]), vars["role"]), vars["role"])
+	} else if vars["role"] != "" {
+		roles = append(service.authorization.roleArnKeys("", vars["role"]), vars["role"])
+	} else if vars["identity"] != "" {
+		if roleArn := service.credsConfig.WebIdentities[vars["identity"]].RoleArn; roleArn != "" {
+			roles = append(roles, roleArn, roleNameFromArn(roleArn))
+		}
+	} else if vars["profile"] != "" {
+		if roleArn := service.credsConfig.RolesAnywhere[vars["profile"]].RoleArn; roleArn != "" {
+			roles = append(roles, roleArn, roleNameFromArn(roleArn))
+		}
+	}
+	return roles
+}
+
+// checkAuthorization returns an error if the request does not present the token required for the roles
+func (service *CredentialService) checkAuthorization(r *http.Request, roleNameOrArns ...string) error {
+	if service.authorization == nil {
+		return nil
+	}
+
+	return checkToken(r, service.authorization.expectedToken(roleNameOrArns...))
+}
+
+// checkRoleChainAuthorization returns an error if the request does not present the token of every role in the chain
+// which has its own token, or, if none of them do, the token required for all credentials requests
+func (service *CredentialService) checkRoleChainAuthorization(r *http.Request, roleArns []string) error {
+	if service.authorization == nil {
+		return nil
+	}
+
+	protected := false
+	for _, roleArn := range roleArns {
+		if token, ok := service.authorization.roleToken(roleArn, roleNameFromArn(roleArn)); ok {
+			protected = true
+			if err := checkToken(r, token); err != nil {
+				return err
+			}
+		}
+	}
+	if protected {
+		return nil
+	}
+	return checkToken(r, service.authorization.token)
+}
+
+// checkToken returns an error if the request does not present the expected token, unless no token is expected
+func checkToken(r *http.Request, expected string) error {
+	if expected == "" {
+		return nil
+	}
+
+	actual := r.Header.Get(authorizationHeader)
+	if subtle.ConstantTimeCompare([]byte(actual), []byte(expected)) != 1 {
+		return HTTPError{
+			Code: http.StatusUnauthorized,
+			Err:  fmt.Errorf("Missing or invalid %s header for %s", authorizationHeader, r.URL.Path),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	authToken     = "clyde-token"
+	roleAuthToken = "clyde-role-token"
+)
+
+func TestRequireAuthorization(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		auth           *authorization
+		path           string
+		header         string
+		expectedStatus int
+	}{
+		{
+			name:           "no token configured",
+			auth:           &authorization{},
+			path:           "/role/" + roleName,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing token",
+			auth:           &authorization{token: authToken},
+			path:           "/creds",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong token",
+			auth:           &authorization{token: authToken},
+			path:           "/creds",
+			header:         "tum-tum",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "matching token",
+			auth:           &authorization{token: authToken},
+			path:           "/creds",
+			header:         authToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "role token takes precedence",
+			auth: &authorization{
+				token:      authToken,
+				roleTokens: map[string]string{roleName: roleAuthToken},
+			},
+			path:           "/role/" + roleName,
+			header:         authToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "matching role token",
+			auth: &authorization{
+				token:      authToken,
+				roleTokens: map[string]string{roleName: roleAuthToken},
+			},
+			path:           "/role/" + roleName,
+			header:         roleAuthToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "matching role ARN token",
+			auth: &authorization{
+				roleTokens: map[string]string{roleARN: roleAuthToken},
+			},
+			path:           "/role-arn/" + roleARN,
+			header:         roleAuthToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "matching role name token for a role with a path",
+			auth: &authorization{
+				roleTokens: map[string]string{roleName: roleAuthToken},
+			},
+			path:           "/role-arn/arn:aws:iam::111111111111:role/service-role/" + roleName,
+			header:         authToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "missing role ARN token for a role requested by name",
+			auth: &authorization{
+				token:      authToken,
+				roleTokens: map[string]string{roleARN: roleAuthToken},
+			},
+			path:           "/role/" + roleName,
+			header:         authToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "matching role ARN token for a role requested by name",
+			auth: &authorization{
+				token:      authToken,
+				roleTokens: map[string]string{roleARN: roleAuthToken},
+			},
+			path:           "/role/" + roleName,
+			header:         roleAuthToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "matching role ARN token for a role with a path requested by name",
+			auth: &authorization{
+				roleTokens: map[string]string{"arn:aws:iam::111111111111:role/service-role/" + roleName: roleAuthToken},
+			},
+			path:           "/role/" + roleName,
+			header:         roleAuthToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "role token for another role",
+			auth: &authorization{
+				roleTokens: map[string]string{"pudding": roleAuthToken},
+			},
+			path:           "/role/" + roleName,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			service := &CredentialService{
+				authorization: testCase.auth,
+			}
+			handler := ServeHTTP(service.requireAuthorization(func(w http.ResponseWriter, r *http.Request) error {
+				w.WriteHeader(http.StatusOK)
+				return nil
+			}))
+
+			router := mux.NewRouter()
+			router.HandleFunc(config.RoleCredentialsPath, handler)
+			router.HandleFunc(config.RoleArnCredentialsPath, handler)
+			router.HandleFunc(config.TempCredentialsPath, handler)
+
+			req := httptest.NewRequest(http.MethodGet, testCase.path, nil)
+			if testCase.header != "" {
+				req.Header.Set(authorizationHeader, testCase.header)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			assert.Equal(t, testCase.expectedStatus, recorder.Code, "Expected status code to match")
+		})
+	}
+}
+
+func TestRequireAuthorizationForRoleChain(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		roleTokens     map[string]string
+		header         string
+		expectedStatus int
+	}{
+		{
+			name:           "global token for chain without role tokens",
+			header:         authToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "global token for chain ending in a role with its own token",
+			roleTokens:     map[string]string{workloadRoleARN: roleAuthToken},
+			header:         authToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "global token for chain passing through a role with its own token",
+			roleTokens:     map[string]string{"hub": roleAuthToken},
+			header:         authToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "matching role token",
+			roleTokens:     map[string]string{hubRoleARN: roleAuthToken},
+			header:         roleAuthToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "one of the role tokens",
+			roleTokens:     map[string]string{hubRoleARN: roleAuthToken, workloadRoleARN: "pudding"},
+			header:         roleAuthToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			service := &CredentialService{
+				authorization: &authorization{
+					token:      authToken,
+					roleTokens: testCase.roleTokens,
+				},
+				credsConfig: &config.CredentialsConfig{
+					RoleChains: map[string][]string{
+						chainName: {hubRoleARN, workloadRoleARN},
+					},
+				},
+			}
+			router := mux.NewRouter()
+			router.HandleFunc(config.RoleChainCredentialsPath, ServeHTTP(service.requireAuthorization(func(w http.ResponseWriter, r *http.Request) error {
+				w.WriteHeader(http.StatusOK)
+				return nil
+			})))
+
+			req := httptest.NewRequest(http.MethodGet, "/role-chain/"+chainName, nil)
+			req.Header.Set(authorizationHeader, testCase.header)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			assert.Equal(t, testCase.expectedStatus, recorder.Code, "Expected status code to match")
+		})
+	}
+}
+
+func TestNewAuthorizationFromTokenFile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := ioutil.WriteFile(tokenFile, []byte(authToken+"\n"), 0600)
+	assert.NoError(t, err, "Unexpected error writing token file")
+
+	os.Setenv(config.AuthorizationTokenFileVar, tokenFile)
+	defer os.Unsetenv(config.AuthorizationTokenFileVar)
+
+	auth, err := newAuthorization(&config.CredentialsConfig{})
+	assert.NoError(t, err, "Unexpected error creating authorization")
+	assert.Equal(t, authToken, auth.expectedToken(roleName), "Expected token to be read from the file")
+}
+
+func TestNewAuthorizationTokenAndFileConflict(t *testing.T) {
+	os.Setenv(config.AuthorizationTokenVar, authToken)
+	defer os.Unsetenv(config.AuthorizationTokenVar)
+	os.Setenv(config.AuthorizationTokenFileVar, "/token")
+	defer os.Unsetenv(config.AuthorizationTokenFileVar)
+
+	_, err := newAuthorization(&config.CredentialsConfig{})
+	assert.Error(t, err, "Expected error when both token and token file are set")
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials/processcreds"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// getProcessHandler returns a handler which vends the credentials printed by a configured credential process
+func (service *CredentialService) getProcessHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received credential process request")
+
+		response, err := service.getProcessCredentials(mux.Vars(r)["process"])
+		if err != nil {
+			return err
+		}
+
+		auditCredentials(r, response)
+		writeJSONResponse(w, response)
+		return nil
+	}
+}
+
+// getProcessCredentials runs the command configured for the process, which prints credentials in the credential_process
+// JSON format, and caches them until they expire. Credentials without an expiration are cached for SHARED_TOKEN_EXPIRATION.
+func (service *CredentialService) getProcessCredentials(name string) (*CredentialResponse, error) {
+	command := service.credsConfig.CredentialProcesses[name]
+	if command == "" {
+		return nil, HTTPError{
+			Code: http.StatusNotFound,
+			Err:  fmt.Errorf("Credential process %s is not defined in the credentials configuration", name),
+		}
+	}
+
+	return service.cache.get(fmt.Sprintf("process:%s", name), func() (*CredentialResponse, time.Time, error) {
+		logrus.Debugf("Running credential process %s", name)
+		creds := processcreds.NewCredentials(command)
+		value, err := creds.Get()
+		if err != nil {
+			return nil, time.Time{}, errors.Wrapf(err, "credential process %s failed", name)
+		}
+
+		expiration, err := creds.ExpiresAt()
+		if err != nil || expiration.IsZero() {
+			if expiration, err = getSharedTokenExpiration(); err != nil {
+				return nil, time.Time{}, err
+			}
+		}
+
+		return &CredentialResponse{
+			AccessKeyID:     value.AccessKeyID,
+			SecretAccessKey: value.SecretAccessKey,
+			Token:           value.SessionToken,
+			Expiration:      expiration.Format(CredentialExpirationTimeFormat),
+		}, expiration, nil
+	})
+}
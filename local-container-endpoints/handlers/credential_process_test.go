@@ -0,0 +1,112 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// newProcessServiceInTest returns a service with credential processes which print credentials from a file,
+// and record each run in a log file
+func newProcessServiceInTest(t *testing.T, dir string) *CredentialService {
+	iamMock, stsMock := setupMocks(t)
+	service := newCredentialServiceInTest(iamMock, stsMock)
+
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	outputs := map[string]string{
+		"broker":    fmt.Sprintf(`{"Version": 1, "AccessKeyId": "%s", "SecretAccessKey": "%s", "SessionToken": "%s", "Expiration": "%s"}`, accessKey, secretKey, sessionToken, expiration),
+		"static":    fmt.Sprintf(`{"Version": 1, "AccessKeyId": "%s", "SecretAccessKey": "%s"}`, accessKey, secretKey),
+		"malformed": `not json`,
+	}
+
+	service.credsConfig = &config.CredentialsConfig{
+		CredentialProcesses: map[string]string{},
+	}
+	for name, output := range outputs {
+		outputFile := filepath.Join(dir, name+".json")
+		assert.NoError(t, ioutil.WriteFile(outputFile, []byte(output), 0644), "Unexpected error writing process output")
+		service.credsConfig.CredentialProcesses[name] = fmt.Sprintf("echo run >> %s; cat %s", filepath.Join(dir, name+".log"), outputFile)
+	}
+	service.credsConfig.CredentialProcesses["failing"] = "exit 1"
+	return service
+}
+
+func processRuns(t *testing.T, dir, name string) int {
+	bits, err := ioutil.ReadFile(filepath.Join(dir, name+".log"))
+	assert.NoError(t, err, "Unexpected error reading process log")
+	return strings.Count(string(bits), "run")
+}
+
+func TestGetProcessCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "process")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	service := newProcessServiceInTest(t, dir)
+
+	// the process is only run once while its credentials are valid
+	for i := 0; i < 2; i++ {
+		response, err := service.getProcessCredentials("broker")
+		assert.NoError(t, err, "Unexpected error calling getProcessCredentials")
+		assert.Equal(t, accessKey, response.AccessKeyID, "Expected access key to match")
+		assert.Equal(t, secretKey, response.SecretAccessKey, "Expected secret key to match")
+		assert.Equal(t, sessionToken, response.Token, "Expected session token to match")
+		assert.NotEmpty(t, response.Expiration, "Expected an expiration")
+	}
+	assert.Equal(t, 1, processRuns(t, dir, "broker"), "Expected the process to run once")
+}
+
+func TestGetProcessCredentialsWithoutExpiration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "process")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	service := newProcessServiceInTest(t, dir)
+
+	response, err := service.getProcessCredentials("static")
+	assert.NoError(t, err, "Unexpected error calling getProcessCredentials")
+	expiration, err := time.Parse(CredentialExpirationTimeFormat, response.Expiration)
+	assert.NoError(t, err, "Unexpected error parsing expiration")
+	assert.True(t, expiration.After(time.Now()), "Expected an expiration in the future")
+}
+
+func TestGetProcessHandlerErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "process")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	service := newProcessServiceInTest(t, dir)
+
+	router := mux.NewRouter()
+	service.SetupRoutes(router)
+
+	for process, status := range map[string]int{
+		"undefined": http.StatusNotFound,
+		"malformed": http.StatusInternalServerError,
+		"failing":   http.StatusInternalServerError,
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/process/"+process, nil))
+		assert.Equal(t, status, recorder.Code, "Expected status code for %s", process)
+	}
+}
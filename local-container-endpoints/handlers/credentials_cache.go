@@ -0,0 +1,179 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// credentialsFetcher retrieves a fresh set of credentials along with the time at which they expire
+type credentialsFetcher func() (*CredentialResponse, time.Time, error)
+
+// credentialsCache stores credentials by key so that many containers asking for the same
+// role do not each result in a call to STS.
+//
+// Credentials are handed out from the cache until they reach their refresh time, which is
+// refreshMargin before they expire. Once that point is passed, callers still get the cached
+// credentials while a single background refresh replaces them. Expired credentials are never
+// returned; instead the caller waits on a fetch, which is shared by all concurrent callers.
+//
+// Keys include the session name and tags of each container, so entries which have expired or
+// failed to fetch are removed, rather than kept for containers which may never ask again.
+type credentialsCache struct {
+	lock          sync.Mutex
+	entries       map[string]*cacheEntry
+	refreshMargin time.Duration
+	now           func() time.Time
+	nextPrune     time.Time
+}
+
+// cachePruneInterval is how often expired entries are removed from the cache
+const cachePruneInterval = time.Minute
+
+type cacheEntry struct {
+	creds      *CredentialResponse
+	expiration time.Time
+	refreshAt  time.Time
+	inflight   *inflightFetch
+}
+
+// inflightFetch is a fetch in progress; done is closed once creds and err are set
+type inflightFetch struct {
+	done  chan struct{}
+	creds *CredentialResponse
+	err   error
+}
+
+func newCredentialsCache(refreshMargin time.Duration) *credentialsCache {
+	return &credentialsCache{
+		entries:       make(map[string]*cacheEntry),
+		refreshMargin: refreshMargin,
+		now:           time.Now,
+	}
+}
+
+// get returns the cached credentials for key, calling fetch if they are missing or expired
+func (cache *credentialsCache) get(key string, fetch credentialsFetcher) (*CredentialResponse, error) {
+	cache.lock.Lock()
+	entry, ok := cache.entries[key]
+	if !ok {
+		entry = &cacheEntry{}
+		cache.entries[key] = entry
+	}
+
+	now := cache.now()
+	if !now.Before(cache.nextPrune) {
+		cache.prune(now, key)
+		cache.nextPrune = now.Add(cachePruneInterval)
+	}
+
+	if entry.creds != nil && now.Before(entry.expiration) {
+		creds := entry.creds
+		if !now.Before(entry.refreshAt) && entry.inflight == nil {
+			logrus.Debugf("Refreshing cached credentials for %s in the background", key)
+			go cache.wait(cache.startFetch(key, entry, fetch))
+		}
+		cache.lock.Unlock()
+		return creds, nil
+	}
+
+	inflight := entry.inflight
+	if inflight == nil {
+		inflight = cache.startFetch(key, entry, fetch)
+	}
+	cache.lock.Unlock()
+
+	return cache.wait(inflight)
+}
+
+// clear drops every cached credential
+func (cache *credentialsCache) clear() {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.entries = make(map[string]*cacheEntry)
+}
+
+// prune removes every expired entry other than key which is not being fetched; it must be called with the lock held
+func (cache *credentialsCache) prune(now time.Time, key string) {
+	for k, entry := range cache.entries {
+		if k != key && entry.inflight == nil && !now.Before(entry.expiration) {
+			delete(cache.entries, k)
+		}
+	}
+}
+
+// clearPrefix drops the cached credentials whose keys begin with prefix
+func (cache *credentialsCache) clearPrefix(prefix string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	for key := range cache.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(cache.entries, key)
+		}
+	}
+}
+
+// startFetch must be called with the lock held
+func (cache *credentialsCache) startFetch(key string, entry *cacheEntry, fetch credentialsFetcher) *inflightFetch {
+	inflight := &inflightFetch{
+		done: make(chan struct{}),
+	}
+	entry.inflight = inflight
+
+	go func() {
+		creds, expiration, err := fetch()
+		fetchedAt := cache.now()
+
+		cache.lock.Lock()
+		entry.inflight = nil
+		if err == nil {
+			entry.creds = creds
+			entry.expiration = expiration
+			entry.refreshAt = cache.refreshTime(fetchedAt, expiration)
+		} else {
+			logrus.Debugf("Failed to fetch credentials for %s: %s", key, err)
+			// a failed background refresh keeps the credentials which are still valid
+			if (entry.creds == nil || !fetchedAt.Before(entry.expiration)) && cache.entries[key] == entry {
+				delete(cache.entries, key)
+			}
+		}
+		cache.lock.Unlock()
+
+		inflight.creds = creds
+		inflight.err = err
+		close(inflight.done)
+	}()
+
+	return inflight
+}
+
+func (cache *credentialsCache) wait(inflight *inflightFetch) (*CredentialResponse, error) {
+	<-inflight.done
+	return inflight.creds, inflight.err
+}
+
+// refreshTime is refreshMargin before expiration, but never earlier than halfway through
+// the lifetime of the credentials, so that short lived sessions are still cached
+func (cache *credentialsCache) refreshTime(fetchedAt, expiration time.Time) time.Time {
+	refreshAt := expiration.Add(-cache.refreshMargin)
+	halfway := fetchedAt.Add(expiration.Sub(fetchedAt) / 2)
+	if refreshAt.Before(halfway) {
+		return halfway
+	}
+	return refreshAt
+}
@@ -0,0 +1,197 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const cacheKey = "role:clyde_task_role"
+
+func newCacheInTest(margin time.Duration, now time.Time) (*credentialsCache, *time.Time) {
+	cache := newCredentialsCache(margin)
+	clock := now
+	cache.now = func() time.Time {
+		return clock
+	}
+	return cache, &clock
+}
+
+func countingFetcher(calls *int32, expiration time.Time) credentialsFetcher {
+	return func() (*CredentialResponse, time.Time, error) {
+		n := atomic.AddInt32(calls, 1)
+		return &CredentialResponse{
+			AccessKeyID: fmt.Sprintf("%s%d", accessKey, n),
+		}, expiration, nil
+	}
+}
+
+func TestCredentialsCacheReturnsCachedCredentials(t *testing.T) {
+	start := time.Now()
+	cache, _ := newCacheInTest(20*time.Minute, start)
+
+	var calls int32
+	fetch := countingFetcher(&calls, start.Add(time.Hour))
+
+	first, err := cache.get(cacheKey, fetch)
+	assert.NoError(t, err, "Unexpected error getting credentials")
+	second, err := cache.get(cacheKey, fetch)
+	assert.NoError(t, err, "Unexpected error getting credentials")
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Expected credentials to be fetched once")
+	assert.Equal(t, first, second, "Expected cached credentials to be returned")
+}
+
+func TestCredentialsCacheCollapsesConcurrentRequests(t *testing.T) {
+	start := time.Now()
+	cache, _ := newCacheInTest(20*time.Minute, start)
+
+	var calls int32
+	release := make(chan struct{})
+	fetch := func() (*CredentialResponse, time.Time, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &CredentialResponse{AccessKeyID: accessKey}, start.Add(time.Hour), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			creds, err := cache.get(cacheKey, fetch)
+			assert.NoError(t, err, "Unexpected error getting credentials")
+			assert.Equal(t, accessKey, creds.AccessKeyID, "Expected access key to match")
+		}()
+	}
+
+	// give every goroutine a chance to block on the fetch before letting it finish
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Expected credentials to be fetched once")
+}
+
+func TestCredentialsCacheRefreshesInBackground(t *testing.T) {
+	start := time.Now()
+	cache, clock := newCacheInTest(20*time.Minute, start)
+
+	var calls int32
+	fetch := countingFetcher(&calls, start.Add(time.Hour))
+
+	_, err := cache.get(cacheKey, fetch)
+	assert.NoError(t, err, "Unexpected error getting credentials")
+
+	// inside the refresh margin: the cached credentials are still handed out
+	*clock = start.Add(45 * time.Minute)
+	creds, err := cache.get(cacheKey, fetch)
+	assert.NoError(t, err, "Unexpected error getting credentials")
+	assert.Equal(t, accessKey+"1", creds.AccessKeyID, "Expected cached credentials while refreshing")
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 2
+	}, time.Second, 10*time.Millisecond, "Expected a background refresh")
+}
+
+func TestCredentialsCacheRefetchesExpiredCredentials(t *testing.T) {
+	start := time.Now()
+	cache, clock := newCacheInTest(20*time.Minute, start)
+
+	var calls int32
+	fetch := countingFetcher(&calls, start.Add(time.Hour))
+
+	_, err := cache.get(cacheKey, fetch)
+	assert.NoError(t, err, "Unexpected error getting credentials")
+
+	*clock = start.Add(2 * time.Hour)
+	creds, err := cache.get(cacheKey, fetch)
+	assert.NoError(t, err, "Unexpected error getting credentials")
+	assert.Equal(t, accessKey+"2", creds.AccessKeyID, "Expected new credentials once expired")
+}
+
+func TestCredentialsCacheDoesNotCacheErrors(t *testing.T) {
+	cache, _ := newCacheInTest(20*time.Minute, time.Now())
+
+	var calls int32
+	fetch := func() (*CredentialResponse, time.Time, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, time.Time{}, fmt.Errorf("Some API Error")
+	}
+
+	_, err := cache.get(cacheKey, fetch)
+	assert.Error(t, err, "Expected error getting credentials")
+	_, err = cache.get(cacheKey, fetch)
+	assert.Error(t, err, "Expected error getting credentials")
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "Expected failed fetches to be retried")
+}
+
+func TestCredentialsCacheRemovesFailedAndExpiredEntries(t *testing.T) {
+	start := time.Now()
+	cache, clock := newCacheInTest(20*time.Minute, start)
+
+	_, err := cache.get(cacheKey, func() (*CredentialResponse, time.Time, error) {
+		return nil, time.Time{}, fmt.Errorf("Some API Error")
+	})
+	assert.Error(t, err, "Expected error getting credentials")
+	assert.Len(t, cache.entries, 0, "Expected the failed entry to be removed")
+
+	var calls int32
+	for i := 0; i < 3; i++ {
+		_, err = cache.get(fmt.Sprintf("%s-%d", cacheKey, i), countingFetcher(&calls, start.Add(time.Hour)))
+		assert.NoError(t, err, "Unexpected error getting credentials")
+	}
+	assert.Len(t, cache.entries, 3, "Expected an entry for each key")
+
+	*clock = start.Add(2 * time.Hour)
+	_, err = cache.get(cacheKey, countingFetcher(&calls, clock.Add(time.Hour)))
+	assert.NoError(t, err, "Unexpected error getting credentials")
+	assert.Len(t, cache.entries, 1, "Expected the expired entries to be removed")
+}
+
+func TestCredentialsCacheRefreshTime(t *testing.T) {
+	start := time.Now()
+	cache, _ := newCacheInTest(20*time.Minute, start)
+
+	var testCases = []struct {
+		name     string
+		lifetime time.Duration
+		expected time.Duration
+	}{
+		{
+			name:     "margin before expiration",
+			lifetime: time.Hour,
+			expected: 40 * time.Minute,
+		},
+		{
+			name:     "halfway for short sessions",
+			lifetime: 15 * time.Minute,
+			expected: 450 * time.Second,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual := cache.refreshTime(start, start.Add(testCase.lifetime))
+			assert.Equal(t, start.Add(testCase.expected), actual, "Expected refresh time to match")
+		})
+	}
+}
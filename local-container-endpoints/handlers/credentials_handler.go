@@ -16,19 +16,29 @@ package handlers
 import (
 	"fmt"
 	"net/http"
-	"strconv"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sso"
+	"github.com/aws/aws-sdk-go/service/ssooidc"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/offline"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/rolesanywhere"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/useragent"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -37,6 +47,8 @@ import (
 const (
 	temporaryCredentialsDurationInS = 3600
 	roleSessionNameLength           = 64
+
+	sessionTokenCacheKey = "session-token"
 )
 
 const (
@@ -46,74 +58,247 @@ const (
 
 // CredentialService vends credentials to containers
 type CredentialService struct {
+	// clientsLock guards the base session and the clients built from it, which are replaced
+	// when the base credentials are reloaded
+	clientsLock    sync.RWMutex
 	iamClient      iamiface.IAMAPI
 	stsClient      stsiface.STSAPI
 	currentSession *session.Session
+	newClients     clientFactory
+	// newSession creates the base session from the default credential chain
+	newSession func() (*session.Session, error)
+
+	dockerClient   docker.Client
+	cache          *credentialsCache
+	authorization  *authorization
+	credsConfig    *config.CredentialsConfig
+	mfa            *mfaSession
+	roleResolution string
+	identity       *callerIdentity
+	// maxSessionDurations caches the maximum session durations of roles requested by ARN
+	maxSessionDurations *maxSessionDurations
+	offline             *offline.Client
+	profiles            *profileSessions
+	sso                 *ssoLogin
+	rolesAnywhere       rolesanywhere.API
+	auditLog            *auditLog
 }
 
 // NewCredentialService returns a struct that handles credentials requests
 func NewCredentialService() (*CredentialService, error) {
-	iamCustomEndpoint := utils.GetValue("", config.IAMCustomEndpointVar)
-	if iamCustomEndpoint != "" {
-		logrus.Infof("Using custom IAM endpoint %s", iamCustomEndpoint)
+	var service *CredentialService
+	var err error
+	if utils.GetValue("", config.OfflineModeVar) == "true" {
+		service, err = newOfflineCredentialService()
+	} else {
+		service, err = newAWSCredentialService()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	service.dockerClient, err = docker.NewDockerClient()
+	if err != nil {
+		return nil, err
 	}
 
-	stsCustomEndpoint := utils.GetValue("", config.STSCustomEndpointVar)
-	if stsCustomEndpoint != "" {
-		logrus.Infof("Using custom STS endpoint %s", stsCustomEndpoint)
+	credsConfig, err := config.LoadCredentialsConfig(utils.GetValue("", config.CredentialsConfigPathVar))
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := newAuthorization(credsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	service.auditLog, err = newAuditLog()
+	if err != nil {
+		return nil, err
+	}
+
+	service.authorization = auth
+	service.credsConfig = credsConfig
+	service.mfa = newMFASession()
+
+	if interval := getCredentialsReloadInterval(); service.newSession != nil && interval > 0 {
+		service.watchBaseCredentials(interval)
+	}
+	return service, nil
+}
+
+// newAWSCredentialService returns a service with clients for IAM and STS which use the default credentials
+func newAWSCredentialService() (*CredentialService, error) {
+	sessionConfig := newAWSSessionConfig()
+	newSession := func() (*session.Session, error) {
+		return session.NewSessionWithOptions(session.Options{
+			Config:            withHTTPClient(sessionConfig),
+			SharedConfigState: session.SharedConfigEnable,
+		})
+	}
+	sess, err := newSession()
+	if err != nil {
+		return nil, err
+	}
+
+	service := NewCredentialServiceWithClients(nil, nil, nil, nil)
+	service.setSession(sess)
+	service.newSession = newSession
+	service.profiles = newProfileSessions(func(profile string) (*session.Session, error) {
+		return session.NewSessionWithOptions(session.Options{
+			Config:            withHTTPClient(sessionConfig),
+			Profile:           profile,
+			SharedConfigState: session.SharedConfigEnable,
+		})
+	})
+	service.sso = newSSOLogin(sessionConfig, func() {
+		service.reloadBaseCredentials()
+	})
+	service.rolesAnywhere = rolesanywhere.New(sessionConfig.EndpointResolver)
+	return service, nil
+}
+
+// setSession replaces the base session, and the IAM and STS clients built from it
+func (service *CredentialService) setSession(sess *session.Session) {
+	service.clientsLock.Lock()
+	defer service.clientsLock.Unlock()
+
+	service.currentSession = sess
+	service.iamClient = newIAMClient(sess)
+	service.stsClient = newSTSClient(sess)
+	service.newClients = func(creds *credentials.Credentials) (iamiface.IAMAPI, stsiface.STSAPI) {
+		clientSession := sess.Copy(&aws.Config{
+			Credentials: creds,
+		})
+		return newIAMClient(clientSession), newSTSClient(clientSession)
+	}
+}
+
+// clients returns the IAM and STS clients which use the base credentials, and the base session
+func (service *CredentialService) clients() (iamiface.IAMAPI, stsiface.STSAPI, *session.Session) {
+	service.clientsLock.RLock()
+	defer service.clientsLock.RUnlock()
+	return service.iamClient, service.stsClient, service.currentSession
+}
+
+// clientsWithCredentials returns IAM and STS clients which use the given credentials
+func (service *CredentialService) clientsWithCredentials(creds *credentials.Credentials) (iamiface.IAMAPI, stsiface.STSAPI) {
+	service.clientsLock.RLock()
+	newClients := service.newClients
+	service.clientsLock.RUnlock()
+	return newClients(creds)
+}
+
+// newAWSSessionConfig returns the configuration for AWS sessions, which uses the custom IAM, STS, SSO, SSO OIDC,
+// and IAM Roles Anywhere endpoints, if any
+func newAWSSessionConfig() aws.Config {
+	customEndpoints := make(map[string]string)
+	for _, endpoint := range []struct {
+		service, name, variable string
+	}{
+		{endpoints.IamServiceID, "IAM", config.IAMCustomEndpointVar},
+		{endpoints.StsServiceID, "STS", config.STSCustomEndpointVar},
+		{sso.EndpointsID, "SSO", config.SSOCustomEndpointVar},
+		{ssooidc.EndpointsID, "SSO OIDC", config.SSOOIDCCustomEndpointVar},
+		{rolesanywhere.EndpointsID, "IAM Roles Anywhere", config.RolesAnywhereCustomEndpointVar},
+	} {
+		if url := utils.GetValue("", endpoint.variable); url != "" {
+			logrus.Infof("Using custom %s endpoint %s", endpoint.name, url)
+			customEndpoints[endpoint.service] = url
+		}
 	}
 
 	defaultResolver := endpoints.DefaultResolver()
 	customResolverFn := func(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
-		if service == endpoints.IamServiceID && iamCustomEndpoint != "" {
+		if url, ok := customEndpoints[service]; ok {
 			return endpoints.ResolvedEndpoint{
-				URL: iamCustomEndpoint,
-			}, nil
-		} else if service == endpoints.StsServiceID && stsCustomEndpoint != "" {
-			return endpoints.ResolvedEndpoint{
-				URL: stsCustomEndpoint,
+				URL: url,
 			}, nil
 		}
 		return defaultResolver.EndpointFor(service, region, optFns...)
 	}
 
-	sess, err := session.NewSessionWithOptions(session.Options{
-		Config: aws.Config{
-			EndpointResolver:              endpoints.ResolverFunc(customResolverFn),
-			CredentialsChainVerboseErrors: aws.Bool(true),
-		},
-		SharedConfigState: session.SharedConfigEnable,
-	})
-	if err != nil {
-		return nil, err
+	return aws.Config{
+		EndpointResolver:              endpoints.ResolverFunc(customResolverFn),
+		CredentialsChainVerboseErrors: aws.Bool(true),
 	}
+}
 
+// withHTTPClient returns the session configuration with an HTTP client of its own. The SDK sets the transport of the
+// client when it loads AWS_CA_BUNDLE, so a session which is created while requests are in flight must not share one.
+func withHTTPClient(sessionConfig aws.Config) aws.Config {
+	sessionConfig.HTTPClient = &http.Client{}
+	return sessionConfig
+}
+
+func newIAMClient(sess *session.Session) *iam.IAM {
 	iamClient := iam.New(sess)
 	iamClient.Handlers.Build.PushBackNamed(useragent.CustomUserAgentHandler())
+	return iamClient
+}
+
+func newSTSClient(sess *session.Session) *sts.STS {
 	stsClient := sts.New(sess)
 	stsClient.Handlers.Build.PushBackNamed(useragent.CustomUserAgentHandler())
-	return NewCredentialServiceWithClients(iamClient, stsClient, sess), nil
+	return stsClient
 }
 
 // NewCredentialServiceWithClients returns a struct that handles credentials requests with the given clients
-func NewCredentialServiceWithClients(iamClient iamiface.IAMAPI, stsClient stsiface.STSAPI, currentSession *session.Session) *CredentialService {
+func NewCredentialServiceWithClients(iamClient iamiface.IAMAPI, stsClient stsiface.STSAPI, dockerClient docker.Client, currentSession *session.Session) *CredentialService {
 	return &CredentialService{
 		iamClient:      iamClient,
 		stsClient:      stsClient,
+		dockerClient:   dockerClient,
 		currentSession: currentSession,
+		cache:          newCredentialsCache(getCredentialsRefreshMargin()),
+		credsConfig:    &config.CredentialsConfig{},
+		roleResolution: getRoleResolution(),
+		identity:       &callerIdentity{},
+		maxSessionDurations: &maxSessionDurations{
+			durations: make(map[string]int64),
+		},
 	}
 }
 
 // SetupRoutes sets up the credentials paths in mux
 func (service *CredentialService) SetupRoutes(router *mux.Router) {
-	router.HandleFunc(config.RoleCredentialsPath, ServeHTTP(service.getRoleHandler()))
-	router.HandleFunc(config.RoleCredentialsPathWithSlash, ServeHTTP(service.getRoleHandler()))
+	router.HandleFunc(config.RoleCredentialsPath, ServeHTTP(service.audit(service.requireSSOSession(service.requireAuthorization(service.getRoleHandler())))))
+	router.HandleFunc(config.RoleCredentialsPathWithSlash, ServeHTTP(service.audit(service.requireSSOSession(service.requireAuthorization(service.getRoleHandler())))))
+
+	router.HandleFunc(config.RoleAccountCredentialsPath, ServeHTTP(service.audit(service.requireSSOSession(service.requireAuthorization(service.getAccountRoleHandler())))))
+	router.HandleFunc(config.RoleAccountCredentialsPathWithSlash, ServeHTTP(service.audit(service.requireSSOSession(service.requireAuthorization(service.getAccountRoleHandler())))))
+
+	router.HandleFunc(config.RoleArnCredentialsPath, ServeHTTP(service.audit(service.requireSSOSession(service.requireAuthorization(service.getRoleArnHandler())))))
+	router.HandleFunc(config.RoleArnCredentialsPathWithSlash, ServeHTTP(service.audit(service.requireSSOSession(service.requireAuthorization(service.getRoleArnHandler())))))
 
-	router.HandleFunc(config.RoleArnCredentialsPath, ServeHTTP(service.getRoleArnHandler()))
-	router.HandleFunc(config.RoleArnCredentialsPathWithSlash, ServeHTTP(service.getRoleArnHandler()))
+	router.HandleFunc(config.RoleChainCredentialsPath, ServeHTTP(service.audit(service.requireSSOSession(service.requireAuthorization(service.getRoleChainHandler())))))
+	router.HandleFunc(config.RoleChainCredentialsPathWithSlash, ServeHTTP(service.audit(service.requireSSOSession(service.requireAuthorization(service.getRoleChainHandler())))))
 
-	router.HandleFunc(config.TempCredentialsPath, ServeHTTP(service.getTemporaryCredentialHandler()))
-	router.HandleFunc(config.TempCredentialsPathWithSlash, ServeHTTP(service.getTemporaryCredentialHandler()))
+	router.HandleFunc(config.TaskRoleCredentialsPath, ServeHTTP(service.audit(service.requireSSOSession(service.getTaskRoleHandler()))))
+	router.HandleFunc(config.TaskRoleCredentialsPathWithSlash, ServeHTTP(service.audit(service.requireSSOSession(service.getTaskRoleHandler()))))
+
+	router.HandleFunc(config.PodIdentityCredentialsPath, ServeHTTP(service.audit(service.requireSSOSession(service.getPodIdentityHandler()))))
+	router.HandleFunc(config.PodIdentityCredentialsPathWithSlash, ServeHTTP(service.audit(service.requireSSOSession(service.getPodIdentityHandler()))))
+
+	router.HandleFunc(config.ProfileCredentialsPath, ServeHTTP(service.audit(service.requireSSOSession(service.requireAuthorization(service.getProfileHandler())))))
+	router.HandleFunc(config.ProfileCredentialsPathWithSlash, ServeHTTP(service.audit(service.requireSSOSession(service.requireAuthorization(service.getProfileHandler())))))
+
+	router.HandleFunc(config.ProcessCredentialsPath, ServeHTTP(service.audit(service.requireAuthorization(service.getProcessHandler()))))
+	router.HandleFunc(config.ProcessCredentialsPathWithSlash, ServeHTTP(service.audit(service.requireAuthorization(service.getProcessHandler()))))
+
+	router.HandleFunc(config.WebIdentityCredentialsPath, ServeHTTP(service.audit(service.requireAuthorization(service.getWebIdentityHandler()))))
+	router.HandleFunc(config.WebIdentityCredentialsPathWithSlash, ServeHTTP(service.audit(service.requireAuthorization(service.getWebIdentityHandler()))))
+
+	router.HandleFunc(config.RolesAnywhereCredentialsPath, ServeHTTP(service.audit(service.requireAuthorization(service.getRolesAnywhereHandler()))))
+	router.HandleFunc(config.RolesAnywhereCredentialsPathWithSlash, ServeHTTP(service.audit(service.requireAuthorization(service.getRolesAnywhereHandler()))))
+
+	router.HandleFunc(config.TempCredentialsPath, ServeHTTP(service.audit(service.requireSSOSession(service.requireAuthorization(service.getTemporaryCredentialHandler())))))
+	router.HandleFunc(config.TempCredentialsPathWithSlash, ServeHTTP(service.audit(service.requireSSOSession(service.requireAuthorization(service.getTemporaryCredentialHandler())))))
+
+	router.HandleFunc(config.MFAAdminPath, ServeHTTP(service.requireAuthorization(service.getMFAHandler()))).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc(config.SSOLoginAdminPath, ServeHTTP(service.requireAuthorization(service.getSSOLoginHandler()))).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc(config.OfflineCredentialsAdminPath, ServeHTTP(service.requireAuthorization(service.getOfflineCredentialsHandler()))).Methods(http.MethodGet)
+	router.HandleFunc(config.OfflineCredentialsAdminPathWithAccessKey, ServeHTTP(service.requireAuthorization(service.getOfflineCredentialsHandler()))).Methods(http.MethodGet)
 }
 
 // GetRoleHandler returns the Task IAM Role handler
@@ -130,11 +315,12 @@ func (service *CredentialService) getRoleHandler() func(w http.ResponseWriter, r
 			}
 		}
 
-		response, err := service.getRoleCredentials(roleName)
+		response, err := service.getRoleCredentials(roleName, service.lookupCallerContainer(r))
 		if err != nil {
 			return err
 		}
 
+		auditCredentials(r, response)
 		writeJSONResponse(w, response)
 		return nil
 	}
@@ -146,131 +332,253 @@ func (service *CredentialService) getRoleArnHandler() func(w http.ResponseWriter
 		logrus.Debug("Received role credentials request using ARN")
 
 		vars := mux.Vars(r)
-		roleName := vars["roleName"]
-		roleArn := fmt.Sprintf("%s/%s", vars["roleArn"], roleName)
-		if roleArn == "" {
+		roleArn, roleName, err := parseRoleArn(vars["roleArn"])
+		if err != nil {
 			return HTTPError{
 				Code: http.StatusBadRequest,
-				Err:  fmt.Errorf("Invalid URL path %s; expected '/role-arn/<IAM Role ARN>", r.URL.Path),
+				Err:  errors.Wrapf(err, "Invalid URL path %s; expected '/role-arn/<IAM Role ARN>'", r.URL.Path),
 			}
 		}
 
-		response, err := service.getRoleCredentialsFromArn(roleArn, roleName)
+		response, err := service.getRoleCredentialsFromArn(roleArn, roleName, service.lookupCallerContainer(r), 0)
 		if err != nil {
 			return err
 		}
 
+		auditCredentials(r, response)
 		writeJSONResponse(w, response)
 		return nil
 	}
 }
 
-func (service *CredentialService) getRoleCredentials(roleName string) (*CredentialResponse, error) {
+// parseRoleArn validates the role ARN from a request path, which may be percent-encoded, and returns it with the role name.
+// The role name is the final segment of the ARN, since roles may have paths, e.g. arn:aws:iam::111111111111:role/service-role/app.
+func parseRoleArn(value string) (string, string, error) {
+	value = strings.TrimSuffix(value, "/")
+	if strings.Contains(value, "%") {
+		// the path is decoded once by net/http; this handles ARNs which were encoded twice
+		unescaped, err := url.PathUnescape(value)
+		if err != nil {
+			return "", "", err
+		}
+		value = unescaped
+	}
+
+	parsed, err := arn.Parse(value)
+	if err != nil {
+		return "", "", err
+	}
+	if parsed.Service != "iam" || !strings.HasPrefix(parsed.Resource, "role/") {
+		return "", "", fmt.Errorf("%s is not an IAM role ARN", value)
+	}
+
+	roleName := roleNameFromArn(value)
+	if roleName == "" {
+		return "", "", fmt.Errorf("%s does not contain a role name", value)
+	}
+	return value, roleName, nil
+}
+
+func (service *CredentialService) getRoleCredentials(roleName string, caller *types.Container) (*CredentialResponse, error) {
 	logrus.Debugf("Requesting credentials for %s", roleName)
 
-	output, err := service.iamClient.GetRole(&iam.GetRoleInput{
+	if service.roleResolution == config.RoleResolutionCallerIdentity {
+		return service.getRoleCredentialsFromCallerIdentity(roleName, caller)
+	}
+
+	iamClient, _, err := service.baseClients()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := iamClient.GetRole(&iam.GetRoleInput{
 		RoleName: aws.String(roleName),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return service.getRoleCredentialsFromArn(aws.StringValue(output.Role.Arn), roleName)
+	return service.getRoleCredentialsFromArn(aws.StringValue(output.Role.Arn), roleName, caller, aws.Int64Value(output.Role.MaxSessionDuration))
 }
 
-func (service *CredentialService) getRoleCredentialsFromArn(roleArn, roleName string) (*CredentialResponse, error) {
+// getRoleCredentialsFromArn assumes the role; caller is the container which requested credentials, if it is known,
+// and maxSessionDuration is the role's maximum session duration in seconds, or 0 if it is not known
+func (service *CredentialService) getRoleCredentialsFromArn(roleArn, roleName string, caller *types.Container, maxSessionDuration int64) (*CredentialResponse, error) {
 	logrus.Debugf("Requesting credentials for role with ARN %s", roleArn)
 
-	creds, err := service.stsClient.AssumeRole(&sts.AssumeRoleInput{
-		RoleArn:         aws.String(roleArn),
-		DurationSeconds: aws.Int64(temporaryCredentialsDurationInS),
-		RoleSessionName: aws.String(utils.Truncate(fmt.Sprintf("ecs-local-%s", roleName), roleSessionNameLength)),
-	})
+	_, stsClient, err := service.baseClients()
+	if err != nil {
+		return nil, err
+	}
 
+	input, err := service.newAssumeRoleInput(roleArn, roleName, caller, maxSessionDuration)
 	if err != nil {
 		return nil, err
 	}
 
-	return &CredentialResponse{
-		AccessKeyID:     aws.StringValue(creds.Credentials.AccessKeyId),
-		SecretAccessKey: aws.StringValue(creds.Credentials.SecretAccessKey),
-		RoleArn:         roleArn,
-		Token:           aws.StringValue(creds.Credentials.SessionToken),
-		Expiration:      creds.Credentials.Expiration.Format(CredentialExpirationTimeFormat),
-	}, nil
+	return service.assumeRole(stsClient, roleCacheKey(input), input)
+}
+
+// assumeRole returns the credentials cached under cacheKey, calling sts:AssumeRole with input if they need to be fetched
+func (service *CredentialService) assumeRole(stsClient stsiface.STSAPI, cacheKey string, input *sts.AssumeRoleInput) (*CredentialResponse, error) {
+	roleArn := aws.StringValue(input.RoleArn)
+	return service.cache.get(cacheKey, func() (*CredentialResponse, time.Time, error) {
+		logrus.Debugf("Assuming role with ARN %s", roleArn)
+		creds, err := stsClient.AssumeRole(input)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		return &CredentialResponse{
+			AccessKeyID:     aws.StringValue(creds.Credentials.AccessKeyId),
+			SecretAccessKey: aws.StringValue(creds.Credentials.SecretAccessKey),
+			RoleArn:         roleArn,
+			Token:           aws.StringValue(creds.Credentials.SessionToken),
+			Expiration:      creds.Credentials.Expiration.Format(CredentialExpirationTimeFormat),
+			sessionName:     aws.StringValue(input.RoleSessionName),
+		}, aws.TimeValue(creds.Credentials.Expiration), nil
+	})
+}
+
+// roleCacheKey identifies credentials by everything that is sent to STS to obtain them
+func roleCacheKey(input *sts.AssumeRoleInput) string {
+	return fmt.Sprintf("role:%s", input.String())
+}
+
+// lookupCallerContainer returns the container which made the request, or nil if it can not be found.
+// The caller container is optional for role credentials; it is only used to customize the request to STS.
+// It is looked up once per request, and then reused from the audit record.
+func (service *CredentialService) lookupCallerContainer(r *http.Request) *types.Container {
+	if service.dockerClient == nil {
+		return nil
+	}
+
+	if record, ok := r.Context().Value(auditRecordKey{}).(*AuditRecord); ok && record.callerLookedUp {
+		return record.caller
+	}
+
+	container, err := findCallerContainer(service.dockerClient, getCallerIP(r))
+	if err != nil {
+		logrus.Debugf("Could not find the container which requested credentials: %s", err)
+	}
+	auditCaller(r, container)
+	return container
 }
 
-// GetTemporaryCredentialHandler returns a handler which vends temporary credentials for the local IAM identity
+// GetTemporaryCredentialHandler returns a handler which vends temporary credentials for the local IAM identity,
+// down-scoped by a session policy if one is requested
 func (service *CredentialService) getTemporaryCredentialHandler() func(w http.ResponseWriter, r *http.Request) error {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		logrus.Debug("Received temporary local credentials request")
 
-		response, err := service.getTemporaryCredentials()
+		policy, err := service.federationPolicyName(r)
 		if err != nil {
 			return err
 		}
 
+		var response *CredentialResponse
+		if policy != "" {
+			response, err = service.getFederationToken(policy)
+		} else {
+			response, err = service.getTemporaryCredentials()
+		}
+		if err != nil {
+			return err
+		}
+
+		auditCredentials(r, response)
 		writeJSONResponse(w, response)
 		return nil
 	}
 }
 
 func (service *CredentialService) getTemporaryCredentials() (*CredentialResponse, error) {
+	// the MFA session was itself obtained with GetSessionToken, so hand it out as is
+	if service.mfa != nil {
+		creds, _, _, ok := service.mfa.active()
+		if !ok {
+			return nil, service.mfa.errSessionRequired()
+		}
+
+		logrus.Debug("Using the MFA session for temporary credentials")
+		return &CredentialResponse{
+			AccessKeyID:     aws.StringValue(creds.AccessKeyId),
+			SecretAccessKey: aws.StringValue(creds.SecretAccessKey),
+			Token:           aws.StringValue(creds.SessionToken),
+			Expiration:      creds.Expiration.Format(CredentialExpirationTimeFormat),
+		}, nil
+	}
+
 	// check if the current session already was built on temp creds
 	// because temp creds do not have the power to call GetSessionToken
-	if service.isCurrentSessionTemporary() {
-		credVal, err := service.currentSession.Config.Credentials.Get()
+	_, stsClient, currentSession := service.clients()
+	if isSessionTemporary(currentSession) {
+		logrus.Debug("Current session contains temporary credentials")
+		response, err := sessionCredentials(currentSession.Config.Credentials)
 		if err != nil {
 			return nil, errors.Wrap(err, "Current session is based on temporary credentials, but they were not retrieved.")
 		}
-
-		logrus.Debug("Current session contains temporary credentials")
-		response := CredentialResponse{
-			AccessKeyID:     credVal.AccessKeyID,
-			SecretAccessKey: credVal.SecretAccessKey,
-			Token:           credVal.SessionToken,
-		}
-
-		expiration, err := service.currentSession.Config.Credentials.ExpiresAt()
-
-		// It is valid for a credential provider to not return an expiration;
-		// however, we need to have an expiration if a token is present to
-		// satsify various client SDKs. In this case, we return an expiration
-		// timestamp a fixed point in the future.
-		// https://github.com/awslabs/amazon-ecs-local-container-endpoints/issues/26
-		if err != nil && len(response.Token) > 0 {
-			expiration, err = getSharedTokenExpiration()
-		}
-
-		if err == nil {
-			response.Expiration = expiration.Format(CredentialExpirationTimeFormat)
-		}
-
-		return &response, nil
+		return response, nil
 	}
 
 	// current session is not temp creds, so we can call GetSessionToken
-	creds, err := service.stsClient.GetSessionToken(&sts.GetSessionTokenInput{
-		DurationSeconds: aws.Int64(temporaryCredentialsDurationInS),
-	})
+	return service.getSessionToken(stsClient, sessionTokenCacheKey)
+}
 
+// sessionCredentials returns the temporary credentials of a session as they are
+func sessionCredentials(creds *credentials.Credentials) (*CredentialResponse, error) {
+	credVal, err := creds.Get()
 	if err != nil {
 		return nil, err
 	}
 
 	response := CredentialResponse{
-		AccessKeyID:     aws.StringValue(creds.Credentials.AccessKeyId),
-		SecretAccessKey: aws.StringValue(creds.Credentials.SecretAccessKey),
-		Token:           aws.StringValue(creds.Credentials.SessionToken),
-		Expiration:      creds.Credentials.Expiration.Format(CredentialExpirationTimeFormat),
+		AccessKeyID:     credVal.AccessKeyID,
+		SecretAccessKey: credVal.SecretAccessKey,
+		Token:           credVal.SessionToken,
+	}
+
+	expiration, err := creds.ExpiresAt()
+
+	// It is valid for a credential provider to not return an expiration;
+	// however, we need to have an expiration if a token is present to
+	// satsify various client SDKs. In this case, we return an expiration
+	// timestamp a fixed point in the future.
+	// https://github.com/awslabs/amazon-ecs-local-container-endpoints/issues/26
+	if err != nil && len(response.Token) > 0 {
+		expiration, err = getSharedTokenExpiration()
+	}
+
+	if err == nil {
+		response.Expiration = expiration.Format(CredentialExpirationTimeFormat)
 	}
 
 	return &response, nil
 }
 
-func (service *CredentialService) isCurrentSessionTemporary() bool {
-	if service.currentSession != nil && service.currentSession.Config != nil && service.currentSession.Config.Credentials != nil {
-		credVal, err := service.currentSession.Config.Credentials.Get()
+// getSessionToken returns the credentials cached under cacheKey, calling sts:GetSessionToken if they need to be fetched
+func (service *CredentialService) getSessionToken(stsClient stsiface.STSAPI, cacheKey string) (*CredentialResponse, error) {
+	return service.cache.get(cacheKey, func() (*CredentialResponse, time.Time, error) {
+		logrus.Debug("Requesting a session token")
+		creds, err := stsClient.GetSessionToken(&sts.GetSessionTokenInput{
+			DurationSeconds: aws.Int64(temporaryCredentialsDurationInS),
+		})
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		return &CredentialResponse{
+			AccessKeyID:     aws.StringValue(creds.Credentials.AccessKeyId),
+			SecretAccessKey: aws.StringValue(creds.Credentials.SecretAccessKey),
+			Token:           aws.StringValue(creds.Credentials.SessionToken),
+			Expiration:      creds.Credentials.Expiration.Format(CredentialExpirationTimeFormat),
+		}, aws.TimeValue(creds.Credentials.Expiration), nil
+	})
+}
+
+func isSessionTemporary(currentSession *session.Session) bool {
+	if currentSession != nil && currentSession.Config != nil && currentSession.Config.Credentials != nil {
+		credVal, err := currentSession.Config.Credentials.Get()
 
 		if err == nil && credVal.SessionToken != "" { // current session is already temp creds
 			return true
@@ -284,19 +592,13 @@ func (service *CredentialService) isCurrentSessionTemporary() bool {
 // reserve it for future use in case there are valid reasons to error out.
 func getSharedTokenExpiration() (time.Time, error) {
 	durationStr := utils.GetValue(fmt.Sprintf("%ds", config.DefaultSharedTokenExpiration), config.SharedTokenExpirationVar)
-	duration, err := time.ParseDuration(durationStr)
+	duration, err := utils.ParseDuration(durationStr)
 
 	if err != nil {
-		// If they didn't provide a unit, try to parse this as seconds.
-		durationSeconds, err := strconv.ParseInt(durationStr, 0, 64)
-		if err != nil {
-			logrus.Warnf(
-				"Could not parse SHARED_TOKEN_EXPIRATION value, defaulting to %d seconds: %s",
-				config.DefaultSharedTokenExpiration, durationStr)
-			durationSeconds = config.DefaultSharedTokenExpiration
-		}
-
-		duration = time.Duration(durationSeconds) * time.Second
+		logrus.Warnf(
+			"Could not parse SHARED_TOKEN_EXPIRATION value, defaulting to %d seconds: %s",
+			config.DefaultSharedTokenExpiration, durationStr)
+		duration = config.DefaultSharedTokenExpiration * time.Second
 	}
 
 	// Make sure the duration is always in the future.
@@ -309,3 +611,18 @@ func getSharedTokenExpiration() (time.Time, error) {
 
 	return time.Now().UTC().Add(duration), nil
 }
+
+// getCredentialsRefreshMargin returns how long before expiration cached credentials are refreshed
+func getCredentialsRefreshMargin() time.Duration {
+	marginStr := utils.GetValue(fmt.Sprintf("%ds", config.DefaultCredentialsRefreshMargin), config.CredentialsRefreshMarginVar)
+	margin, err := utils.ParseDuration(marginStr)
+
+	if err != nil || margin < 0 {
+		logrus.Warnf(
+			"Could not parse CREDENTIALS_REFRESH_MARGIN value, defaulting to %d seconds: %s",
+			config.DefaultCredentialsRefreshMargin, marginStr)
+		margin = config.DefaultCredentialsRefreshMargin * time.Second
+	}
+
+	return margin
+}
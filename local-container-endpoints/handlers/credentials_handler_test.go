@@ -15,6 +15,7 @@ package handlers
 
 import (
 	"fmt"
+	"net/http"
 	"testing"
 	"time"
 
@@ -25,6 +26,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/iam/mock_iamiface"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/sts/mock_stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
@@ -67,7 +69,7 @@ func TestGetRoleCredentials(t *testing.T) {
 		}, nil),
 	)
 
-	response, err := credsService.getRoleCredentials(roleName)
+	response, err := credsService.getRoleCredentials(roleName, nil)
 	assert.NoError(t, err, "Unexpected error calling getRoleCredentials")
 	assert.Equal(t, response.AccessKeyID, accessKey, "Expected access key to match")
 	assert.Equal(t, response.SecretAccessKey, secretKey, "Expected secret key to match")
@@ -77,6 +79,123 @@ func TestGetRoleCredentials(t *testing.T) {
 
 }
 
+func TestGetRoleCredentialsCached(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	expiration := time.Now().Add(time.Hour)
+
+	iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+		Role: &iam.Role{
+			Arn: aws.String(roleARN),
+		},
+	}, nil).Times(2)
+	stsMock.EXPECT().AssumeRole(gomock.Any()).Return(&sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil).Times(1)
+
+	for i := 0; i < 2; i++ {
+		response, err := credsService.getRoleCredentials(roleName, nil)
+		assert.NoError(t, err, "Unexpected error calling getRoleCredentials")
+		assert.Equal(t, response.AccessKeyID, accessKey, "Expected access key to match")
+	}
+}
+
+func TestGetRoleCredentialsCappedByMaxSessionDuration(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.credsConfig = &config.CredentialsConfig{
+		Roles: map[string]config.RoleConfig{
+			roleName: {
+				DurationSeconds: 43200,
+			},
+		},
+	}
+
+	expiration := time.Now().Add(2 * time.Hour)
+
+	gomock.InOrder(
+		iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn:                aws.String(roleARN),
+				MaxSessionDuration: aws.Int64(7200),
+			},
+		}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, int64(7200), aws.Int64Value(input.DurationSeconds), "Expected duration to be capped")
+		}).Return(&sts.AssumeRoleOutput{
+			Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String(accessKey),
+				SecretAccessKey: aws.String(secretKey),
+				SessionToken:    aws.String(sessionToken),
+				Expiration:      &expiration,
+			},
+		}, nil),
+	)
+
+	_, err := credsService.getRoleCredentials(roleName, nil)
+	assert.NoError(t, err, "Unexpected error calling getRoleCredentials")
+}
+
+func TestGetRoleCredentialsFromArnCappedByMaxSessionDuration(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+
+	otherRoleARN := "arn:aws:iam::222222222222:role/" + roleName
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.credsConfig = &config.CredentialsConfig{
+		RoleDefaults: config.RoleConfig{
+			DurationSeconds: 43200,
+		},
+	}
+
+	expiration := time.Now().Add(time.Hour)
+	output := &sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}
+
+	gomock.InOrder(
+		iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn:                aws.String(roleARN),
+				MaxSessionDuration: aws.Int64(7200),
+			},
+		}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, int64(7200), aws.Int64Value(input.DurationSeconds), "Expected duration to be capped by the role")
+		}).Return(output, nil),
+		// roles in other accounts can not be looked up, so their sessions are limited to an hour
+		iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn:                aws.String(roleARN),
+				MaxSessionDuration: aws.Int64(7200),
+			},
+		}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, int64(3600), aws.Int64Value(input.DurationSeconds), "Expected duration to be limited to an hour")
+		}).Return(output, nil),
+	)
+
+	_, err := credsService.getRoleCredentialsFromArn(roleARN, roleName, nil, 0)
+	assert.NoError(t, err, "Unexpected error calling getRoleCredentialsFromArn")
+	_, err = credsService.getRoleCredentialsFromArn(otherRoleARN, roleName, nil, 0)
+	assert.NoError(t, err, "Unexpected error calling getRoleCredentialsFromArn")
+
+	// the maximum session duration is only looked up once
+	assert.Equal(t, int64(7200), credsService.roleMaxSessionDuration(roleARN, roleName), "Expected the cached maximum session duration")
+}
+
 func TestGetRoleCredentialsGetRoleError(t *testing.T) {
 	iamMock, stsMock := setupMocks(t)
 
@@ -89,7 +208,7 @@ func TestGetRoleCredentialsGetRoleError(t *testing.T) {
 		}).Return(nil, fmt.Errorf("Some API Error")),
 	)
 
-	_, err := credsService.getRoleCredentials(roleName)
+	_, err := credsService.getRoleCredentials(roleName, nil)
 	assert.Error(t, err, "Expected error calling getRoleCredentials")
 
 }
@@ -114,11 +233,74 @@ func TestGetRoleCredentialsSTSError(t *testing.T) {
 		}).Return(nil, fmt.Errorf("Some API Error")),
 	)
 
-	_, err := credsService.getRoleCredentials(roleName)
+	_, err := credsService.getRoleCredentials(roleName, nil)
 	assert.Error(t, err, "Expected error calling getRoleCredentials")
 
 }
 
+func TestParseRoleArn(t *testing.T) {
+	var testCases = []struct {
+		name             string
+		value            string
+		expectedRoleArn  string
+		expectedRoleName string
+		expectError      bool
+	}{
+		{
+			name:             "role ARN",
+			value:            "arn:aws:iam::111111111111:role/clyde_task_role",
+			expectedRoleArn:  "arn:aws:iam::111111111111:role/clyde_task_role",
+			expectedRoleName: "clyde_task_role",
+		},
+		{
+			name:             "role ARN with a path",
+			value:            "arn:aws:iam::111111111111:role/service-role/team/app",
+			expectedRoleArn:  "arn:aws:iam::111111111111:role/service-role/team/app",
+			expectedRoleName: "app",
+		},
+		{
+			name:             "percent-encoded role ARN",
+			value:            "arn%3Aaws%3Aiam%3A%3A111111111111%3Arole%2Fservice-role%2Fapp",
+			expectedRoleArn:  "arn:aws:iam::111111111111:role/service-role/app",
+			expectedRoleName: "app",
+		},
+		{
+			name:             "trailing slash",
+			value:            "arn:aws-cn:iam::111111111111:role/app/",
+			expectedRoleArn:  "arn:aws-cn:iam::111111111111:role/app",
+			expectedRoleName: "app",
+		},
+		{
+			name:        "not an ARN",
+			value:       "clyde_task_role",
+			expectError: true,
+		},
+		{
+			name:        "not a role ARN",
+			value:       "arn:aws:iam::111111111111:user/clyde",
+			expectError: true,
+		},
+		{
+			name:        "not an IAM ARN",
+			value:       "arn:aws:s3:::role/clyde",
+			expectError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			roleArn, roleName, err := parseRoleArn(testCase.value)
+			if testCase.expectError {
+				assert.Error(t, err, "Expected error parsing role ARN")
+				return
+			}
+			assert.NoError(t, err, "Unexpected error parsing role ARN")
+			assert.Equal(t, testCase.expectedRoleArn, roleArn, "Expected role ARN to match")
+			assert.Equal(t, testCase.expectedRoleName, roleName, "Expected role name to match")
+		})
+	}
+}
+
 func TestGetTemporaryCredentials(t *testing.T) {
 	iamMock, stsMock := setupMocks(t)
 
@@ -146,6 +328,29 @@ func TestGetTemporaryCredentials(t *testing.T) {
 
 }
 
+func TestGetTemporaryCredentialsCached(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	expiration := time.Now().Add(time.Hour)
+
+	stsMock.EXPECT().GetSessionToken(gomock.Any()).Return(&sts.GetSessionTokenOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil).Times(1)
+
+	for i := 0; i < 2; i++ {
+		response, err := credsService.getTemporaryCredentials()
+		assert.NoError(t, err, "Unexpected error calling getTemporaryCredentials")
+		assert.Equal(t, response.AccessKeyID, accessKey, "Expected access key to match")
+	}
+}
+
 func TestGetTemporaryCredentialsErrorCase(t *testing.T) {
 	iamMock, stsMock := setupMocks(t)
 
@@ -213,9 +418,16 @@ func setupMocks(t *testing.T) (*mock_iamiface.MockIAMAPI, *mock_stsiface.MockSTS
 }
 
 func newCredentialServiceInTest(iamMock *mock_iamiface.MockIAMAPI, stsMock *mock_stsiface.MockSTSAPI) *CredentialService {
-	return &CredentialService{
-		stsClient:      stsMock,
-		iamClient:      iamMock,
-		currentSession: nil,
-	}
+	return NewCredentialServiceWithClients(iamMock, stsMock, nil, nil)
+}
+
+func TestSessionsHaveTheirOwnHTTPClient(t *testing.T) {
+	sessionConfig := newAWSSessionConfig()
+	first := withHTTPClient(sessionConfig)
+	second := withHTTPClient(sessionConfig)
+
+	assert.NotNil(t, first.HTTPClient, "Expected an HTTP client")
+	assert.NotSame(t, first.HTTPClient, second.HTTPClient, "Expected each session to have its own HTTP client")
+	assert.NotSame(t, http.DefaultClient, first.HTTPClient, "Expected sessions not to share the default HTTP client")
+	assert.Nil(t, sessionConfig.HTTPClient, "Expected the shared configuration to be unchanged")
 }
@@ -0,0 +1,151 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// federationPolicyLabel on the caller container selects the policy which down-scopes its /creds credentials
+	federationPolicyLabel = "ecs-local.federation-policy"
+	// federationPolicyQueryParameter selects the policy for a single /creds request from a container without the label
+	federationPolicyQueryParameter = "policy"
+
+	federatedUserNameLength = 32
+)
+
+// federationPolicyName returns the name of the policy requested for /creds, or an empty string if the full
+// base identity was requested. The caller's label is authoritative, so that a container which is limited to
+// a policy can not ask for a broader one.
+func (service *CredentialService) federationPolicyName(r *http.Request) (string, error) {
+	requested := r.URL.Query().Get(federationPolicyQueryParameter)
+	// only look up the caller when there are policies, so that /creds does not otherwise need the Docker API
+	if len(service.credsConfig.FederationPolicies) == 0 {
+		return requested, nil
+	}
+	// without the caller, its label can not be checked, so the request is refused rather than given the base identity
+	if service.dockerClient == nil {
+		return "", fmt.Errorf("Federation policies require access to the Docker API")
+	}
+	caller := service.lookupCallerContainer(r)
+	if caller == nil {
+		return "", HTTPError{
+			Code: http.StatusBadRequest,
+			Err:  fmt.Errorf("Could not find the container which made the request, so its %s label can not be checked", federationPolicyLabel),
+		}
+	}
+	if caller.Labels[federationPolicyLabel] == "" {
+		return requested, nil
+	}
+
+	labelled := caller.Labels[federationPolicyLabel]
+	if requested != "" && requested != labelled {
+		return "", HTTPError{
+			Code: http.StatusForbidden,
+			Err:  fmt.Errorf("Federation policy %s was requested, but the %s label of container %s limits it to %s", requested, federationPolicyLabel, containerName(caller), labelled),
+		}
+	}
+	return labelled, nil
+}
+
+// getFederationToken returns credentials for a federated user, which have the permissions of the base identity
+// limited by the configured session policy
+func (service *CredentialService) getFederationToken(name string) (*CredentialResponse, error) {
+	policyConfig, ok := service.credsConfig.FederationPolicies[name]
+	if !ok {
+		return nil, HTTPError{
+			Code: http.StatusNotFound,
+			Err:  fmt.Errorf("Federation policy %s is not defined in the credentials configuration", name),
+		}
+	}
+
+	input, err := newGetFederationTokenInput(name, policyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// GetFederationToken can only be called with long-term IAM user credentials
+	if service.mfa != nil {
+		return nil, fmt.Errorf("Federation policies can not be used with %s, since GetFederationToken does not accept MFA sessions", config.MFASerialVar)
+	}
+	_, stsClient, currentSession := service.clients()
+	if isSessionTemporary(currentSession) {
+		return nil, fmt.Errorf("Federation policy %s requires long-term IAM user credentials, but the base credentials are temporary", name)
+	}
+
+	return service.cache.get(fmt.Sprintf("federation:%s", input.String()), func() (*CredentialResponse, time.Time, error) {
+		logrus.Debugf("Requesting a federation token with policy %s", name)
+		output, err := stsClient.GetFederationToken(input)
+		if err != nil {
+			return nil, time.Time{}, errors.Wrapf(err, "failed to get a federation token with policy %s", name)
+		}
+
+		return &CredentialResponse{
+			AccessKeyID:     aws.StringValue(output.Credentials.AccessKeyId),
+			SecretAccessKey: aws.StringValue(output.Credentials.SecretAccessKey),
+			Token:           aws.StringValue(output.Credentials.SessionToken),
+			Expiration:      output.Credentials.Expiration.Format(CredentialExpirationTimeFormat),
+			sessionName:     aws.StringValue(input.Name),
+		}, aws.TimeValue(output.Credentials.Expiration), nil
+	})
+}
+
+// newGetFederationTokenInput reads the policy file on every request, so that edits to it take effect right away
+func newGetFederationTokenInput(name string, policyConfig config.FederationPolicyConfig) (*sts.GetFederationTokenInput, error) {
+	if policyConfig.PolicyFile == "" && len(policyConfig.PolicyArns) == 0 {
+		return nil, fmt.Errorf("Federation policy %s has neither PolicyFile nor PolicyArns", name)
+	}
+
+	userName := policyConfig.Name
+	if userName == "" {
+		userName = fmt.Sprintf("ecs-local-%s", name)
+	}
+	duration := policyConfig.DurationSeconds
+	if duration == 0 {
+		duration = temporaryCredentialsDurationInS
+	}
+	input := &sts.GetFederationTokenInput{
+		Name:            aws.String(utils.Truncate(sanitizeSessionName(userName), federatedUserNameLength)),
+		DurationSeconds: aws.Int64(duration),
+	}
+
+	if policyConfig.PolicyFile != "" {
+		bits, err := ioutil.ReadFile(policyConfig.PolicyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read the policy file for federation policy %s", name)
+		}
+		policy, err := sessionPolicy(bits)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the policy file for federation policy %s", name)
+		}
+		input.Policy = aws.String(policy)
+	}
+	for _, policyArn := range policyConfig.PolicyArns {
+		input.PolicyArns = append(input.PolicyArns, &sts.PolicyDescriptorType{
+			Arn: aws.String(policyArn),
+		})
+	}
+	return input, nil
+}
@@ -0,0 +1,255 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker/mock_docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/offline"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	readOnlyPolicyArn = "arn:aws:iam::aws:policy/ReadOnlyAccess"
+	bucketPolicy      = `{
+  "Version": "2012-10-17",
+  "Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::my-bucket/*"}]
+}`
+)
+
+func getFederationTokenOutput() *sts.GetFederationTokenOutput {
+	expiration := time.Now().Add(time.Hour)
+	return &sts.GetFederationTokenOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}
+}
+
+// federationPoliciesInTest returns a bucket policy from a file, and a managed read only policy
+func federationPoliciesInTest(t *testing.T, dir string) *config.CredentialsConfig {
+	policyFile := filepath.Join(dir, "bucket.json")
+	assert.NoError(t, ioutil.WriteFile(policyFile, []byte(bucketPolicy), 0644), "Unexpected error writing policy")
+	return &config.CredentialsConfig{
+		FederationPolicies: map[string]config.FederationPolicyConfig{
+			"bucket": {
+				PolicyFile: policyFile,
+			},
+			"readonly": {
+				PolicyArns:      []string{readOnlyPolicyArn},
+				Name:            "my-app",
+				DurationSeconds: 900,
+			},
+			"empty": {},
+		},
+	}
+}
+
+func getCredsInTest(t *testing.T, router *mux.Router, path string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	request.RemoteAddr = ipAddress1 + ":34567"
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestGetFederationTokenFromQueryParameter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "federation")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+	service := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+	service.credsConfig = federationPoliciesInTest(t, dir)
+	router := mux.NewRouter()
+	service.SetupRoutes(router)
+
+	// a container without the label may select any policy
+	caller := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).Get()
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{caller}, nil).AnyTimes()
+	gomock.InOrder(
+		stsMock.EXPECT().GetFederationToken(gomock.Any()).Do(func(input *sts.GetFederationTokenInput) {
+			assert.Equal(t, "ecs-local-bucket", aws.StringValue(input.Name), "Expected the default federated user name")
+			assert.JSONEq(t, bucketPolicy, aws.StringValue(input.Policy), "Expected the policy from the file")
+			assert.Equal(t, int64(temporaryCredentialsDurationInS), aws.Int64Value(input.DurationSeconds), "Expected the default duration")
+			assert.Empty(t, input.PolicyArns, "Expected no managed policies")
+		}).Return(getFederationTokenOutput(), nil),
+		stsMock.EXPECT().GetFederationToken(gomock.Any()).Do(func(input *sts.GetFederationTokenInput) {
+			assert.Equal(t, "my-app", aws.StringValue(input.Name), "Expected the configured federated user name")
+			assert.Nil(t, input.Policy, "Expected no inline policy")
+			assert.Equal(t, readOnlyPolicyArn, aws.StringValue(input.PolicyArns[0].Arn), "Expected the managed policy")
+			assert.Equal(t, int64(900), aws.Int64Value(input.DurationSeconds), "Expected the configured duration")
+		}).Return(getFederationTokenOutput(), nil),
+	)
+
+	// credentials are cached for each policy
+	for _, path := range []string{"/creds?policy=bucket", "/creds?policy=bucket", "/creds?policy=readonly"} {
+		recorder := getCredsInTest(t, router, path)
+		assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code 200 for %s", path)
+		response := &CredentialResponse{}
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), response), "Unexpected error parsing response")
+		assert.Equal(t, accessKey, response.AccessKeyID, "Expected access key to match")
+	}
+}
+
+func TestGetFederationTokenFromCallerLabel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "federation")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+	service := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+	service.credsConfig = federationPoliciesInTest(t, dir)
+	router := mux.NewRouter()
+	service.SetupRoutes(router)
+
+	labeled := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).WithLabel(federationPolicyLabel, "readonly").Get()
+	unlabeled := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).Get()
+	gomock.InOrder(
+		dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{labeled}, nil),
+		stsMock.EXPECT().GetFederationToken(gomock.Any()).Do(func(input *sts.GetFederationTokenInput) {
+			assert.Equal(t, "my-app", aws.StringValue(input.Name), "Expected the policy from the label")
+		}).Return(getFederationTokenOutput(), nil),
+		dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{unlabeled}, nil),
+		stsMock.EXPECT().GetSessionToken(gomock.Any()).Return(&sts.GetSessionTokenOutput{
+			Credentials: getFederationTokenOutput().Credentials,
+		}, nil),
+	)
+
+	for i := 0; i < 2; i++ {
+		recorder := getCredsInTest(t, router, "/creds")
+		assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code 200")
+	}
+}
+
+func TestGetFederationTokenLabelIsAuthoritative(t *testing.T) {
+	dir, err := ioutil.TempDir("", "federation")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+	service := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+	service.credsConfig = federationPoliciesInTest(t, dir)
+	router := mux.NewRouter()
+	service.SetupRoutes(router)
+
+	labeled := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).WithLabel(federationPolicyLabel, "readonly").Get()
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{labeled}, nil).AnyTimes()
+	stsMock.EXPECT().GetFederationToken(gomock.Any()).Do(func(input *sts.GetFederationTokenInput) {
+		assert.Equal(t, "my-app", aws.StringValue(input.Name), "Expected the policy from the label")
+	}).Return(getFederationTokenOutput(), nil)
+
+	// a container with the label can not ask for another policy
+	recorder := getCredsInTest(t, router, "/creds?policy=bucket")
+	assert.Equal(t, http.StatusForbidden, recorder.Code, "Expected status code 403 for a conflicting policy")
+
+	recorder = getCredsInTest(t, router, "/creds?policy=readonly")
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code 200 for the labelled policy")
+}
+
+func TestGetFederationTokenUnknownCaller(t *testing.T) {
+	dir, err := ioutil.TempDir("", "federation")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+
+	// the caller's label can not be checked, so neither a policy nor the base identity is vended
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+	service := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+	service.credsConfig = federationPoliciesInTest(t, dir)
+	router := mux.NewRouter()
+	service.SetupRoutes(router)
+
+	gomock.InOrder(
+		dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{}, nil).Times(2),
+		dockerMock.EXPECT().ContainerList(gomock.Any()).Return(nil, fmt.Errorf("Some API Error")).Times(2),
+	)
+	for _, path := range []string{"/creds", "/creds?policy=readonly", "/creds", "/creds?policy=readonly"} {
+		recorder := getCredsInTest(t, router, path)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, "Expected status code 400 for %s", path)
+	}
+
+	service = newCredentialServiceInTest(iamMock, stsMock)
+	service.credsConfig = federationPoliciesInTest(t, dir)
+	router = mux.NewRouter()
+	service.SetupRoutes(router)
+	recorder := getCredsInTest(t, router, "/creds")
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code, "Expected status code 500 without the Docker API")
+}
+
+func TestGetFederationTokenErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "federation")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+
+	iamMock, stsMock := setupMocks(t)
+	service := newCredentialServiceInTest(iamMock, stsMock)
+	service.credsConfig = federationPoliciesInTest(t, dir)
+
+	_, err = service.getFederationToken("undefined")
+	assert.Error(t, err, "Expected error for an undefined policy")
+	status, _ := errorStatus(err)
+	assert.Equal(t, http.StatusNotFound, status, "Expected status code 404")
+
+	_, err = service.getFederationToken("empty")
+	assert.Error(t, err, "Expected error for a policy without a file or managed policies")
+
+	// temporary base credentials can not call GetFederationToken
+	sess, err := session.NewSession(aws.NewConfig().WithCredentials(credentials.NewStaticCredentials(accessKey, secretKey, sessionToken)))
+	assert.NoError(t, err, "Unexpected error creating new session")
+	service.currentSession = sess
+	_, err = service.getFederationToken("readonly")
+	assert.Error(t, err, "Expected error for temporary base credentials")
+}
+
+func TestGetFederationTokenOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "federation")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+
+	client := offline.New(callerAccountID, 0)
+	service := NewOfflineCredentialService(client)
+	service.credsConfig = federationPoliciesInTest(t, dir)
+
+	response, err := service.getFederationToken("bucket")
+	assert.NoError(t, err, "Unexpected error calling getFederationToken")
+	record := client.Lookup(response.AccessKeyID)
+	assert.NotNil(t, record, "Expected the credentials to be recorded")
+	assert.Equal(t, "GetFederationToken", record.Operation, "Expected the operation to match")
+	assert.Equal(t, "arn:aws:sts::111111111111:federated-user/ecs-local-bucket", record.FederatedUserArn, "Expected the federated user to match")
+}
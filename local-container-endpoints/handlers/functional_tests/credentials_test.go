@@ -19,17 +19,22 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker/mock_docker"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/iam/mock_iamiface"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/sts/mock_stsiface"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/handlers"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
+	"github.com/docker/docker/api/types"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
@@ -91,6 +96,82 @@ func TestGetRoleCredentials(t *testing.T) {
 	assert.Equal(t, creds.RoleArn, roleARN, "Expected role ARN to match")
 }
 
+func TestGetRoleArnCredentials(t *testing.T) {
+	const pathRoleARN = "arn:aws:iam::111111111111:role/service-role/team/clyde_task_role"
+
+	var testCases = []struct {
+		name string
+		path string
+	}{
+		{
+			name: "raw ARN",
+			path: "/role-arn/" + pathRoleARN,
+		},
+		{
+			name: "percent-encoded ARN",
+			path: "/role-arn/" + url.PathEscape(pathRoleARN),
+		},
+		{
+			name: "percent-encoded ARN with trailing slash",
+			path: "/role-arn/" + url.PathEscape(pathRoleARN) + "/",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			iamMock, stsMock := setupMocks(t)
+			credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+			expiration, _ := time.Parse(handlers.CredentialExpirationTimeFormat, expirationTimeString)
+
+			stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+				assert.Equal(t, pathRoleARN, aws.StringValue(input.RoleArn), "Expected role ARN to match")
+				assert.Equal(t, "ecs-local-"+roleName, aws.StringValue(input.RoleSessionName), "Expected session name to use the role name")
+			}).Return(&sts.AssumeRoleOutput{
+				Credentials: &sts.Credentials{
+					AccessKeyId:     aws.String(accessKey),
+					SecretAccessKey: aws.String(secretKey),
+					SessionToken:    aws.String(sessionToken),
+					Expiration:      &expiration,
+				},
+			}, nil)
+
+			router := mux.NewRouter()
+			credsService.SetupRoutes(router)
+			ts := httptest.NewServer(router)
+			defer ts.Close()
+
+			res, err := http.Get(ts.URL + testCase.path)
+			assert.NoError(t, err, "Unexpected error making HTTP Request")
+			response, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.NoError(t, err, "Unexpected error reading HTTP response")
+			assert.Equal(t, http.StatusOK, res.StatusCode, "Expected http response status to be OK")
+
+			creds := &handlers.CredentialResponse{}
+			err = json.Unmarshal(response, creds)
+			assert.NoError(t, err, "Unexpected error unmarshalling response")
+			assert.Equal(t, creds.AccessKeyID, accessKey, "Expected access key to match")
+			assert.Equal(t, creds.RoleArn, pathRoleARN, "Expected role ARN to match")
+		})
+	}
+}
+
+func TestGetRoleArnCredentialsInvalidArn(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	res, err := http.Get(fmt.Sprintf("%s/role-arn/arn:aws:iam::111111111111:user/clyde", ts.URL))
+	assert.NoError(t, err, "Unexpected error making HTTP Request")
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "Expected http response status to be bad request")
+}
+
 func TestGetTemporaryCredentials(t *testing.T) {
 	iamMock, stsMock := setupMocks(t)
 
@@ -131,6 +212,83 @@ func TestGetTemporaryCredentials(t *testing.T) {
 
 }
 
+func TestGetTaskRoleCredentials(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+
+	credsService := handlers.NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+
+	expiration, _ := time.Parse(handlers.CredentialExpirationTimeFormat, expirationTimeString)
+
+	// requests from the test server come from localhost
+	caller := testingutils.BaseDockerContainer("caller", longID1).WithNetwork("bridge", "127.0.0.1").WithLabel("ecs-local.task-role-arn", roleARN).Get()
+
+	gomock.InOrder(
+		dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{caller}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, roleARN, aws.StringValue(input.RoleArn), "Expected role ARN to match")
+		}).Return(&sts.AssumeRoleOutput{
+			Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String(accessKey),
+				SecretAccessKey: aws.String(secretKey),
+				SessionToken:    aws.String(sessionToken),
+				Expiration:      &expiration,
+			},
+		}, nil),
+	)
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	res, err := http.Get(fmt.Sprintf("%s/task-role", ts.URL))
+	assert.NoError(t, err, "Unexpected error making HTTP Request")
+	response, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.NoError(t, err, "Unexpected error reading HTTP response")
+
+	creds := &handlers.CredentialResponse{}
+	err = json.Unmarshal(response, creds)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+	assert.Equal(t, creds.AccessKeyID, accessKey, "Expected access key to match")
+	assert.Equal(t, creds.Expiration, expirationTimeString, "Expected expiration to match")
+	assert.Equal(t, creds.RoleArn, roleARN, "Expected role ARN to match")
+}
+
+func TestGetRoleCredentialsAccessDenied(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	gomock.InOrder(
+		iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn: aws.String(roleARN),
+			},
+		}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Return(nil, awserr.New("AccessDenied", "not authorized to perform sts:AssumeRole", nil)),
+	)
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	res, err := http.Get(fmt.Sprintf("%s/role/%s", ts.URL, roleName))
+	assert.NoError(t, err, "Unexpected error making HTTP Request")
+	response, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.NoError(t, err, "Unexpected error reading HTTP response")
+	assert.Equal(t, http.StatusForbidden, res.StatusCode, "Expected http response status to be forbidden")
+
+	errResponse := &handlers.ErrorResponse{}
+	err = json.Unmarshal(response, errResponse)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+	assert.Equal(t, "AccessDenied", errResponse.Code, "Expected error code to match")
+	assert.Contains(t, errResponse.Message, "not authorized to perform sts:AssumeRole", "Expected error message to match")
+}
+
 func setupMocks(t *testing.T) (*mock_iamiface.MockIAMAPI, *mock_stsiface.MockSTSAPI) {
 	ctrl := gomock.NewController(t)
 	iamMock := mock_iamiface.NewMockIAMAPI(ctrl)
@@ -139,5 +297,5 @@ func setupMocks(t *testing.T) (*mock_iamiface.MockIAMAPI, *mock_stsiface.MockSTS
 }
 
 func newCredentialServiceInTest(iamMock *mock_iamiface.MockIAMAPI, stsMock *mock_stsiface.MockSTSAPI) *handlers.CredentialService {
-	return handlers.NewCredentialServiceWithClients(iamMock, stsMock, nil)
+	return handlers.NewCredentialServiceWithClients(iamMock, stsMock, nil, nil)
 }
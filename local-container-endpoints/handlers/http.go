@@ -16,12 +16,27 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
+	"net"
 	"net/http"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// awsErrorStatuses maps the codes of AWS API errors to the HTTP status returned to the caller.
+// Errors with other codes result in an HTTP 500.
+var awsErrorStatuses = map[string]int{
+	"AccessDenied":          http.StatusForbidden,
+	"AccessDeniedException": http.StatusForbidden,
+	"NoSuchEntity":          http.StatusNotFound,
+	"Throttling":            http.StatusTooManyRequests,
+	"ThrottlingException":   http.StatusTooManyRequests,
+	"ExpiredToken":          http.StatusUnauthorized,
+	"ExpiredTokenException": http.StatusUnauthorized,
+}
+
 // Error wraps built-in error and adds a status code
 type Error interface {
 	error
@@ -44,29 +59,74 @@ func (herr HTTPError) Status() int {
 	return herr.Code
 }
 
+// ErrorResponse is used to marshal the JSON body of error responses, in the same format as the ECS Agent
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
 // ServeHTTP wraps an HTTP Handler
 func ServeHTTP(handler func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := handler(w, r)
 		if err != nil {
-			switch e := err.(type) {
-			case Error:
-				// Return the specific error code and error message
-				logrus.Errorf("HTTP %d - %s", e.Status(), err)
-				http.Error(w, e.Error(), e.Status())
-			default:
-				// default to HTTP 500 for all other errors
-				logrus.Errorf("HTTP 500 - %s", err)
-				// Internal Server Error: <actual error message>
-				http.Error(w, fmt.Sprintf("%s: %s", http.StatusText(http.StatusInternalServerError), err.Error()),
-					http.StatusInternalServerError)
-			}
+			status, code := errorStatus(err)
+			logrus.Errorf("HTTP %d - %s", status, err)
+			writeJSONError(w, status, &ErrorResponse{
+				Code:    code,
+				Message: err.Error(),
+			})
+		}
+	}
+}
+
+// errorStatus returns the HTTP status and error code for an error returned by a handler
+func errorStatus(err error) (int, string) {
+	switch e := errors.Cause(err).(type) {
+	case awserr.Error:
+		if status, ok := awsErrorStatuses[e.Code()]; ok {
+			return status, e.Code()
 		}
+		// default to HTTP 500 for all other AWS API errors
+		return http.StatusInternalServerError, e.Code()
+	case Error:
+		// Return the specific error code
+		return e.Status(), statusCode(e.Status())
+	default:
+		// default to HTTP 500 for all other errors
+		return http.StatusInternalServerError, statusCode(http.StatusInternalServerError)
 	}
 }
 
+// statusCode returns the error code for errors which did not come from an AWS API, e.g. "NotFound" for HTTP 404
+func statusCode(status int) string {
+	return strings.Replace(http.StatusText(status), " ", "", -1)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, response *ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
 func writeJSONResponse(w http.ResponseWriter, response interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
+
+func writeTextResponse(w http.ResponseWriter, response string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(response))
+}
+
+// getCallerIP returns the IP address which the request came from, or an empty string if it is unknown
+func getCallerIP(r *http.Request) string {
+	callerIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return callerIP
+}
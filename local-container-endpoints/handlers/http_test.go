@@ -0,0 +1,103 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeHTTPErrors(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "AccessDenied",
+			err:            awserr.New("AccessDenied", "not authorized to perform sts:AssumeRole", nil),
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "AccessDenied",
+		},
+		{
+			name:           "NoSuchEntity",
+			err:            awserr.New("NoSuchEntity", "role not found", nil),
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "NoSuchEntity",
+		},
+		{
+			name:           "Throttling",
+			err:            awserr.New("Throttling", "rate exceeded", nil),
+			expectedStatus: http.StatusTooManyRequests,
+			expectedCode:   "Throttling",
+		},
+		{
+			name:           "ExpiredToken",
+			err:            awserr.New("ExpiredToken", "the security token included in the request is expired", nil),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "ExpiredToken",
+		},
+		{
+			name:           "Wrapped AWS error",
+			err:            errors.Wrap(awserr.New("AccessDenied", "not authorized", nil), "failed to assume role"),
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "AccessDenied",
+		},
+		{
+			name:           "Unmapped AWS error",
+			err:            awserr.New("InvalidClientTokenId", "the security token is invalid", nil),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   "InvalidClientTokenId",
+		},
+		{
+			name:           "HTTPError",
+			err:            HTTPError{Code: http.StatusBadRequest, Err: fmt.Errorf("invalid path")},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "BadRequest",
+		},
+		{
+			name:           "Other error",
+			err:            fmt.Errorf("something went wrong"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   "InternalServerError",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := ServeHTTP(func(w http.ResponseWriter, r *http.Request) error {
+				return tc.err
+			})
+
+			recorder := httptest.NewRecorder()
+			handler(recorder, httptest.NewRequest(http.MethodGet, "/creds", nil))
+
+			assert.Equal(t, tc.expectedStatus, recorder.Code, "Expected status code to match")
+			assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"), "Expected JSON content type")
+			response := &ErrorResponse{}
+			err := json.Unmarshal(recorder.Body.Bytes(), response)
+			assert.NoError(t, err, "Unexpected error unmarshalling response")
+			assert.Equal(t, tc.expectedCode, response.Code, "Expected error code to match")
+			assert.Equal(t, tc.err.Error(), response.Message, "Expected error message to match")
+		})
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	imdsTokenHeader    = "X-aws-ec2-metadata-token"
+	imdsTokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	imdsTokenBytes     = 42
+
+	imdsCredentialsCode = "Success"
+	imdsCredentialsType = "AWS-HMAC"
+)
+
+// IMDSService emulates the EC2 Instance Metadata Service, for applications which only know
+// how to obtain credentials from an EC2 instance
+type IMDSService struct {
+	credentials *CredentialService
+	tokens      *imdsTokens
+	// disableV1 requires every request to present an IMDSv2 session token
+	disableV1 bool
+	// role is the name or ARN of the instance's role, for containers without a task role label
+	role string
+	// metadataOverrides are merged into the default instance metadata
+	metadataOverrides imdsMetadata
+	// pendingTime is when the emulated instance was launched
+	pendingTime time.Time
+	// signer signs the instance identity document
+	signer *imdsSigner
+	// events are the simulated Spot interruption and maintenance events
+	events *imdsEvents
+}
+
+// imdsTokens holds the IMDSv2 session tokens which have been issued, and when they expire
+type imdsTokens struct {
+	lock   sync.Mutex
+	expiry map[string]time.Time
+	now    func() time.Time
+}
+
+// NewIMDSService returns a struct that handles IMDS requests using the given credentials service
+func NewIMDSService(credentials *CredentialService) (*IMDSService, error) {
+	overrides, err := loadIMDSMetadataOverrides(utils.GetValue("", config.IMDSMetadataPathVar))
+	if err != nil {
+		return nil, err
+	}
+	signer, err := newIMDSSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	service := &IMDSService{
+		credentials: credentials,
+		tokens: &imdsTokens{
+			expiry: make(map[string]time.Time),
+			now:    time.Now,
+		},
+		disableV1:         utils.GetValue("", config.IMDSDisableV1Var) == "true",
+		role:              utils.GetValue("", config.IMDSRoleVar),
+		metadataOverrides: overrides,
+		pendingTime:       time.Now().UTC().Truncate(time.Second),
+		signer:            signer,
+		events:            newIMDSEvents(),
+	}
+	if service.disableV1 {
+		logrus.Info("IMDS requests must present a session token (IMDSv2)")
+	}
+	return service, nil
+}
+
+// SetupRoutes sets up the IMDS paths in mux
+func (service *IMDSService) SetupRoutes(router *mux.Router) {
+	router.HandleFunc(config.IMDSTokenPath, ServeHTTP(service.getTokenHandler())).Methods(http.MethodPut)
+
+	router.HandleFunc(config.IMDSSecurityCredentialsPath, ServeHTTP(service.requireToken(service.getRoleListHandler())))
+	router.HandleFunc(config.IMDSSecurityCredentialsPathWithSlash, ServeHTTP(service.requireToken(service.getRoleListHandler())))
+
+	router.HandleFunc(config.IMDSRoleCredentialsPath, ServeHTTP(service.credentials.audit(service.requireToken(service.credentials.requireSSOSession(service.getRoleCredentialsHandler())))))
+	router.HandleFunc(config.IMDSRoleCredentialsPathWithSlash, ServeHTTP(service.credentials.audit(service.requireToken(service.credentials.requireSSOSession(service.getRoleCredentialsHandler())))))
+
+	// the rest of the metadata tree; this must come after the credentials paths
+	router.HandleFunc(config.IMDSRootPath, ServeHTTP(service.requireToken(service.getMetadataHandler()))).Methods(http.MethodGet)
+	router.HandleFunc(config.IMDSMetadataPath, ServeHTTP(service.requireToken(service.getMetadataHandler()))).Methods(http.MethodGet)
+
+	router.HandleFunc(config.IMDSCertificateAdminPath, ServeHTTP(service.getCertificateHandler())).Methods(http.MethodGet)
+	router.HandleFunc(config.IMDSEventsAdminPath, ServeHTTP(service.credentials.requireAuthorization(service.getEventsHandler()))).Methods(http.MethodGet, http.MethodPost, http.MethodDelete)
+}
+
+// issue returns a new session token which is valid for ttl
+func (tokens *imdsTokens) issue(ttl time.Duration) (string, error) {
+	bits := make([]byte, imdsTokenBytes)
+	if _, err := rand.Read(bits); err != nil {
+		return "", fmt.Errorf("failed to generate session token: %s", err)
+	}
+	token := base64.StdEncoding.EncodeToString(bits)
+
+	tokens.lock.Lock()
+	defer tokens.lock.Unlock()
+
+	now := tokens.now()
+	for existing, expiry := range tokens.expiry {
+		if !now.Before(expiry) {
+			delete(tokens.expiry, existing)
+		}
+	}
+	tokens.expiry[token] = now.Add(ttl)
+	return token, nil
+}
+
+// valid returns true if the token was issued and has not expired
+func (tokens *imdsTokens) valid(token string) bool {
+	tokens.lock.Lock()
+	defer tokens.lock.Unlock()
+
+	expiry, ok := tokens.expiry[token]
+	return ok && tokens.now().Before(expiry)
+}
+
+// getTokenHandler returns a handler which issues IMDSv2 session tokens
+func (service *IMDSService) getTokenHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received IMDS session token request")
+
+		// like EC2, refuse token requests which went through a proxy
+		if r.Header.Get("X-Forwarded-For") != "" {
+			return HTTPError{
+				Code: http.StatusForbidden,
+				Err:  fmt.Errorf("IMDS session tokens can not be requested through a proxy"),
+			}
+		}
+
+		ttl, err := strconv.Atoi(r.Header.Get(imdsTokenTTLHeader))
+		if err != nil || ttl < 1 || ttl > config.IMDSMaxTokenTTL {
+			return HTTPError{
+				Code: http.StatusBadRequest,
+				Err:  fmt.Errorf("The %s header must be between 1 and %d", imdsTokenTTLHeader, config.IMDSMaxTokenTTL),
+			}
+		}
+
+		token, err := service.tokens.issue(time.Duration(ttl) * time.Second)
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set(imdsTokenTTLHeader, strconv.Itoa(ttl))
+		writeTextResponse(w, token)
+		return nil
+	}
+}
+
+// requireToken wraps an IMDS handler so that requests with an invalid session token are rejected,
+// as are requests without one if IMDSv1 is disabled
+func (service *IMDSService) requireToken(handler func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		token := r.Header.Get(imdsTokenHeader)
+		if token == "" && !service.disableV1 {
+			return handler(w, r)
+		}
+
+		if !service.tokens.valid(token) {
+			return HTTPError{
+				Code: http.StatusUnauthorized,
+				Err:  fmt.Errorf("Missing, invalid or expired %s header; obtain a session token with 'PUT %s'", imdsTokenHeader, config.IMDSTokenPath),
+			}
+		}
+		return handler(w, r)
+	}
+}
+
+// instanceRole returns the role for the container which made the request: the role in its task role labels,
+// or else the configured instance role. roleArn is empty if the role was given by name.
+func (service *IMDSService) instanceRole(r *http.Request) (caller *types.Container, roleArn, roleName string, err error) {
+	caller = service.credentials.lookupCallerContainer(r)
+
+	role := service.role
+	if caller != nil {
+		if arnLabel := caller.Labels[taskRoleArnLabel]; arnLabel != "" {
+			role = arnLabel
+		} else if nameLabel := caller.Labels[taskRoleNameLabel]; nameLabel != "" {
+			role = nameLabel
+		}
+	}
+
+	if role == "" {
+		return nil, "", "", HTTPError{
+			Code: http.StatusNotFound,
+			Err:  fmt.Errorf("No instance role: set %s on the Local Endpoints container, or the %s or %s label on your container", config.IMDSRoleVar, taskRoleArnLabel, taskRoleNameLabel),
+		}
+	}
+
+	if !strings.HasPrefix(role, "arn:") {
+		return caller, "", role, nil
+	}
+
+	roleArn, roleName, err = parseRoleArn(role)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return caller, roleArn, roleName, nil
+}
+
+// getRoleListHandler returns a handler which lists the instance's role, in the same way as EC2
+func (service *IMDSService) getRoleListHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received IMDS security credentials request")
+
+		_, _, roleName, err := service.instanceRole(r)
+		if err != nil {
+			return err
+		}
+
+		writeTextResponse(w, roleName)
+		return nil
+	}
+}
+
+// getRoleCredentialsHandler returns a handler which vends credentials for the instance's role in the IMDS format
+func (service *IMDSService) getRoleCredentialsHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received IMDS role credentials request")
+
+		caller, roleArn, roleName, err := service.instanceRole(r)
+		if err != nil {
+			return err
+		}
+
+		if requested := mux.Vars(r)["role"]; requested != roleName {
+			return HTTPError{
+				Code: http.StatusNotFound,
+				Err:  fmt.Errorf("Role %s is not the instance's role", requested),
+			}
+		}
+
+		// the SDKs can not present an authorization token to IMDS, so roles which require one are not served here
+		roleKeys := []string{roleArn, roleName}
+		if roleArn == "" {
+			roleKeys = append(service.credentials.authorization.roleArnKeys("", roleName), roleName)
+		}
+		if service.credentials.authorization != nil && service.credentials.authorization.expectedToken(roleKeys...) != "" {
+			return HTTPError{
+				Code: http.StatusForbidden,
+				Err:  fmt.Errorf("Role %s requires an authorization token, which can not be presented to IMDS; use the credentials paths instead", roleName),
+			}
+		}
+
+		var response *CredentialResponse
+		if roleArn != "" {
+			response, err = service.credentials.getRoleCredentialsFromArn(roleArn, roleName, caller, 0)
+		} else {
+			response, err = service.credentials.getRoleCredentials(roleName, caller)
+		}
+		if err != nil {
+			return err
+		}
+
+		auditCredentials(r, response)
+		writeJSONResponse(w, &IMDSCredentialResponse{
+			Code:            imdsCredentialsCode,
+			LastUpdated:     time.Now().UTC().Format(CredentialExpirationTimeFormat),
+			Type:            imdsCredentialsType,
+			AccessKeyID:     response.AccessKeyID,
+			SecretAccessKey: response.SecretAccessKey,
+			Token:           response.Token,
+			Expiration:      response.Expiration,
+		})
+		return nil
+	}
+}
@@ -0,0 +1,423 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Types of event which can be scheduled with the IMDS events admin endpoint
+const (
+	imdsSpotInterruptionEvent = "spot-interruption"
+	imdsMaintenanceEvent      = "maintenance"
+)
+
+const (
+	// EC2 gives two minutes warning of a Spot interruption
+	defaultIMDSEventDelay = 120 * time.Second
+	// maxIMDSEventDelay keeps the delay well within the range of a time.Duration
+	maxIMDSEventDelay = 7 * 24 * time.Hour
+	// the window in which a scheduled maintenance event takes place
+	imdsMaintenanceWindow = time.Hour
+
+	imdsMaintenanceTimeFormat = "2 Jan 2006 15:04:05 GMT"
+	imdsMaintenanceState      = "active"
+)
+
+var (
+	imdsSpotActions = map[string]bool{
+		"terminate": true,
+		"stop":      true,
+		"hibernate": true,
+	}
+	imdsMaintenanceCodes = map[string]bool{
+		"instance-reboot":     true,
+		"system-reboot":       true,
+		"system-maintenance":  true,
+		"instance-retirement": true,
+		"instance-stop":       true,
+	}
+)
+
+// IMDSEventRequest is used to unmarshal the request body of the IMDS events admin endpoint
+type IMDSEventRequest struct {
+	// Type is spot-interruption or maintenance
+	Type string
+	// DelaySeconds is how long until the interruption or maintenance; the default is 120
+	DelaySeconds int64
+	// Action is the Spot interruption action: terminate, stop or hibernate
+	Action string `json:",omitempty"`
+	// Code and Description describe the maintenance event
+	Code        string `json:",omitempty"`
+	Description string `json:",omitempty"`
+	// StopContainers stops the containers in the Docker Compose project when the delay has passed
+	StopContainers bool `json:",omitempty"`
+	// ComposeProject defaults to the project of the Local Endpoints container
+	ComposeProject string `json:",omitempty"`
+}
+
+// IMDSSpotInstanceAction is used to marshal the spot/instance-action item
+type IMDSSpotInstanceAction struct {
+	Action string `json:"action"`
+	Time   string `json:"time"`
+}
+
+// IMDSRebalanceRecommendation is used to marshal the events/recommendations/rebalance item
+type IMDSRebalanceRecommendation struct {
+	NoticeTime string `json:"noticeTime"`
+}
+
+// IMDSMaintenanceEvent is used to marshal the events/maintenance/scheduled item
+type IMDSMaintenanceEvent struct {
+	NotBefore   string
+	NotAfter    string
+	Code        string
+	Description string
+	EventID     string `json:"EventId"`
+	State       string
+}
+
+// IMDSEventsResponse is used to marshal the JSON response for the IMDS events admin endpoint
+type IMDSEventsResponse struct {
+	SpotInstanceAction *IMDSSpotInstanceAction `json:",omitempty"`
+	MaintenanceEvents  []IMDSMaintenanceEvent
+}
+
+// imdsEvents holds the simulated Spot interruption and scheduled maintenance events, and the timers which
+// stop containers when they take place
+type imdsEvents struct {
+	lock         sync.Mutex
+	spot         *IMDSSpotInstanceAction
+	spotNotice   time.Time
+	spotTimer    *time.Timer
+	maintenance  []IMDSMaintenanceEvent
+	timers       []*time.Timer
+	now          func() time.Time
+	eventCounter int
+}
+
+func newIMDSEvents() *imdsEvents {
+	return &imdsEvents{
+		maintenance: []IMDSMaintenanceEvent{},
+		now:         time.Now,
+	}
+}
+
+// metadata returns the items in the instance metadata tree for the events; the spot directory only exists
+// while there is an interruption, like on EC2
+func (events *imdsEvents) metadata() (imdsMetadata, error) {
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	scheduled, err := json.Marshal(events.maintenance)
+	if err != nil {
+		return nil, err
+	}
+	metadata := map[string]interface{}{
+		"events": map[string]interface{}{
+			"maintenance": map[string]interface{}{
+				"scheduled": imdsJSON(scheduled),
+			},
+		},
+	}
+
+	if events.spot != nil {
+		instanceAction, err := json.Marshal(events.spot)
+		if err != nil {
+			return nil, err
+		}
+		rebalance, err := json.Marshal(&IMDSRebalanceRecommendation{
+			NoticeTime: events.spotNotice.Format(CredentialExpirationTimeFormat),
+		})
+		if err != nil {
+			return nil, err
+		}
+		metadata["spot"] = map[string]interface{}{
+			"instance-action":  imdsJSON(instanceAction),
+			"termination-time": events.spot.Time,
+		}
+		metadata["events"].(map[string]interface{})["recommendations"] = map[string]interface{}{
+			"rebalance": imdsJSON(rebalance),
+		}
+	}
+
+	return imdsMetadata{
+		"meta-data": metadata,
+	}, nil
+}
+
+// schedule adds the event, and calls onDeadline, if any, when it takes place
+func (events *imdsEvents) schedule(request *IMDSEventRequest, onDeadline func()) {
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	now := events.now().UTC()
+	delay := time.Duration(request.DelaySeconds) * time.Second
+	deadline := now.Add(delay).Truncate(time.Second)
+
+	var timer *time.Timer
+	if onDeadline != nil {
+		timer = time.AfterFunc(delay, onDeadline)
+	}
+
+	switch request.Type {
+	case imdsSpotInterruptionEvent:
+		// a new interruption replaces the previous one
+		if events.spotTimer != nil {
+			events.spotTimer.Stop()
+		}
+		events.spot = &IMDSSpotInstanceAction{
+			Action: request.Action,
+			Time:   deadline.Format(CredentialExpirationTimeFormat),
+		}
+		events.spotNotice = now.Truncate(time.Second)
+		events.spotTimer = timer
+	case imdsMaintenanceEvent:
+		events.eventCounter++
+		events.maintenance = append(events.maintenance, IMDSMaintenanceEvent{
+			NotBefore:   deadline.Format(imdsMaintenanceTimeFormat),
+			NotAfter:    deadline.Add(imdsMaintenanceWindow).Format(imdsMaintenanceTimeFormat),
+			Code:        request.Code,
+			Description: request.Description,
+			EventID:     fmt.Sprintf("instance-event-%017x", events.eventCounter),
+			State:       imdsMaintenanceState,
+		})
+		if timer != nil {
+			events.timers = append(events.timers, timer)
+		}
+	}
+}
+
+// cancel removes all events, so that their containers are not stopped
+func (events *imdsEvents) cancel() {
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	if events.spotTimer != nil {
+		events.spotTimer.Stop()
+	}
+	for _, timer := range events.timers {
+		timer.Stop()
+	}
+	events.spot = nil
+	events.spotTimer = nil
+	events.maintenance = []IMDSMaintenanceEvent{}
+	events.timers = nil
+}
+
+func (events *imdsEvents) status() *IMDSEventsResponse {
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	response := &IMDSEventsResponse{
+		MaintenanceEvents: append([]IMDSMaintenanceEvent{}, events.maintenance...),
+	}
+	if events.spot != nil {
+		spot := *events.spot
+		response.SpotInstanceAction = &spot
+	}
+	return response
+}
+
+// validateIMDSEventRequest checks the request and fills in the defaults
+func validateIMDSEventRequest(request *IMDSEventRequest) error {
+	if request.DelaySeconds < 0 {
+		return fmt.Errorf("DelaySeconds must not be negative")
+	}
+	if request.DelaySeconds > int64(maxIMDSEventDelay/time.Second) {
+		return fmt.Errorf("DelaySeconds must not be more than %d", int64(maxIMDSEventDelay/time.Second))
+	}
+	if request.DelaySeconds == 0 {
+		request.DelaySeconds = int64(defaultIMDSEventDelay / time.Second)
+	}
+
+	switch request.Type {
+	case imdsSpotInterruptionEvent:
+		if request.Action == "" {
+			request.Action = "terminate"
+		}
+		if !imdsSpotActions[request.Action] {
+			return fmt.Errorf("Unknown Spot interruption action %s", request.Action)
+		}
+	case imdsMaintenanceEvent:
+		if request.Code == "" {
+			request.Code = "system-reboot"
+		}
+		if !imdsMaintenanceCodes[request.Code] {
+			return fmt.Errorf("Unknown maintenance event code %s", request.Code)
+		}
+		if request.Description == "" {
+			request.Description = "scheduled " + strings.Replace(request.Code, "-", " ", -1)
+		}
+	default:
+		return fmt.Errorf("Type must be %s or %s", imdsSpotInterruptionEvent, imdsMaintenanceEvent)
+	}
+	return nil
+}
+
+// composeProjectToStop returns the project whose containers are stopped for an event: the one in the request,
+// or else the project of the Local Endpoints container. Only the project of the Local Endpoints container or of the
+// caller may be stopped, so that containers can not stop unrelated projects on the same host.
+func (service *IMDSService) composeProjectToStop(request *IMDSEventRequest, callerIP string) (string, error) {
+	if service.credentials.dockerClient == nil {
+		return "", HTTPError{
+			Code: http.StatusBadRequest,
+			Err:  fmt.Errorf("Containers can not be stopped without a Docker client"),
+		}
+	}
+
+	containers, err := service.listContainers()
+	if err != nil {
+		return "", err
+	}
+	var endpointsProject, callerProject string
+	if endpoints := endpointsContainer(containers); endpoints != nil {
+		endpointsProject = endpoints.Labels[composeProjectNameLabel]
+	}
+	if caller, err := findContainer(containers, "", callerIP); err == nil && containerHasIP(caller, callerIP) {
+		callerProject = caller.Labels[composeProjectNameLabel]
+	}
+
+	switch {
+	case request.ComposeProject == "" && endpointsProject != "":
+		return endpointsProject, nil
+	case request.ComposeProject == "":
+		return "", HTTPError{
+			Code: http.StatusBadRequest,
+			Err:  fmt.Errorf("Set ComposeProject to stop containers: the Local Endpoints container is not in a Docker Compose project"),
+		}
+	case request.ComposeProject == endpointsProject || request.ComposeProject == callerProject:
+		return request.ComposeProject, nil
+	default:
+		return "", HTTPError{
+			Code: http.StatusForbidden,
+			Err:  fmt.Errorf("Docker Compose project %s can not be stopped: only the project of the Local Endpoints container or of the caller can be", request.ComposeProject),
+		}
+	}
+}
+
+// stopComposeProject stops the containers in the project, except for Local Endpoints itself
+func (service *IMDSService) stopComposeProject(project string) {
+	containers, err := service.listContainers()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	endpoints := endpointsContainer(containers)
+	for _, container := range filterByLabel(containers, composeProjectNameLabel, project) {
+		if endpoints != nil && container.ID == endpoints.ID {
+			continue
+		}
+		logrus.Infof("Stopping container %s", containerName(&container))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := service.credentials.dockerClient.ContainerStop(ctx, container.ID); err != nil {
+			logrus.Error(err)
+		}
+		cancel()
+	}
+}
+
+func (service *IMDSService) listContainers() ([]types.Container, error) {
+	timeout, _ := time.ParseDuration(config.HTTPTimeoutDuration)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	containers, err := service.credentials.dockerClient.ContainerList(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list running containers")
+	}
+	return containers, nil
+}
+
+// endpointsContainer returns the Local Endpoints container, whose short ID is its hostname, or nil if it is not found
+func endpointsContainer(containers []types.Container) *types.Container {
+	shortID := os.Getenv("HOSTNAME")
+	if shortID == "" {
+		return nil
+	}
+	for i := range containers {
+		if strings.HasPrefix(containers[i].ID, shortID) {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+func filterByLabel(containers []types.Container, label, value string) []types.Container {
+	var filtered []types.Container
+	for _, container := range containers {
+		if container.Labels[label] == value {
+			filtered = append(filtered, container)
+		}
+	}
+	return filtered
+}
+
+// getEventsHandler returns a handler which reports the simulated events on GET, schedules an event from
+// the request body on POST, and cancels all events on DELETE
+func (service *IMDSService) getEventsHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodPost:
+			logrus.Debug("Received IMDS event request")
+			request := &IMDSEventRequest{}
+			if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+				return HTTPError{
+					Code: http.StatusBadRequest,
+					Err:  fmt.Errorf("Invalid request body; expected {\"Type\": \"%s\", \"DelaySeconds\": 120}", imdsSpotInterruptionEvent),
+				}
+			}
+			if err := validateIMDSEventRequest(request); err != nil {
+				return HTTPError{
+					Code: http.StatusBadRequest,
+					Err:  err,
+				}
+			}
+
+			var onDeadline func()
+			if request.StopContainers {
+				project, err := service.composeProjectToStop(request, getCallerIP(r))
+				if err != nil {
+					return err
+				}
+				onDeadline = func() {
+					logrus.Infof("The simulated %s event has taken place, stopping Docker Compose project %s", request.Type, project)
+					service.stopComposeProject(project)
+				}
+			}
+
+			logrus.Infof("Scheduled a simulated %s event in %d seconds", request.Type, request.DelaySeconds)
+			service.events.schedule(request, onDeadline)
+		case http.MethodDelete:
+			logrus.Info("Cancelled the simulated IMDS events")
+			service.events.cancel()
+		}
+
+		writeJSONResponse(w, service.events.status())
+		return nil
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker/mock_docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func imdsEventRequest(router *mux.Router, method, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, config.IMDSEventsAdminPath, strings.NewReader(body)))
+	return recorder
+}
+
+func TestIMDSSpotInterruption(t *testing.T) {
+	_, router, _ := newIMDSRouterInTest(t, false)
+
+	recorder := imdsRequest(router, http.MethodGet, "/latest/meta-data/spot/instance-action", nil)
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected no interruption")
+
+	start := time.Now().UTC()
+	recorder = imdsEventRequest(router, http.MethodPost, `{"Type": "spot-interruption", "DelaySeconds": 300}`)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+	recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/spot/instance-action", nil)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	action := &IMDSSpotInstanceAction{}
+	err := json.Unmarshal(recorder.Body.Bytes(), action)
+	assert.NoError(t, err, "Unexpected error unmarshalling instance action")
+	assert.Equal(t, "terminate", action.Action, "Expected the default action")
+	actionTime, err := time.Parse(CredentialExpirationTimeFormat, action.Time)
+	assert.NoError(t, err, "Unexpected error parsing action time")
+	assert.WithinDuration(t, start.Add(300*time.Second), actionTime, 2*time.Second, "Expected the action to be 300 seconds out")
+
+	recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/spot/termination-time", nil)
+	assert.Equal(t, action.Time, recorder.Body.String(), "Expected termination time to match the action")
+
+	recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/events/recommendations/rebalance", nil)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected a rebalance recommendation")
+
+	recorder = imdsEventRequest(router, http.MethodDelete, "")
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/spot/instance-action", nil)
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected the interruption to be cancelled")
+}
+
+func TestIMDSMaintenanceEvent(t *testing.T) {
+	_, router, _ := newIMDSRouterInTest(t, false)
+
+	recorder := imdsRequest(router, http.MethodGet, "/latest/meta-data/events/maintenance/scheduled", nil)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	assert.Equal(t, "[]", recorder.Body.String(), "Expected no scheduled events")
+
+	recorder = imdsEventRequest(router, http.MethodPost, `{"Type": "maintenance", "Code": "instance-retirement", "DelaySeconds": 60}`)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	status := &IMDSEventsResponse{}
+	err := json.Unmarshal(recorder.Body.Bytes(), status)
+	assert.NoError(t, err, "Unexpected error unmarshalling status")
+	assert.Nil(t, status.SpotInstanceAction, "Expected no Spot interruption")
+
+	recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/events/maintenance/scheduled", nil)
+	var events []IMDSMaintenanceEvent
+	err = json.Unmarshal(recorder.Body.Bytes(), &events)
+	assert.NoError(t, err, "Unexpected error unmarshalling events")
+	assert.Len(t, events, 1, "Expected one scheduled event")
+	assert.Equal(t, "instance-retirement", events[0].Code, "Expected code to match")
+	assert.Equal(t, "active", events[0].State, "Expected state to match")
+	assert.Equal(t, status.MaintenanceEvents, events, "Expected the admin endpoint to report the event")
+	_, err = time.Parse(imdsMaintenanceTimeFormat, events[0].NotBefore)
+	assert.NoError(t, err, "Expected NotBefore in the IMDS format")
+	assert.True(t, strings.HasPrefix(events[0].EventID, "instance-event-"), "Expected an event ID")
+}
+
+func TestIMDSEventInvalidRequest(t *testing.T) {
+	_, router, _ := newIMDSRouterInTest(t, false)
+
+	for _, body := range []string{
+		`not json`,
+		`{"Type": "meteor-strike"}`,
+		`{"Type": "spot-interruption", "Action": "explode"}`,
+		`{"Type": "maintenance", "Code": "system-meltdown"}`,
+		`{"Type": "spot-interruption", "DelaySeconds": -1}`,
+		`{"Type": "spot-interruption", "DelaySeconds": 604801}`,
+		// would overflow a time.Duration, and take place immediately
+		`{"Type": "spot-interruption", "DelaySeconds": 9223372036}`,
+		// there is no Docker client to stop containers with
+		`{"Type": "spot-interruption", "StopContainers": true, "ComposeProject": "app"}`,
+	} {
+		recorder := imdsEventRequest(router, http.MethodPost, body)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, "Expected status code for %s", body)
+	}
+}
+
+func TestIMDSEventStopsContainers(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+	credsService := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+	imdsService, err := NewIMDSService(credsService)
+	assert.NoError(t, err, "Unexpected error creating IMDS service")
+
+	os.Setenv("HOSTNAME", "endpoints")
+	defer os.Unsetenv("HOSTNAME")
+
+	containers := []types.Container{
+		{ID: "endpoints0123", Names: []string{"/endpoints"}, Labels: map[string]string{composeProjectNameLabel: "app"}},
+		{ID: "worker0123", Names: []string{"/worker"}, Labels: map[string]string{composeProjectNameLabel: "app"}},
+		{ID: "unrelated0123", Names: []string{"/unrelated"}, Labels: map[string]string{composeProjectNameLabel: "other"}},
+	}
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return(containers, nil).Times(2)
+
+	// only the worker is stopped, and not Local Endpoints itself or containers in other projects
+	stopped := make(chan string, 1)
+	dockerMock.EXPECT().ContainerStop(gomock.Any(), "worker0123").DoAndReturn(func(_ interface{}, id string) error {
+		stopped <- id
+		return nil
+	})
+
+	request := &IMDSEventRequest{Type: imdsSpotInterruptionEvent, StopContainers: true}
+	project, err := imdsService.composeProjectToStop(request, "")
+	assert.NoError(t, err, "Unexpected error finding the Compose project")
+	assert.Equal(t, "app", project, "Expected the project of the Local Endpoints container")
+
+	imdsService.events.schedule(request, func() {
+		imdsService.stopComposeProject(project)
+	})
+
+	select {
+	case id := <-stopped:
+		assert.Equal(t, "worker0123", id, "Expected the worker to be stopped")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the containers to be stopped")
+	}
+}
+
+func TestIMDSEventComposeProjectToStop(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+	credsService := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+	credsService.authorization = &authorization{token: authToken}
+	imdsService, err := NewIMDSService(credsService)
+	assert.NoError(t, err, "Unexpected error creating IMDS service")
+	router := mux.NewRouter()
+	imdsService.SetupRoutes(router)
+	defer imdsService.events.cancel()
+
+	os.Setenv("HOSTNAME", "endpoints")
+	defer os.Unsetenv("HOSTNAME")
+
+	containers := []types.Container{
+		{ID: "endpoints0123", Names: []string{"/endpoints"}, Labels: map[string]string{composeProjectNameLabel: "app"}},
+		testingutils.BaseDockerContainer(containerName1, longID1).WithComposeProject("caller").WithNetwork(network1, testRequestIP).Get(),
+		{ID: "unrelated0123", Names: []string{"/unrelated"}, Labels: map[string]string{composeProjectNameLabel: "other"}},
+	}
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return(containers, nil).AnyTimes()
+
+	// the authorization token is required
+	recorder := imdsEventRequest(router, http.MethodPost, `{"Type": "spot-interruption", "StopContainers": true}`)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected status code without a token")
+
+	for project, expectedStatus := range map[string]int{
+		"":       http.StatusOK,
+		"app":    http.StatusOK,
+		"caller": http.StatusOK,
+		"other":  http.StatusForbidden,
+	} {
+		body := fmt.Sprintf(`{"Type": "spot-interruption", "DelaySeconds": 3600, "StopContainers": true, "ComposeProject": "%s"}`, project)
+		req := httptest.NewRequest(http.MethodPost, config.IMDSEventsAdminPath, strings.NewReader(body))
+		req.Header.Set(authorizationHeader, authToken)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		assert.Equal(t, expectedStatus, recorder.Code, "Expected status code for project %s", project)
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultIMDSRegion = "us-west-2"
+	unknownCallerIP   = "127.0.0.1"
+
+	instanceIdentityDocumentPath = "dynamic/instance-identity/document"
+)
+
+// imdsMetadata is the emulated instance metadata tree: a map is a directory, and anything else is an item.
+// The keys at the top level are the paths under /latest, i.e. meta-data and dynamic.
+type imdsMetadata map[string]interface{}
+
+// imdsJSON is an item which is served as JSON, exactly as given, e.g. the instance identity document which is signed
+type imdsJSON []byte
+
+// imdsSignature is an item which is the instance identity document signed in the named format
+type imdsSignature string
+
+// loadIMDSMetadataOverrides reads the JSON or YAML file which overrides the emulated instance metadata
+func loadIMDSMetadataOverrides(path string) (imdsMetadata, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	bits, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read instance metadata file")
+	}
+
+	// YAML is a superset of JSON, so this reads both
+	overrides := map[string]interface{}{}
+	if err = yaml.Unmarshal(bits, &overrides); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse instance metadata file %s", path)
+	}
+	if err = validateIMDSMetadataOverrides(defaultIMDSMetadata(""), overrides, ""); err != nil {
+		return nil, errors.Wrapf(err, "invalid instance metadata file %s", path)
+	}
+	return overrides, nil
+}
+
+// validateIMDSMetadataOverrides returns an error if the overrides replace a default directory with an item,
+// since the instance identity document is built from the default directories
+func validateIMDSMetadataOverrides(defaults, overrides map[string]interface{}, path string) error {
+	for key, value := range overrides {
+		defaultDir, ok := defaults[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		overrideDir, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s%s must be a directory", path, key)
+		}
+		if err := validateIMDSMetadataOverrides(defaultDir, overrideDir, path+key+"/"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultIMDSMetadata returns the instance metadata for a caller, derived from the mock task ARN
+func defaultIMDSMetadata(callerIP string) imdsMetadata {
+	taskARN := utils.GetValue(config.DefaultTaskARN, config.TaskARNVar)
+	region := defaultIMDSRegion
+	accountID := config.DefaultOfflineAccountID
+	if parsed, err := arn.Parse(taskARN); err == nil {
+		region = parsed.Region
+		accountID = parsed.AccountID
+	}
+
+	if callerIP == "" {
+		callerIP = unknownCallerIP
+	}
+	hostname := fmt.Sprintf("ip-%s.%s.compute.internal", strings.Replace(callerIP, ".", "-", -1), region)
+
+	// a stable instance ID for each mock task
+	hash := sha256.Sum256([]byte(taskARN))
+	instanceID := "i-" + hex.EncodeToString(hash[:])[:17]
+
+	return imdsMetadata{
+		"meta-data": map[string]interface{}{
+			"ami-id":         config.DefaultIMDSImageID,
+			"hostname":       hostname,
+			"instance-id":    instanceID,
+			"instance-type":  config.DefaultIMDSInstanceType,
+			"local-hostname": hostname,
+			"local-ipv4":     callerIP,
+			"placement": map[string]interface{}{
+				"availability-zone": region + "a",
+				"region":            region,
+			},
+			"services": map[string]interface{}{
+				"domain": "amazonaws.com",
+			},
+			// listed so that the tree is complete; the credentials paths have their own handlers
+			"iam": map[string]interface{}{
+				"security-credentials": map[string]interface{}{},
+			},
+		},
+		"dynamic": map[string]interface{}{
+			"instance-identity": map[string]interface{}{
+				"document": map[string]interface{}{
+					"accountId": accountID,
+				},
+			},
+		},
+	}
+}
+
+// metadata returns the instance metadata tree for a caller: the defaults, the overrides from the
+// metadata file, the simulated events, and an instance identity document which agrees with them, with its signatures
+func (service *IMDSService) metadata(callerIP string) (imdsMetadata, error) {
+	tree := defaultIMDSMetadata(callerIP)
+	mergeIMDSMetadata(tree, service.metadataOverrides)
+
+	events, err := service.events.metadata()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal instance events")
+	}
+	mergeIMDSMetadata(tree, events)
+
+	metadata, ok := tree["meta-data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("meta-data is not a directory")
+	}
+	placement, _ := metadata["placement"].(map[string]interface{})
+	document := map[string]interface{}{
+		"architecture":            "x86_64",
+		"availabilityZone":        placement["availability-zone"],
+		"billingProducts":         nil,
+		"devpayProductCodes":      nil,
+		"marketplaceProductCodes": nil,
+		"imageId":                 metadata["ami-id"],
+		"instanceId":              metadata["instance-id"],
+		"instanceType":            metadata["instance-type"],
+		"kernelId":                nil,
+		"pendingTime":             service.pendingTime.Format(CredentialExpirationTimeFormat),
+		"privateIp":               metadata["local-ipv4"],
+		"ramdiskId":               nil,
+		"region":                  placement["region"],
+		"version":                 "2017-09-30",
+	}
+	// the default and overridden document fields take precedence
+	if existing, ok := lookupIMDSMetadata(tree, instanceIdentityDocumentPath).(map[string]interface{}); ok {
+		for key, value := range existing {
+			document[key] = value
+		}
+	}
+	documentJSON, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal instance identity document")
+	}
+	mergeIMDSMetadata(tree, imdsMetadata{
+		"dynamic": map[string]interface{}{
+			"instance-identity": map[string]interface{}{
+				"document":          imdsJSON(documentJSON),
+				imdsSignatureFormat: imdsSignature(imdsSignatureFormat),
+				imdsPKCS7Format:     imdsSignature(imdsPKCS7Format),
+				imdsRSA2048Format:   imdsSignature(imdsRSA2048Format),
+			},
+		},
+	})
+	return tree, nil
+}
+
+// mergeIMDSMetadata merges the overrides into the tree; directories are merged, and items are replaced.
+// Directories are copied rather than shared, so that the overrides are never modified.
+func mergeIMDSMetadata(tree, overrides map[string]interface{}) {
+	for key, value := range overrides {
+		overrideDir, overrideIsDir := value.(map[string]interface{})
+		if !overrideIsDir {
+			tree[key] = value
+			continue
+		}
+
+		treeDir, treeIsDir := tree[key].(map[string]interface{})
+		if !treeIsDir {
+			treeDir = map[string]interface{}{}
+			tree[key] = treeDir
+		}
+		mergeIMDSMetadata(treeDir, overrideDir)
+	}
+}
+
+// lookupIMDSMetadata returns the directory or item at the path, or nil if there is none
+func lookupIMDSMetadata(tree map[string]interface{}, path string) interface{} {
+	var node interface{} = tree
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			continue
+		}
+		dir, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node, ok = dir[segment]
+		if !ok {
+			return nil
+		}
+	}
+	return node
+}
+
+// listIMDSDirectory lists the entries in a directory one per line, with a trailing slash on subdirectories, like IMDS
+func listIMDSDirectory(dir map[string]interface{}) string {
+	entries := make([]string, 0, len(dir))
+	for key, value := range dir {
+		if _, isDir := value.(map[string]interface{}); isDir {
+			key += "/"
+		}
+		entries = append(entries, key)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, "\n")
+}
+
+// getMetadataHandler returns a handler which serves the instance metadata tree
+func (service *IMDSService) getMetadataHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		path := strings.Trim(mux.Vars(r)["path"], "/")
+		logrus.Debugf("Received IMDS metadata request for %s", path)
+
+		tree, err := service.metadata(getCallerIP(r))
+		if err != nil {
+			return err
+		}
+		node := lookupIMDSMetadata(tree, path)
+		if node == nil {
+			return HTTPError{
+				Code: http.StatusNotFound,
+				Err:  fmt.Errorf("No instance metadata at %s", r.URL.Path),
+			}
+		}
+
+		switch item := node.(type) {
+		case map[string]interface{}:
+			writeTextResponse(w, listIMDSDirectory(item))
+		case imdsJSON:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(item)
+		case imdsSignature:
+			document, _ := lookupIMDSMetadata(tree, instanceIdentityDocumentPath).(imdsJSON)
+			signature, err := service.signer.sign(string(item), document)
+			if err != nil {
+				return err
+			}
+			writeTextResponse(w, signature)
+		case string:
+			writeTextResponse(w, item)
+		default:
+			writeTextResponse(w, fmt.Sprint(item))
+		}
+		return nil
+	}
+}
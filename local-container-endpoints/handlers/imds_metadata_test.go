@@ -0,0 +1,208 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	imdsMetadataYAML = `
+meta-data:
+  instance-id: i-0fedcba9876543210
+  placement:
+    availability-zone: eu-west-1b
+    region: eu-west-1
+  tags:
+    instance:
+      Name: clyde
+dynamic:
+  instance-identity:
+    document:
+      architecture: arm64
+`
+	imdsMetadataJSON = `{
+  "meta-data": {
+    "instance-id": "i-0fedcba9876543210",
+    "placement": {
+      "availability-zone": "eu-west-1b",
+      "region": "eu-west-1"
+    },
+    "tags": {
+      "instance": {
+        "Name": "clyde"
+      }
+    }
+  },
+  "dynamic": {
+    "instance-identity": {
+      "document": {
+        "architecture": "arm64"
+      }
+    }
+  }
+}`
+)
+
+func TestIMDSMetadataDefaults(t *testing.T) {
+	_, router, _ := newIMDSRouterInTest(t, false)
+
+	var testCases = []struct {
+		path     string
+		expected string
+	}{
+		{
+			path:     "/latest",
+			expected: "dynamic/\nmeta-data/",
+		},
+		{
+			path:     "/latest/meta-data/",
+			expected: "ami-id\nevents/\nhostname\niam/\ninstance-id\ninstance-type\nlocal-hostname\nlocal-ipv4\nplacement/\nservices/",
+		},
+		{
+			path:     "/latest/meta-data/placement",
+			expected: "availability-zone\nregion",
+		},
+		{
+			path:     "/latest/meta-data/placement/availability-zone",
+			expected: "us-west-2a",
+		},
+		{
+			path:     "/latest/meta-data/local-ipv4",
+			expected: testRequestIP,
+		},
+		{
+			path:     "/latest/meta-data/local-hostname",
+			expected: "ip-192-0-2-1.us-west-2.compute.internal",
+		},
+		{
+			path:     "/latest/meta-data/iam/",
+			expected: "security-credentials/",
+		},
+		{
+			path:     "/latest/dynamic/instance-identity/",
+			expected: "document\npkcs7\nrsa2048\nsignature",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.path, func(t *testing.T) {
+			recorder := imdsRequest(router, http.MethodGet, testCase.path, nil)
+			assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+			assert.Equal(t, testCase.expected, recorder.Body.String(), "Expected response to match")
+		})
+	}
+
+	recorder := imdsRequest(router, http.MethodGet, "/latest/meta-data/pudding", nil)
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected status code for a missing item")
+
+	recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/instance-id/pudding", nil)
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected status code for a path below an item")
+
+	recorder = imdsRequest(router, http.MethodGet, "/latest/dynamic/instance-identity/document", nil)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	document := map[string]interface{}{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &document)
+	assert.NoError(t, err, "Unexpected error unmarshalling document")
+	assert.Equal(t, "111111111111", document["accountId"], "Expected account from the task ARN")
+	assert.Equal(t, "us-west-2", document["region"], "Expected region from the task ARN")
+	assert.Equal(t, "us-west-2a", document["availabilityZone"], "Expected availability zone to match")
+	assert.Equal(t, testRequestIP, document["privateIp"], "Expected private IP to match")
+	assert.Equal(t, config.DefaultIMDSInstanceType, document["instanceType"], "Expected instance type to match")
+}
+
+func TestIMDSMetadataOverrides(t *testing.T) {
+	for name, contents := range map[string]string{"metadata.yaml": imdsMetadataYAML, "metadata.json": imdsMetadataJSON} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			err := ioutil.WriteFile(path, []byte(contents), 0600)
+			assert.NoError(t, err, "Unexpected error writing metadata file")
+
+			os.Setenv(config.IMDSMetadataPathVar, path)
+			defer os.Unsetenv(config.IMDSMetadataPathVar)
+
+			_, router, _ := newIMDSRouterInTest(t, false)
+
+			recorder := imdsRequest(router, http.MethodGet, "/latest/meta-data/placement/availability-zone", nil)
+			assert.Equal(t, "eu-west-1b", recorder.Body.String(), "Expected overridden availability zone")
+
+			recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/tags/instance/", nil)
+			assert.Equal(t, "Name", recorder.Body.String(), "Expected added directory to be listed")
+
+			recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/tags/instance/Name", nil)
+			assert.Equal(t, "clyde", recorder.Body.String(), "Expected added item")
+
+			recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/local-ipv4", nil)
+			assert.Equal(t, testRequestIP, recorder.Body.String(), "Expected default to remain")
+
+			recorder = imdsRequest(router, http.MethodGet, "/latest/dynamic/instance-identity/document", nil)
+			document := map[string]interface{}{}
+			err = json.Unmarshal(recorder.Body.Bytes(), &document)
+			assert.NoError(t, err, "Unexpected error unmarshalling document")
+			assert.Equal(t, "i-0fedcba9876543210", document["instanceId"], "Expected document to agree with the metadata")
+			assert.Equal(t, "eu-west-1", document["region"], "Expected document to agree with the metadata")
+			assert.Equal(t, "eu-west-1b", document["availabilityZone"], "Expected document to agree with the metadata")
+			assert.Equal(t, "arm64", document["architecture"], "Expected overridden document field")
+		})
+	}
+}
+
+func TestIMDSMetadataOverridesInvalid(t *testing.T) {
+	for name, contents := range map[string]string{
+		"scalar meta-data": "meta-data: i-0fedcba9876543210",
+		"list meta-data":   "meta-data:\n  - instance-id\n",
+		"scalar placement": "meta-data:\n  placement: eu-west-1b\n",
+		"list document":    `{"dynamic": {"instance-identity": {"document": ["arm64"]}}}`,
+		"not a mapping":    "- meta-data",
+		"invalid YAML":     "meta-data: {",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metadata.yaml")
+			err := ioutil.WriteFile(path, []byte(contents), 0600)
+			assert.NoError(t, err, "Unexpected error writing metadata file")
+
+			_, err = loadIMDSMetadataOverrides(path)
+			assert.Error(t, err, "Expected error loading malformed metadata file")
+		})
+	}
+
+	// keys which are not default directories may be items
+	path := filepath.Join(t.TempDir(), "metadata.yaml")
+	err := ioutil.WriteFile(path, []byte("meta-data:\n  instance-id: i-0fedcba9876543210\nuser-data: hello\n"), 0600)
+	assert.NoError(t, err, "Unexpected error writing metadata file")
+	_, err = loadIMDSMetadataOverrides(path)
+	assert.NoError(t, err, "Unexpected error loading metadata file")
+}
+
+func TestIMDSMetadataRequiresToken(t *testing.T) {
+	_, router, _ := newIMDSRouterInTest(t, true)
+
+	recorder := imdsRequest(router, http.MethodGet, "/latest/meta-data/instance-id", nil)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected IMDSv1 requests to be rejected")
+
+	recorder = imdsRequest(router, http.MethodPut, "/latest/api/token", map[string]string{imdsTokenTTLHeader: "60"})
+	token := recorder.Body.String()
+
+	recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/instance-id", map[string]string{imdsTokenHeader: token})
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	assert.Regexp(t, `^i-[0-9a-f]{17}$`, recorder.Body.String(), "Expected a well-formed instance ID")
+}
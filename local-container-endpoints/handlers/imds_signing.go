@@ -0,0 +1,379 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Formats of the signed instance identity document, named after their IMDS paths
+const (
+	imdsSignatureFormat = "signature"
+	imdsPKCS7Format     = "pkcs7"
+	imdsRSA2048Format   = "rsa2048"
+)
+
+const (
+	imdsSigningKeyBits        = 2048
+	imdsCertificateValidity   = 10 * 365 * 24 * time.Hour
+	imdsCertificateCommonName = "Amazon ECS Local Container Endpoints"
+	imdsSignatureLineLength   = 64
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+// PKCS #7 structures from RFC 2315
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type pkcs7IssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// imdsSigner signs instance identity documents, in the same way as AWS signs them with its regional certificates
+type imdsSigner struct {
+	key         *rsa.PrivateKey
+	certificate *x509.Certificate
+	now         func() time.Time
+}
+
+// newIMDSSigner loads the signing key and certificate from the configured PEM files,
+// or generates a key and a self-signed certificate if neither is configured
+func newIMDSSigner() (*imdsSigner, error) {
+	keyPath := utils.GetValue("", config.IMDSSigningKeyPathVar)
+	certificatePath := utils.GetValue("", config.IMDSSigningCertificatePathVar)
+
+	if keyPath == "" && certificatePath == "" {
+		logrus.Info("Generating a key and certificate to sign instance identity documents")
+		return generateIMDSSigner()
+	}
+	if keyPath == "" || certificatePath == "" {
+		return nil, fmt.Errorf("Both or neither of %s and %s must be set", config.IMDSSigningKeyPathVar, config.IMDSSigningCertificatePathVar)
+	}
+
+	key, err := loadIMDSSigningKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := loadIMDSCertificate(certificatePath)
+	if err != nil {
+		return nil, err
+	}
+	if !key.PublicKey.Equal(certificate.PublicKey) {
+		return nil, fmt.Errorf("The certificate in %s is not for the key in %s", certificatePath, keyPath)
+	}
+
+	return &imdsSigner{
+		key:         key,
+		certificate: certificate,
+		now:         time.Now,
+	}, nil
+}
+
+func generateIMDSSigner() (*imdsSigner, error) {
+	key, err := rsa.GenerateKey(rand.Reader, imdsSigningKeyBits)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate signing key")
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate certificate serial number")
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName: imdsCertificateCommonName,
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(imdsCertificateValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create certificate")
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse certificate")
+	}
+
+	return &imdsSigner{
+		key:         key,
+		certificate: certificate,
+		now:         time.Now,
+	}, nil
+}
+
+func loadIMDSSigningKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEMFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse signing key %s", path)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("The signing key in %s is not an RSA key", path)
+	}
+	return rsaKey, nil
+}
+
+func loadIMDSCertificate(path string) (*x509.Certificate, error) {
+	block, err := readPEMFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse certificate %s", path)
+	}
+	return certificate, nil
+}
+
+func readPEMFile(path string) (*pem.Block, error) {
+	bits, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read PEM file")
+	}
+	block, _ := pem.Decode(bits)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found in %s", path)
+	}
+	return block, nil
+}
+
+// certificatePEM returns the certificate which verifies the signatures, PEM encoded
+func (signer *imdsSigner) certificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: signer.certificate.Raw,
+	})
+}
+
+// sign returns the document signed in the given format, base64 encoded in lines like IMDS
+func (signer *imdsSigner) sign(format string, document []byte) (string, error) {
+	var signature []byte
+	var err error
+	switch format {
+	case imdsSignatureFormat:
+		digest := sha256.Sum256(document)
+		signature, err = rsa.SignPKCS1v15(rand.Reader, signer.key, crypto.SHA256, digest[:])
+	case imdsPKCS7Format, imdsRSA2048Format:
+		signature, err = signer.signPKCS7(document)
+	default:
+		return "", fmt.Errorf("Unknown signature format %s", format)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign instance identity document")
+	}
+
+	return wrapLines(base64.StdEncoding.EncodeToString(signature), imdsSignatureLineLength), nil
+}
+
+// signPKCS7 returns a DER encoded PKCS #7 SignedData structure which contains the document and signs it with
+// SHA-256 and RSA. Like those from AWS, it has no certificates, so verifiers must supply the certificate.
+func (signer *imdsSigner) signPKCS7(document []byte) ([]byte, error) {
+	digest := sha256.Sum256(document)
+
+	attributes, err := pkcs7Attributes(
+		oidContentType, oidData,
+		oidSigningTime, signer.now().UTC(),
+		oidMessageDigest, digest[:],
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// the signature covers the DER encoding of the attributes as a SET OF
+	signedAttributes, err := asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSet,
+		IsCompound: true,
+		Bytes:      attributes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	attributesDigest := sha256.Sum256(signedAttributes)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signer.key, crypto.SHA256, attributesDigest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := asn1.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	sha256Algorithm := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Algorithm},
+		ContentInfo: pkcs7ContentInfo{
+			ContentType: oidData,
+			Content:     explicitTag(content),
+		},
+		SignerInfos: []pkcs7SignerInfo{
+			{
+				Version: 1,
+				IssuerAndSerialNumber: pkcs7IssuerAndSerialNumber{
+					Issuer:       asn1.RawValue{FullBytes: signer.certificate.RawIssuer},
+					SerialNumber: signer.certificate.SerialNumber,
+				},
+				DigestAlgorithm: sha256Algorithm,
+				AuthenticatedAttributes: asn1.RawValue{
+					Class:      asn1.ClassContextSpecific,
+					Tag:        0,
+					IsCompound: true,
+					Bytes:      attributes,
+				},
+				DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+				EncryptedDigest:           signature,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     explicitTag(signedData),
+	})
+}
+
+// pkcs7Attributes returns the DER encoding of each attribute type and its single value, sorted as DER requires for a SET OF
+func pkcs7Attributes(typesAndValues ...interface{}) ([]byte, error) {
+	var encoded [][]byte
+	for i := 0; i < len(typesAndValues); i += 2 {
+		value, err := asn1.Marshal(typesAndValues[i+1])
+		if err != nil {
+			return nil, err
+		}
+		attribute, err := asn1.Marshal(pkcs7Attribute{
+			Type: typesAndValues[i].(asn1.ObjectIdentifier),
+			Values: asn1.RawValue{
+				Class:      asn1.ClassUniversal,
+				Tag:        asn1.TagSet,
+				IsCompound: true,
+				Bytes:      value,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, attribute)
+	}
+
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+	return bytes.Join(encoded, nil), nil
+}
+
+// explicitTag wraps DER encoded content in the [0] EXPLICIT tag used for the content of a ContentInfo
+func explicitTag(content []byte) asn1.RawValue {
+	return asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        0,
+		IsCompound: true,
+		Bytes:      content,
+	}
+}
+
+func wrapLines(s string, length int) string {
+	var lines []string
+	for len(s) > length {
+		lines = append(lines, s[:length])
+		s = s[length:]
+	}
+	lines = append(lines, s)
+	return strings.Join(lines, "\n")
+}
+
+// getCertificateHandler returns a handler which serves the certificate that verifies signed instance identity documents
+func (service *IMDSService) getCertificateHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received IMDS certificate request")
+
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.WriteHeader(http.StatusOK)
+		w.Write(service.signer.certificatePEM())
+		return nil
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// getSignedIdentity returns the instance identity document, the given signature of it, and the certificate
+func getSignedIdentity(t *testing.T, format string) ([]byte, []byte, *x509.Certificate) {
+	_, router, _ := newIMDSRouterInTest(t, false)
+
+	recorder := imdsRequest(router, http.MethodGet, "/latest/dynamic/instance-identity/document", nil)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	document := recorder.Body.Bytes()
+
+	recorder = imdsRequest(router, http.MethodGet, "/latest/dynamic/instance-identity/"+format, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		assert.True(t, len(line) <= imdsSignatureLineLength, "Expected base64 to be split into lines")
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.Replace(recorder.Body.String(), "\n", "", -1))
+	assert.NoError(t, err, "Unexpected error decoding signature")
+
+	recorder = imdsRequest(router, http.MethodGet, config.IMDSCertificateAdminPath, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	block, _ := pem.Decode(recorder.Body.Bytes())
+	assert.NotNil(t, block, "Expected a PEM certificate")
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err, "Unexpected error parsing certificate")
+
+	return document, signature, certificate
+}
+
+func TestGetIdentitySignature(t *testing.T) {
+	document, signature, certificate := getSignedIdentity(t, imdsSignatureFormat)
+
+	digest := sha256.Sum256(document)
+	err := rsa.VerifyPKCS1v15(certificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature)
+	assert.NoError(t, err, "Expected the signature to verify with the certificate")
+}
+
+func TestGetIdentityPKCS7(t *testing.T) {
+	for _, format := range []string{imdsPKCS7Format, imdsRSA2048Format} {
+		t.Run(format, func(t *testing.T) {
+			document, signature, certificate := getSignedIdentity(t, format)
+
+			contentInfo := pkcs7ContentInfo{}
+			_, err := asn1.Unmarshal(signature, &contentInfo)
+			assert.NoError(t, err, "Unexpected error parsing ContentInfo")
+			assert.Equal(t, oidSignedData, contentInfo.ContentType, "Expected SignedData")
+
+			signedData := pkcs7SignedData{}
+			_, err = asn1.Unmarshal(contentInfo.Content.Bytes, &signedData)
+			assert.NoError(t, err, "Unexpected error parsing SignedData")
+
+			var content []byte
+			_, err = asn1.Unmarshal(signedData.ContentInfo.Content.Bytes, &content)
+			assert.NoError(t, err, "Unexpected error parsing content")
+			assert.Equal(t, document, content, "Expected the document to be embedded")
+
+			assert.Len(t, signedData.SignerInfos, 1, "Expected one signer")
+			signerInfo := signedData.SignerInfos[0]
+			assert.Equal(t, certificate.SerialNumber, signerInfo.IssuerAndSerialNumber.SerialNumber, "Expected the certificate's serial number")
+
+			// the signature covers the attributes, which include the digest of the document
+			digest := sha256.Sum256(document)
+			assert.Contains(t, string(signerInfo.AuthenticatedAttributes.Bytes), string(digest[:]), "Expected the message digest attribute")
+			signedAttributes, err := asn1.Marshal(asn1.RawValue{
+				Class:      asn1.ClassUniversal,
+				Tag:        asn1.TagSet,
+				IsCompound: true,
+				Bytes:      signerInfo.AuthenticatedAttributes.Bytes,
+			})
+			assert.NoError(t, err, "Unexpected error encoding attributes")
+			attributesDigest := sha256.Sum256(signedAttributes)
+			err = rsa.VerifyPKCS1v15(certificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, attributesDigest[:], signerInfo.EncryptedDigest)
+			assert.NoError(t, err, "Expected the signature to verify with the certificate")
+		})
+	}
+}
+
+func TestNewIMDSSignerFromFiles(t *testing.T) {
+	generated, err := generateIMDSSigner()
+	assert.NoError(t, err, "Unexpected error generating signer")
+
+	dir, err := ioutil.TempDir("", "imds-signing")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+
+	keyPath := filepath.Join(dir, "key.pem")
+	certificatePath := filepath.Join(dir, "certificate.pem")
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(generated.key),
+	}), 0600)
+	assert.NoError(t, err, "Unexpected error writing key")
+	err = ioutil.WriteFile(certificatePath, generated.certificatePEM(), 0644)
+	assert.NoError(t, err, "Unexpected error writing certificate")
+
+	os.Setenv(config.IMDSSigningKeyPathVar, keyPath)
+	defer os.Unsetenv(config.IMDSSigningKeyPathVar)
+	os.Setenv(config.IMDSSigningCertificatePathVar, certificatePath)
+	defer os.Unsetenv(config.IMDSSigningCertificatePathVar)
+
+	signer, err := newIMDSSigner()
+	assert.NoError(t, err, "Unexpected error loading signer")
+	assert.Equal(t, generated.certificate.Raw, signer.certificate.Raw, "Expected the configured certificate")
+
+	// a certificate for a different key is rejected
+	other, err := generateIMDSSigner()
+	assert.NoError(t, err, "Unexpected error generating signer")
+	err = ioutil.WriteFile(certificatePath, other.certificatePEM(), 0644)
+	assert.NoError(t, err, "Unexpected error writing certificate")
+	_, err = newIMDSSigner()
+	assert.Error(t, err, "Expected error for a certificate which does not match the key")
+
+	os.Unsetenv(config.IMDSSigningCertificatePathVar)
+	_, err = newIMDSSigner()
+	assert.Error(t, err, "Expected error when only the key is configured")
+}
@@ -0,0 +1,207 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/sts/mock_stsiface"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newIMDSRouterInTest(t *testing.T, disableV1 bool) (*IMDSService, *mux.Router, *mock_stsiface.MockSTSAPI) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	imdsService, _ := NewIMDSService(credsService)
+	imdsService.disableV1 = disableV1
+	imdsService.role = roleARN
+
+	router := mux.NewRouter()
+	imdsService.SetupRoutes(router)
+	return imdsService, router, stsMock
+}
+
+func imdsRequest(router *mux.Router, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestIMDSCredentials(t *testing.T) {
+	_, router, stsMock := newIMDSRouterInTest(t, true)
+
+	expiration, _ := time.Parse(CredentialExpirationTimeFormat, "2049-11-10T23:00:00Z")
+	stsMock.EXPECT().AssumeRole(gomock.Any()).Return(assumeRoleOutput(expiration), nil)
+
+	recorder := imdsRequest(router, http.MethodPut, "/latest/api/token", map[string]string{imdsTokenTTLHeader: "21600"})
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	assert.Equal(t, "21600", recorder.Header().Get(imdsTokenTTLHeader), "Expected TTL header to match")
+	token := recorder.Body.String()
+	assert.NotEmpty(t, token, "Expected a session token")
+
+	recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/iam/security-credentials/", map[string]string{imdsTokenHeader: token})
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	assert.Equal(t, roleName, recorder.Body.String(), "Expected the role name to be listed")
+
+	recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/iam/security-credentials/"+roleName, map[string]string{imdsTokenHeader: token})
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+	creds := &IMDSCredentialResponse{}
+	err := json.Unmarshal(recorder.Body.Bytes(), creds)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+	assert.Equal(t, "Success", creds.Code, "Expected code to match")
+	assert.Equal(t, "AWS-HMAC", creds.Type, "Expected type to match")
+	assert.NotEmpty(t, creds.LastUpdated, "Expected last updated time")
+	assert.Equal(t, accessKey, creds.AccessKeyID, "Expected access key to match")
+	assert.Equal(t, secretKey, creds.SecretAccessKey, "Expected secret key to match")
+	assert.Equal(t, sessionToken, creds.Token, "Expected session token to match")
+	assert.Equal(t, "2049-11-10T23:00:00Z", creds.Expiration, "Expected expiration to match")
+
+	recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/iam/security-credentials/pudding", map[string]string{imdsTokenHeader: token})
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected status code for another role")
+}
+
+func TestIMDSv1(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	for _, disableV1 := range []bool{false, true} {
+		imdsService, _ := NewIMDSService(credsService)
+		imdsService.disableV1 = disableV1
+		imdsService.role = roleName
+
+		router := mux.NewRouter()
+		imdsService.SetupRoutes(router)
+
+		recorder := imdsRequest(router, http.MethodGet, "/latest/meta-data/iam/security-credentials", nil)
+		if disableV1 {
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected IMDSv1 requests to be rejected")
+		} else {
+			assert.Equal(t, http.StatusOK, recorder.Code, "Expected IMDSv1 requests to be allowed")
+			assert.Equal(t, roleName, recorder.Body.String(), "Expected the role name to be listed")
+		}
+
+		recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/iam/security-credentials", map[string]string{imdsTokenHeader: "pudding"})
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected invalid tokens to be rejected")
+	}
+}
+
+func TestIMDSTokens(t *testing.T) {
+	imdsService, router, _ := newIMDSRouterInTest(t, true)
+
+	var testCases = []struct {
+		name           string
+		headers        map[string]string
+		expectedStatus int
+	}{
+		{
+			name:           "missing TTL",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "TTL too long",
+			headers:        map[string]string{imdsTokenTTLHeader: "21601"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "TTL not a number",
+			headers:        map[string]string{imdsTokenTTLHeader: "pudding"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "proxied request",
+			headers:        map[string]string{imdsTokenTTLHeader: "60", "X-Forwarded-For": "192.0.2.2"},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			recorder := imdsRequest(router, http.MethodPut, "/latest/api/token", testCase.headers)
+			assert.Equal(t, testCase.expectedStatus, recorder.Code, "Expected status code to match")
+		})
+	}
+
+	t.Run("expired token", func(t *testing.T) {
+		recorder := imdsRequest(router, http.MethodPut, "/latest/api/token", map[string]string{imdsTokenTTLHeader: "60"})
+		assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+		token := recorder.Body.String()
+
+		imdsService.tokens.now = func() time.Time {
+			return time.Now().Add(time.Minute)
+		}
+
+		recorder = imdsRequest(router, http.MethodGet, "/latest/meta-data/iam/security-credentials", map[string]string{imdsTokenHeader: token})
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected expired tokens to be rejected")
+	})
+}
+
+func TestIMDSNoRole(t *testing.T) {
+	imdsService, router, _ := newIMDSRouterInTest(t, false)
+	imdsService.role = ""
+
+	recorder := imdsRequest(router, http.MethodGet, "/latest/meta-data/iam/security-credentials/", nil)
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected status code to match")
+}
+
+func TestIMDSCredentialsForRolesWithAuthorizationTokens(t *testing.T) {
+	var testCases = []struct {
+		name string
+		role string
+		auth *authorization
+	}{
+		{
+			name: "global token",
+			role: roleARN,
+			auth: &authorization{token: authToken},
+		},
+		{
+			name: "role ARN token",
+			role: roleARN,
+			auth: &authorization{roleTokens: map[string]string{roleARN: roleAuthToken}},
+		},
+		{
+			name: "role name token",
+			role: roleARN,
+			auth: &authorization{roleTokens: map[string]string{roleName: roleAuthToken}},
+		},
+		{
+			name: "role ARN token for a role given by name",
+			role: roleName,
+			auth: &authorization{roleTokens: map[string]string{roleARN: roleAuthToken}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			imdsService, router, _ := newIMDSRouterInTest(t, false)
+			imdsService.role = testCase.role
+			imdsService.credentials.authorization = testCase.auth
+
+			recorder := imdsRequest(router, http.MethodGet, "/latest/meta-data/iam/security-credentials/"+roleName, nil)
+			assert.Equal(t, http.StatusForbidden, recorder.Code, "Expected status code to match")
+		})
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/metadata"
 	"github.com/docker/docker/api/types"
@@ -264,6 +265,48 @@ func findContainer(dockerContainers []types.Container, identifier string, caller
 	return nil, fmt.Errorf("Failed to find the container which the request came from. Narrowed down search to %d containers", len(filteredList))
 }
 
+// findCallerContainer finds the container which made a request from the callerIP.
+// Unlike findContainer, it never falls back to guessing: the container must have the callerIP
+// in one of its networks. This is used for credentials, so that a container can only obtain
+// the credentials meant for itself.
+func findCallerContainer(dockerClient docker.Client, callerIP string) (*types.Container, error) {
+	if callerIP == "" {
+		return nil, fmt.Errorf("Failed to find the container which the request came from: unknown caller IP")
+	}
+
+	timeout, _ := time.ParseDuration(config.HTTPTimeoutDuration)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	containers, err := dockerClient.ContainerList(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list running containers")
+	}
+
+	container, err := findContainer(containers, "", callerIP)
+	if err != nil {
+		return nil, err
+	}
+
+	if !containerHasIP(container, callerIP) {
+		return nil, fmt.Errorf("Failed to find the container which the request came from: no container has IP %s", callerIP)
+	}
+
+	return container, nil
+}
+
+func containerHasIP(container *types.Container, ipAddress string) bool {
+	if container.NetworkSettings == nil {
+		return false
+	}
+	for _, settings := range container.NetworkSettings.Networks {
+		if settings != nil && settings.IPAddress == ipAddress {
+			return true
+		}
+	}
+	return false
+}
+
 func filterContainersByIdentifier(dockerContainers []types.Container, identifier string) []types.Container {
 	var filteredList []types.Container
 	for _, container := range dockerContainers {
@@ -15,7 +15,6 @@ package handlers
 
 import (
 	"fmt"
-	"net"
 	"net/http"
 
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker"
@@ -111,14 +110,9 @@ func (service *MetadataService) SetupV3Routes(router *mux.Router) {
 // getMetadataHandler returns a metadata handler given a requestType
 func (service *MetadataService) getMetadataHandler(requestType int) func(w http.ResponseWriter, r *http.Request) error {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		callerIP, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			// Failed to get the callerIP
-			callerIP = ""
-		}
 		vars := mux.Vars(r)
 		identifier := vars["identifier"]
-		return service.handleRequest(requestType, w, identifier, callerIP)
+		return service.handleRequest(requestType, w, identifier, getCallerIP(r))
 	}
 }
 
@@ -0,0 +1,195 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// clientFactory creates IAM and STS clients which use the given credentials
+type clientFactory func(creds *credentials.Credentials) (iamiface.IAMAPI, stsiface.STSAPI)
+
+// mfaSession holds the session obtained with sts:GetSessionToken and an MFA code.
+// When an MFA serial is configured, all role assumptions are made from this session.
+type mfaSession struct {
+	serialNumber    string
+	durationSeconds int64
+
+	lock      sync.RWMutex
+	creds     *sts.Credentials
+	iamClient iamiface.IAMAPI
+	stsClient stsiface.STSAPI
+}
+
+// MFARequest is used to unmarshal the request to start an MFA session
+type MFARequest struct {
+	TokenCode string
+}
+
+// MFAStatusResponse is used to marshal the JSON response for the MFA admin endpoint
+type MFAStatusResponse struct {
+	SerialNumber string
+	Active       bool
+	Expiration   string `json:",omitempty"`
+}
+
+// newMFASession returns nil if no MFA serial is configured
+func newMFASession() *mfaSession {
+	serialNumber := utils.GetValue("", config.MFASerialVar)
+	if serialNumber == "" {
+		return nil
+	}
+
+	durationStr := utils.GetValue(fmt.Sprintf("%ds", config.DefaultMFASessionDuration), config.MFASessionDurationVar)
+	duration, err := utils.ParseDuration(durationStr)
+	if err != nil || duration <= 0 {
+		logrus.Warnf(
+			"Could not parse MFA_SESSION_DURATION value, defaulting to %d seconds: %s",
+			config.DefaultMFASessionDuration, durationStr)
+		duration = config.DefaultMFASessionDuration * time.Second
+	}
+
+	logrus.Infof("Credentials require an MFA session for device %s", serialNumber)
+	return &mfaSession{
+		serialNumber:    serialNumber,
+		durationSeconds: int64(duration / time.Second),
+	}
+}
+
+// active returns the MFA session credentials and clients, or ok=false if there is no unexpired session
+func (mfa *mfaSession) active() (creds *sts.Credentials, iamClient iamiface.IAMAPI, stsClient stsiface.STSAPI, ok bool) {
+	mfa.lock.RLock()
+	defer mfa.lock.RUnlock()
+
+	if mfa.creds == nil || !time.Now().Before(aws.TimeValue(mfa.creds.Expiration)) {
+		return nil, nil, nil, false
+	}
+	return mfa.creds, mfa.iamClient, mfa.stsClient, true
+}
+
+func (mfa *mfaSession) status() *MFAStatusResponse {
+	response := &MFAStatusResponse{
+		SerialNumber: mfa.serialNumber,
+	}
+	if creds, _, _, ok := mfa.active(); ok {
+		response.Active = true
+		response.Expiration = creds.Expiration.Format(CredentialExpirationTimeFormat)
+	}
+	return response
+}
+
+// end discards the MFA session, so that a new code must be submitted
+func (mfa *mfaSession) end() {
+	mfa.lock.Lock()
+	defer mfa.lock.Unlock()
+	mfa.creds = nil
+	mfa.iamClient = nil
+	mfa.stsClient = nil
+}
+
+// errSessionRequired is returned for credentials requests while there is no MFA session
+func (mfa *mfaSession) errSessionRequired() error {
+	return HTTPError{
+		Code: http.StatusUnauthorized,
+		Err: fmt.Errorf("No valid MFA session for %s: submit a code from your MFA device with 'POST %s', or run '/local-container-endpoints mfa <code>' in the Local Endpoints container",
+			mfa.serialNumber, config.MFAAdminPath),
+	}
+}
+
+// startMFASession calls sts:GetSessionToken with the MFA code, and uses the result for all later role assumptions
+func (service *CredentialService) startMFASession(tokenCode string) error {
+	mfa := service.mfa
+	_, baseSTSClient, _ := service.clients()
+	creds, err := baseSTSClient.GetSessionToken(&sts.GetSessionTokenInput{
+		DurationSeconds: aws.Int64(mfa.durationSeconds),
+		SerialNumber:    aws.String(mfa.serialNumber),
+		TokenCode:       aws.String(tokenCode),
+	})
+	if err != nil {
+		return err
+	}
+
+	iamClient, stsClient := service.clientsWithCredentials(credentials.NewStaticCredentials(
+		aws.StringValue(creds.Credentials.AccessKeyId),
+		aws.StringValue(creds.Credentials.SecretAccessKey),
+		aws.StringValue(creds.Credentials.SessionToken),
+	))
+
+	mfa.lock.Lock()
+	defer mfa.lock.Unlock()
+	mfa.creds = creds.Credentials
+	mfa.iamClient = iamClient
+	mfa.stsClient = stsClient
+
+	logrus.Infof("Started MFA session which expires at %s", creds.Credentials.Expiration.Format(CredentialExpirationTimeFormat))
+	return nil
+}
+
+// baseClients returns the clients which roles are assumed with: those of the MFA session if MFA is configured
+func (service *CredentialService) baseClients() (iamiface.IAMAPI, stsiface.STSAPI, error) {
+	if service.mfa == nil {
+		iamClient, stsClient, _ := service.clients()
+		return iamClient, stsClient, nil
+	}
+
+	_, iamClient, stsClient, ok := service.mfa.active()
+	if !ok {
+		return nil, nil, service.mfa.errSessionRequired()
+	}
+	return iamClient, stsClient, nil
+}
+
+// getMFAHandler returns a handler which reports the MFA session status on GET,
+// and starts a new MFA session from the TokenCode in the request body on POST
+func (service *CredentialService) getMFAHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if service.mfa == nil {
+			return HTTPError{
+				Code: http.StatusNotFound,
+				Err:  fmt.Errorf("MFA is not configured: set %s on the Local Endpoints container", config.MFASerialVar),
+			}
+		}
+
+		if r.Method == http.MethodPost {
+			logrus.Debug("Received MFA code")
+			request := &MFARequest{}
+			if err := json.NewDecoder(r.Body).Decode(request); err != nil || request.TokenCode == "" {
+				return HTTPError{
+					Code: http.StatusBadRequest,
+					Err:  fmt.Errorf("Invalid request body; expected {\"TokenCode\": \"<code from your MFA device>\"}"),
+				}
+			}
+
+			if err := service.startMFASession(request.TokenCode); err != nil {
+				return err
+			}
+		}
+
+		writeJSONResponse(w, service.mfa.status())
+		return nil
+	}
+}
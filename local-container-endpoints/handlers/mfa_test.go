@@ -0,0 +1,188 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/iam/mock_iamiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/sts/mock_stsiface"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	mfaSerial       = "arn:aws:iam::111111111111:mfa/clyde"
+	mfaCode         = "123456"
+	mfaAccessKey    = "MFAAKID"
+	mfaSecretKey    = "MFASKID"
+	mfaSessionToken = "mfa-token"
+)
+
+// newMFACredentialServiceInTest returns a service requiring MFA, and the mocks which are used once an MFA session has started
+func newMFACredentialServiceInTest(t *testing.T) (*CredentialService, *mock_stsiface.MockSTSAPI, *mock_iamiface.MockIAMAPI, *mock_stsiface.MockSTSAPI) {
+	iamMock, stsMock := setupMocks(t)
+	mfaIAMMock, mfaSTSMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.mfa = &mfaSession{
+		serialNumber:    mfaSerial,
+		durationSeconds: 43200,
+	}
+	credsService.newClients = func(creds *credentials.Credentials) (iamiface.IAMAPI, stsiface.STSAPI) {
+		value, err := creds.Get()
+		assert.NoError(t, err, "Unexpected error getting MFA session credentials")
+		assert.Equal(t, mfaAccessKey, value.AccessKeyID, "Expected clients to use the MFA session")
+		return mfaIAMMock, mfaSTSMock
+	}
+	return credsService, stsMock, mfaIAMMock, mfaSTSMock
+}
+
+func expectGetSessionTokenWithMFA(t *testing.T, stsMock *mock_stsiface.MockSTSAPI, expiration time.Time) {
+	stsMock.EXPECT().GetSessionToken(gomock.Any()).Do(func(input *sts.GetSessionTokenInput) {
+		assert.Equal(t, mfaSerial, aws.StringValue(input.SerialNumber), "Expected MFA serial to match")
+		assert.Equal(t, mfaCode, aws.StringValue(input.TokenCode), "Expected MFA code to match")
+		assert.Equal(t, int64(43200), aws.Int64Value(input.DurationSeconds), "Expected duration to match")
+	}).Return(&sts.GetSessionTokenOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(mfaAccessKey),
+			SecretAccessKey: aws.String(mfaSecretKey),
+			SessionToken:    aws.String(mfaSessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil)
+}
+
+func TestMFASessionRequired(t *testing.T) {
+	credsService, _, _, _ := newMFACredentialServiceInTest(t)
+
+	_, err := credsService.getRoleCredentials(roleName, nil)
+	assert.Error(t, err, "Expected error without an MFA session")
+	assert.Equal(t, http.StatusUnauthorized, err.(HTTPError).Status(), "Expected status code to match")
+
+	_, err = credsService.getTemporaryCredentials()
+	assert.Error(t, err, "Expected error without an MFA session")
+}
+
+func TestMFASessionBacksRoleAssumption(t *testing.T) {
+	credsService, stsMock, mfaIAMMock, mfaSTSMock := newMFACredentialServiceInTest(t)
+
+	expiration := time.Now().Add(time.Hour)
+	expectGetSessionTokenWithMFA(t, stsMock, expiration.Add(11*time.Hour))
+
+	err := credsService.startMFASession(mfaCode)
+	assert.NoError(t, err, "Unexpected error starting MFA session")
+
+	gomock.InOrder(
+		mfaIAMMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn: aws.String(roleARN),
+			},
+		}, nil),
+		mfaSTSMock.EXPECT().AssumeRole(gomock.Any()).Return(&sts.AssumeRoleOutput{
+			Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String(accessKey),
+				SecretAccessKey: aws.String(secretKey),
+				SessionToken:    aws.String(sessionToken),
+				Expiration:      &expiration,
+			},
+		}, nil),
+	)
+
+	response, err := credsService.getRoleCredentials(roleName, nil)
+	assert.NoError(t, err, "Unexpected error calling getRoleCredentials")
+	assert.Equal(t, accessKey, response.AccessKeyID, "Expected access key to match")
+
+	response, err = credsService.getTemporaryCredentials()
+	assert.NoError(t, err, "Unexpected error calling getTemporaryCredentials")
+	assert.Equal(t, mfaAccessKey, response.AccessKeyID, "Expected the MFA session credentials")
+	assert.Equal(t, mfaSessionToken, response.Token, "Expected the MFA session token")
+}
+
+func TestMFASessionExpired(t *testing.T) {
+	credsService, stsMock, _, _ := newMFACredentialServiceInTest(t)
+
+	expectGetSessionTokenWithMFA(t, stsMock, time.Now().Add(-time.Minute))
+
+	err := credsService.startMFASession(mfaCode)
+	assert.NoError(t, err, "Unexpected error starting MFA session")
+
+	_, err = credsService.getRoleCredentials(roleName, nil)
+	assert.Error(t, err, "Expected error once the MFA session has expired")
+}
+
+func TestMFAHandler(t *testing.T) {
+	credsService, stsMock, _, _ := newMFACredentialServiceInTest(t)
+
+	expiration, _ := time.Parse(CredentialExpirationTimeFormat, "2049-11-10T23:00:00Z")
+	expectGetSessionTokenWithMFA(t, stsMock, expiration)
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/mfa", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	status := &MFAStatusResponse{}
+	json.Unmarshal(recorder.Body.Bytes(), status)
+	assert.False(t, status.Active, "Expected no MFA session")
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/admin/mfa", strings.NewReader(`{"TokenCode": "`+mfaCode+`"}`)))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	status = &MFAStatusResponse{}
+	json.Unmarshal(recorder.Body.Bytes(), status)
+	assert.True(t, status.Active, "Expected an MFA session")
+	assert.Equal(t, mfaSerial, status.SerialNumber, "Expected MFA serial to match")
+	assert.Equal(t, "2049-11-10T23:00:00Z", status.Expiration, "Expected expiration to match")
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/admin/mfa", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "Expected status code to match")
+}
+
+func TestMFAHandlerRequiresAuthorization(t *testing.T) {
+	credsService, _, _, _ := newMFACredentialServiceInTest(t)
+	credsService.authorization = &authorization{token: authToken}
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/mfa", nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected status code to match")
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/admin/mfa", strings.NewReader(`{"TokenCode": "`+mfaCode+`"}`)))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected status code to match")
+	assert.False(t, credsService.mfa.status().Active, "Expected no MFA session")
+
+	request := httptest.NewRequest(http.MethodGet, "/admin/mfa", nil)
+	request.Header.Set(authorizationHeader, authToken)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/offline"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// newOfflineCredentialService returns a service which vends random credentials without calling AWS
+func newOfflineCredentialService() (*CredentialService, error) {
+	accountID := utils.GetValue(config.DefaultOfflineAccountID, config.OfflineAccountIDVar)
+	if !accountIDRegex.MatchString(accountID) {
+		return nil, fmt.Errorf("%s must be a 12 digit AWS account ID: %s", config.OfflineAccountIDVar, accountID)
+	}
+
+	var duration time.Duration
+	if durationStr := utils.GetValue("", config.OfflineCredentialsDurationVar); durationStr != "" {
+		var err error
+		duration, err = utils.ParseDuration(durationStr)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("Could not parse %s value: %s", config.OfflineCredentialsDurationVar, durationStr)
+		}
+	}
+
+	logrus.Infof("Running in offline mode: credentials are random, and roles are in account %s", accountID)
+	return NewOfflineCredentialService(offline.New(accountID, duration)), nil
+}
+
+// NewOfflineCredentialService returns a struct that handles credentials requests with the given offline client
+func NewOfflineCredentialService(client *offline.Client) *CredentialService {
+	service := NewCredentialServiceWithClients(client, client, nil, nil)
+	service.offline = client
+	service.rolesAnywhere = client
+	service.newClients = func(creds *credentials.Credentials) (iamiface.IAMAPI, stsiface.STSAPI) {
+		return client, client
+	}
+	return service
+}
+
+// getOfflineCredentialsHandler returns a handler which reports which role each access key vended in offline mode belongs to
+func (service *CredentialService) getOfflineCredentialsHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if service.offline == nil {
+			return HTTPError{
+				Code: http.StatusNotFound,
+				Err:  fmt.Errorf("Offline mode is not enabled: set %s to true on the Local Endpoints container", config.OfflineModeVar),
+			}
+		}
+
+		accessKeyID := mux.Vars(r)["accessKeyId"]
+		if accessKeyID == "" {
+			writeJSONResponse(w, service.offline.Records())
+			return nil
+		}
+
+		record := service.offline.Lookup(accessKeyID)
+		if record == nil {
+			return HTTPError{
+				Code: http.StatusNotFound,
+				Err:  fmt.Errorf("Access key %s was not vended by Local Endpoints", accessKeyID),
+			}
+		}
+		writeJSONResponse(w, record)
+		return nil
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/offline"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var offlineAccessKeyRegex = regexp.MustCompile(`^ASIA[A-Z2-7]{16}$`)
+
+func getOfflineJSON(t *testing.T, router *mux.Router, path string, response interface{}) int {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if recorder.Code == http.StatusOK {
+		err := json.Unmarshal(recorder.Body.Bytes(), response)
+		assert.NoError(t, err, "Unexpected error unmarshalling response")
+	}
+	return recorder.Code
+}
+
+func TestOfflineCredentials(t *testing.T) {
+	credsService := NewOfflineCredentialService(offline.New("222222222222", 0))
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	var testCases = []struct {
+		path            string
+		expectedRoleArn string
+	}{
+		{
+			path: "/creds",
+		},
+		{
+			path:            "/role/" + roleName,
+			expectedRoleArn: "arn:aws:iam::222222222222:role/" + roleName,
+		},
+		{
+			path:            "/role-arn/arn:aws:iam::333333333333:role/service-role/app",
+			expectedRoleArn: "arn:aws:iam::333333333333:role/service-role/app",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.path, func(t *testing.T) {
+			creds := &CredentialResponse{}
+			status := getOfflineJSON(t, router, testCase.path, creds)
+			assert.Equal(t, http.StatusOK, status, "Expected status code to match")
+			assert.Regexp(t, offlineAccessKeyRegex, creds.AccessKeyID, "Expected a well-formed access key ID")
+			assert.Len(t, creds.SecretAccessKey, 40, "Expected a well-formed secret key")
+			assert.NotEmpty(t, creds.Token, "Expected a session token")
+			assert.Equal(t, testCase.expectedRoleArn, creds.RoleArn, "Expected role ARN to match")
+
+			expiration, err := time.Parse(CredentialExpirationTimeFormat, creds.Expiration)
+			assert.NoError(t, err, "Unexpected error parsing expiration")
+			assert.WithinDuration(t, time.Now().Add(time.Hour), expiration, time.Minute, "Expected credentials to last an hour")
+
+			record := &offline.Record{}
+			status = getOfflineJSON(t, router, "/admin/offline/credentials/"+creds.AccessKeyID, record)
+			assert.Equal(t, http.StatusOK, status, "Expected status code to match")
+			assert.Equal(t, testCase.expectedRoleArn, record.RoleArn, "Expected the record to name the role")
+			assert.Equal(t, testCase.expectedRoleArn, credsService.offline.Lookup(creds.AccessKeyID).RoleArn, "Expected the record to name the role")
+		})
+	}
+
+	var records []*offline.Record
+	status := getOfflineJSON(t, router, "/admin/offline/credentials", &records)
+	assert.Equal(t, http.StatusOK, status, "Expected status code to match")
+	assert.Len(t, records, len(testCases), "Expected a record for each request")
+
+	status = getOfflineJSON(t, router, "/admin/offline/credentials/ASIAUNKNOWN", &offline.Record{})
+	assert.Equal(t, http.StatusNotFound, status, "Expected status code for an unknown access key")
+}
+
+func TestOfflineCredentialsHandlerRequiresAuthorization(t *testing.T) {
+	credsService := NewOfflineCredentialService(offline.New("222222222222", 0))
+	credsService.authorization = &authorization{token: authToken}
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	creds, err := credsService.getRoleCredentials(roleName, nil)
+	assert.NoError(t, err, "Unexpected error calling getRoleCredentials")
+
+	for _, path := range []string{"/admin/offline/credentials", "/admin/offline/credentials/" + creds.AccessKeyID} {
+		status := getOfflineJSON(t, router, path, nil)
+		assert.Equal(t, http.StatusUnauthorized, status, "Expected status code for %s without the token", path)
+
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set(authorizationHeader, authToken)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code for %s with the token", path)
+	}
+}
+
+func TestOfflineCredentialsConfiguredDuration(t *testing.T) {
+	credsService := NewOfflineCredentialService(offline.New("222222222222", 5*time.Minute))
+
+	creds, err := credsService.getRoleCredentials(roleName, nil)
+	assert.NoError(t, err, "Unexpected error calling getRoleCredentials")
+
+	expiration, err := time.Parse(CredentialExpirationTimeFormat, creds.Expiration)
+	assert.NoError(t, err, "Unexpected error parsing expiration")
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), expiration, time.Minute, "Expected the configured duration")
+}
+
+func TestOfflineCredentialsHandlerNotOffline(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	status := getOfflineJSON(t, router, "/admin/offline/credentials", nil)
+	assert.Equal(t, http.StatusNotFound, status, "Expected status code to match")
+}
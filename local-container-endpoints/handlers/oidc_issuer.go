@@ -0,0 +1,427 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	oidcSigningAlgorithm = "RS256"
+	oidcKeyBits          = 2048
+	oidcTokenFileName    = "token"
+
+	// oidcTokenOwnerLabel on a container gives the "uid" or "uid:gid" which its token file and directory belong to,
+	// so that a container which does not run as root can read its token
+	oidcTokenOwnerLabel = "ecs-local.oidc-token-owner"
+)
+
+// oidcTokenPathSegmentRegex matches the container names, Compose project names and Compose service names which
+// may be used in the path of a token file; the first character rules out "." and ".."
+var oidcTokenPathSegmentRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// OIDCIssuerService is an OpenID Connect issuer which mints tokens for containers, so that roles can be assumed with
+// sts:AssumeRoleWithWebIdentity against an STS stand-in which trusts it, the way EKS does for service accounts
+type OIDCIssuerService struct {
+	credentials *CredentialService
+	issuer      string
+	audience    string
+	duration    time.Duration
+	// tokenDirectory is where a token for each running container is kept up to date, if set
+	tokenDirectory string
+
+	key   *rsa.PrivateKey
+	keyID string
+	now   func() time.Time
+}
+
+// OIDCDiscoveryDocument is used to marshal the OpenID Connect discovery document
+type OIDCDiscoveryDocument struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported                  []string `json:"claims_supported"`
+}
+
+// JWKS is used to marshal the JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is an RSA public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// oidcClaims are the claims of the tokens minted for containers
+type oidcClaims struct {
+	Issuer         string `json:"iss"`
+	Subject        string `json:"sub"`
+	Audience       string `json:"aud"`
+	IssuedAt       int64  `json:"iat"`
+	NotBefore      int64  `json:"nbf"`
+	Expiration     int64  `json:"exp"`
+	JWTID          string `json:"jti"`
+	ContainerName  string `json:"container_name"`
+	ComposeProject string `json:"compose_project,omitempty"`
+	ComposeService string `json:"compose_service,omitempty"`
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// NewOIDCIssuerService returns a struct that handles OIDC issuer requests, using the Docker client of the given credentials service
+func NewOIDCIssuerService(credentials *CredentialService) (*OIDCIssuerService, error) {
+	durationStr := utils.GetValue(fmt.Sprintf("%ds", config.DefaultOIDCTokenDuration), config.OIDCTokenDurationVar)
+	duration, err := utils.ParseDuration(durationStr)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("Could not parse %s value: %s", config.OIDCTokenDurationVar, durationStr)
+	}
+
+	key, err := newOIDCSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := jwkThumbprint(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	service := &OIDCIssuerService{
+		credentials:    credentials,
+		issuer:         strings.TrimSuffix(utils.GetValue(config.DefaultOIDCIssuerURL, config.OIDCIssuerURLVar), "/"),
+		audience:       utils.GetValue(config.DefaultOIDCTokenAudience, config.OIDCTokenAudienceVar),
+		duration:       duration,
+		tokenDirectory: utils.GetValue("", config.OIDCTokenDirectoryVar),
+		key:            key,
+		keyID:          keyID,
+		now:            time.Now,
+	}
+
+	if service.tokenDirectory != "" {
+		if credentials.dockerClient == nil {
+			return nil, fmt.Errorf("%s requires access to the Docker API", config.OIDCTokenDirectoryVar)
+		}
+		service.watchTokenDirectory()
+	}
+	return service, nil
+}
+
+// newOIDCSigningKey loads the key at OIDC_SIGNING_KEY_PATH, or generates one, in which case tokens can
+// only be verified until Local Endpoints restarts
+func newOIDCSigningKey() (*rsa.PrivateKey, error) {
+	if path := utils.GetValue("", config.OIDCSigningKeyPathVar); path != "" {
+		return loadIMDSSigningKey(path)
+	}
+
+	logrus.Info("Generating a key to sign OIDC tokens")
+	key, err := rsa.GenerateKey(rand.Reader, oidcKeyBits)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate OIDC signing key")
+	}
+	return key, nil
+}
+
+// SetupRoutes sets up the OIDC issuer paths in mux
+func (service *OIDCIssuerService) SetupRoutes(router *mux.Router) {
+	router.HandleFunc(config.OIDCDiscoveryPath, ServeHTTP(service.getDiscoveryHandler())).Methods(http.MethodGet)
+	router.HandleFunc(config.OIDCJWKSPath, ServeHTTP(service.getJWKSHandler())).Methods(http.MethodGet)
+	router.HandleFunc(config.OIDCTokenPath, ServeHTTP(service.credentials.audit(service.getTokenHandler()))).Methods(http.MethodGet)
+}
+
+func (service *OIDCIssuerService) getDiscoveryHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		writeJSONResponse(w, &OIDCDiscoveryDocument{
+			Issuer:                           service.issuer,
+			JWKSURI:                          service.issuer + config.OIDCJWKSPath,
+			ResponseTypesSupported:           []string{"id_token"},
+			SubjectTypesSupported:            []string{"public"},
+			IDTokenSigningAlgValuesSupported: []string{oidcSigningAlgorithm},
+			ClaimsSupported:                  []string{"iss", "sub", "aud", "iat", "nbf", "exp", "jti", "container_name", "compose_project", "compose_service"},
+		})
+		return nil
+	}
+}
+
+func (service *OIDCIssuerService) getJWKSHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		writeJSONResponse(w, &JWKS{
+			Keys: []JWK{newJWK(&service.key.PublicKey, service.keyID)},
+		})
+		return nil
+	}
+}
+
+// getTokenHandler returns a handler which mints a token for the container which made the request,
+// for the audience in the query string if there is one
+func (service *OIDCIssuerService) getTokenHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received OIDC token request")
+		if service.credentials.dockerClient == nil {
+			return fmt.Errorf("OIDC tokens require access to the Docker API")
+		}
+
+		container, err := findCallerContainer(service.credentials.dockerClient, getCallerIP(r))
+		auditCaller(r, container)
+		if err != nil {
+			return HTTPError{
+				Code: http.StatusBadRequest,
+				Err:  err,
+			}
+		}
+
+		audience := r.URL.Query().Get("audience")
+		if audience == "" {
+			audience = service.audience
+		}
+		token, err := service.mintToken(container, audience)
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", "application/jwt")
+		w.Write([]byte(token))
+		return nil
+	}
+}
+
+// oidcSubject returns the subject of a container's tokens: its compose project and service, or else its name
+func oidcSubject(container *types.Container) string {
+	project, service := container.Labels[composeProjectNameLabel], container.Labels[composeServiceNameLabel]
+	if project != "" && service != "" {
+		return fmt.Sprintf("compose:%s:%s", project, service)
+	}
+	return fmt.Sprintf("container:%s", containerName(container))
+}
+
+// mintToken returns a signed JWT for the container
+func (service *OIDCIssuerService) mintToken(container *types.Container, audience string) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", errors.Wrap(err, "failed to generate token ID")
+	}
+
+	now := service.now().UTC()
+	claims := &oidcClaims{
+		Issuer:         service.issuer,
+		Subject:        oidcSubject(container),
+		Audience:       audience,
+		IssuedAt:       now.Unix(),
+		NotBefore:      now.Unix(),
+		Expiration:     now.Add(service.duration).Unix(),
+		JWTID:          hex.EncodeToString(jti),
+		ContainerName:  containerName(container),
+		ComposeProject: container.Labels[composeProjectNameLabel],
+		ComposeService: container.Labels[composeServiceNameLabel],
+	}
+
+	header, err := json.Marshal(&jwtHeader{
+		Algorithm: oidcSigningAlgorithm,
+		Type:      "JWT",
+		KeyID:     service.keyID,
+	})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, service.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign OIDC token")
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// watchTokenDirectory writes a fresh token for every running container to the token directory
+// whenever half of the token duration has passed
+func (service *OIDCIssuerService) watchTokenDirectory() {
+	logrus.Infof("Writing OIDC tokens for each container to %s", service.tokenDirectory)
+	go func() {
+		for {
+			if err := service.writeTokenFiles(); err != nil {
+				logrus.Errorf("Failed to write OIDC tokens: %s", err)
+			}
+			time.Sleep(service.duration / 2)
+		}
+	}()
+}
+
+// writeTokenFiles writes a token for each running container to <directory>/<compose project>/<compose service>/token,
+// or <directory>/<container name>/token for containers which were not started by Compose. A failure for one
+// container is logged, so that the others still get fresh tokens.
+func (service *OIDCIssuerService) writeTokenFiles() error {
+	timeout, _ := time.ParseDuration(config.HTTPTimeoutDuration)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	containers, err := service.credentials.dockerClient.ContainerList(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list running containers")
+	}
+
+	for i := range containers {
+		container := &containers[i]
+		if err := service.writeTokenFile(container); err != nil {
+			logrus.Errorf("Failed to write the OIDC token for container %s: %s", containerName(container), err)
+		}
+	}
+	return nil
+}
+
+// writeTokenFile writes the token for the container to its own directory, which is meant to be mounted into that
+// container alone, so that containers can not read each other's tokens
+func (service *OIDCIssuerService) writeTokenFile(container *types.Container) error {
+	segments := []string{containerName(container)}
+	if project, composeService := container.Labels[composeProjectNameLabel], container.Labels[composeServiceNameLabel]; project != "" && composeService != "" {
+		segments = []string{project, composeService}
+	}
+	// the segments come from container names and labels, so they must not be able to leave the token directory
+	for _, segment := range segments {
+		if !oidcTokenPathSegmentRegex.MatchString(segment) {
+			return fmt.Errorf("%q can not be used as a directory name", segment)
+		}
+	}
+
+	uid, gid, err := tokenFileOwner(container)
+	if err != nil {
+		return err
+	}
+	token, err := service.mintToken(container, service.audience)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(append([]string{service.tokenDirectory}, segments...)...)
+	return writeFileAtomically(filepath.Join(dir, oidcTokenFileName), []byte(token), uid, gid)
+}
+
+// tokenFileOwner returns the uid and gid in the container's oidcTokenOwnerLabel, or -1 for each which is not set,
+// so that it stays the owner of the Local Endpoints process
+func tokenFileOwner(container *types.Container) (int, int, error) {
+	owner := container.Labels[oidcTokenOwnerLabel]
+	if owner == "" {
+		return -1, -1, nil
+	}
+
+	ids := []int{-1, -1}
+	for i, id := range strings.SplitN(owner, ":", 2) {
+		n, err := strconv.Atoi(id)
+		if err != nil || n < 0 {
+			return -1, -1, fmt.Errorf("Invalid %s label %q: expected a uid, or a uid and gid separated by a colon", oidcTokenOwnerLabel, owner)
+		}
+		ids[i] = n
+	}
+	return ids[0], ids[1], nil
+}
+
+// writeFileAtomically replaces the file, so that readers never see a partial write.
+// The file and its directory can only be read by their owner, since the file is a credential.
+// Unless they are -1, the file and its directory are given to uid and gid, which requires running as root.
+func writeFileAtomically(path string, bits []byte, uid, gid int) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directory for %s", path)
+	}
+
+	// TempFile creates the file with mode 0600
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	defer os.Remove(tmp.Name())
+
+	if uid != -1 || gid != -1 {
+		if err = os.Chown(dir, uid, gid); err != nil {
+			tmp.Close()
+			return errors.Wrapf(err, "failed to change the owner of %s", dir)
+		}
+		if err = tmp.Chown(uid, gid); err != nil {
+			tmp.Close()
+			return errors.Wrapf(err, "failed to change the owner of %s", path)
+		}
+	}
+	if _, err = tmp.Write(bits); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	return errors.Wrapf(os.Rename(tmp.Name(), path), "failed to write %s", path)
+}
+
+func newJWK(key *rsa.PublicKey, keyID string) JWK {
+	return JWK{
+		KeyType:   "RSA",
+		Use:       "sig",
+		Algorithm: oidcSigningAlgorithm,
+		KeyID:     keyID,
+		N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// jwkThumbprint returns the RFC 7638 thumbprint of the key, which is its key ID
+func jwkThumbprint(key *rsa.PublicKey) (string, error) {
+	jwk := newJWK(key, "")
+	// the members are required, in lexicographic order
+	bits, err := json.Marshal(struct {
+		E       string `json:"e"`
+		KeyType string `json:"kty"`
+		N       string `json:"n"`
+	}{jwk.E, jwk.KeyType, jwk.N})
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(bits)
+	return base64.RawURLEncoding.EncodeToString(digest[:]), nil
+}
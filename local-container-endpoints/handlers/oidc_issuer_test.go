@@ -0,0 +1,264 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker/mock_docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const testOIDCIssuer = "http://endpoints.local"
+
+func newOIDCIssuerInTest(t *testing.T) (*OIDCIssuerService, *mock_docker.MockClient, *mux.Router) {
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+	credentials := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+
+	os.Setenv(config.OIDCIssuerURLVar, testOIDCIssuer+"/")
+	defer os.Unsetenv(config.OIDCIssuerURLVar)
+	service, err := NewOIDCIssuerService(credentials)
+	assert.NoError(t, err, "Unexpected error creating OIDC issuer")
+
+	router := mux.NewRouter()
+	service.SetupRoutes(router)
+	return service, dockerMock, router
+}
+
+// verifyJWT checks the token's signature with the key from the JWKS, and returns its claims
+func verifyJWT(t *testing.T, token string, jwks *JWKS) *oidcClaims {
+	parts := strings.Split(token, ".")
+	assert.Len(t, parts, 3, "Expected a JWT with three parts")
+
+	header := &jwtHeader{}
+	bits, err := base64.RawURLEncoding.DecodeString(parts[0])
+	assert.NoError(t, err, "Unexpected error decoding header")
+	assert.NoError(t, json.Unmarshal(bits, header), "Unexpected error parsing header")
+	assert.Equal(t, "RS256", header.Algorithm, "Expected algorithm to match")
+	assert.Len(t, jwks.Keys, 1, "Expected one key")
+	assert.Equal(t, jwks.Keys[0].KeyID, header.KeyID, "Expected key ID to match")
+
+	n, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+	assert.NoError(t, err, "Unexpected error decoding modulus")
+	e, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].E)
+	assert.NoError(t, err, "Unexpected error decoding exponent")
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(t, err, "Unexpected error decoding signature")
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature), "Expected a valid signature")
+
+	claims := &oidcClaims{}
+	bits, err = base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(t, err, "Unexpected error decoding claims")
+	assert.NoError(t, json.Unmarshal(bits, claims), "Unexpected error parsing claims")
+	return claims
+}
+
+func getJWKS(t *testing.T, router *mux.Router) *JWKS {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, config.OIDCJWKSPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code 200")
+	jwks := &JWKS{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), jwks), "Unexpected error parsing JWKS")
+	return jwks
+}
+
+func TestOIDCDiscoveryDocument(t *testing.T) {
+	_, _, router := newOIDCIssuerInTest(t)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, config.OIDCDiscoveryPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code 200")
+
+	document := &OIDCDiscoveryDocument{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), document), "Unexpected error parsing discovery document")
+	assert.Equal(t, testOIDCIssuer, document.Issuer, "Expected issuer without a trailing slash")
+	assert.Equal(t, testOIDCIssuer+config.OIDCJWKSPath, document.JWKSURI, "Expected JWKS URI to match")
+	assert.Equal(t, []string{"RS256"}, document.IDTokenSigningAlgValuesSupported, "Expected signing algorithms to match")
+
+	jwks := getJWKS(t, router)
+	assert.Equal(t, "RSA", jwks.Keys[0].KeyType, "Expected an RSA key")
+	assert.Equal(t, "AQAB", jwks.Keys[0].E, "Expected exponent to match")
+}
+
+func TestOIDCTokenForCallerContainer(t *testing.T) {
+	_, dockerMock, router := newOIDCIssuerInTest(t)
+
+	caller := testingutils.BaseDockerContainer(containerName1, longID1).WithComposeProject(projectName).WithNetwork(network1, ipAddress1).Get()
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{caller}, nil).Times(2)
+
+	for audience, expected := range map[string]string{
+		"":           config.DefaultOIDCTokenAudience,
+		"localstack": "localstack",
+	} {
+		request := httptest.NewRequest(http.MethodGet, config.OIDCTokenPath+"?audience="+audience, nil)
+		request.RemoteAddr = ipAddress1 + ":34567"
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code 200")
+
+		claims := verifyJWT(t, recorder.Body.String(), getJWKS(t, router))
+		assert.Equal(t, testOIDCIssuer, claims.Issuer, "Expected issuer to match")
+		assert.Equal(t, fmt.Sprintf("compose:%s:ecs-local", projectName), claims.Subject, "Expected subject from the compose labels")
+		assert.Equal(t, expected, claims.Audience, "Expected audience to match")
+		assert.Equal(t, containerName1, claims.ContainerName, "Expected container name to match")
+		assert.Equal(t, int64(config.DefaultOIDCTokenDuration), claims.Expiration-claims.IssuedAt, "Expected the default duration")
+	}
+}
+
+func TestOIDCTokenIsAudited(t *testing.T) {
+	service, dockerMock, router := newOIDCIssuerInTest(t)
+	buf := &bytes.Buffer{}
+	service.credentials.auditLog = &auditLog{writers: []io.Writer{buf}}
+
+	// the audit record reuses the handler's lookup of the caller
+	caller := testingutils.BaseDockerContainer(containerName1, longID1).WithComposeProject(projectName).WithNetwork(network1, ipAddress1).Get()
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{caller}, nil).Times(1)
+
+	request := httptest.NewRequest(http.MethodGet, config.OIDCTokenPath, nil)
+	request.RemoteAddr = ipAddress1 + ":34567"
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code 200")
+	assert.NotContains(t, buf.String(), recorder.Body.String(), "Expected no token in the audit log")
+
+	records := readAuditRecords(t, buf.Bytes())
+	assert.Len(t, records, 1, "Expected a record for the token request")
+	record := records[0]
+	assert.Equal(t, config.OIDCTokenPath, record.Path, "Expected path to match")
+	assert.Equal(t, http.StatusOK, record.Status, "Expected status code 200")
+	assert.Equal(t, ipAddress1, record.CallerIP, "Expected caller IP to match")
+	assert.Equal(t, containerName1, record.ContainerName, "Expected container name to match")
+	assert.Equal(t, projectName, record.ComposeProject, "Expected Compose project to match")
+}
+
+func TestOIDCTokenUnknownCaller(t *testing.T) {
+	_, dockerMock, router := newOIDCIssuerInTest(t)
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{}, nil)
+
+	request := httptest.NewRequest(http.MethodGet, config.OIDCTokenPath, nil)
+	request.RemoteAddr = ipAddress1 + ":34567"
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "Expected status code 400")
+}
+
+func TestOIDCWriteTokenFiles(t *testing.T) {
+	service, dockerMock, router := newOIDCIssuerInTest(t)
+	dir, err := ioutil.TempDir("", "oidc")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	service.tokenDirectory = dir
+
+	compose := testingutils.BaseDockerContainer(containerName1, longID1).WithComposeProject(projectName).Get()
+	standalone := testingutils.BaseDockerContainer(containerName2, longID2).Get()
+	// containers whose names or labels would leave the token directory are skipped, and the rest still get tokens
+	traversal := testingutils.BaseDockerContainer("pudding", longID3).WithComposeProject("..").WithLabel(composeServiceNameLabel, "escape").Get()
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{traversal, compose, standalone}, nil)
+
+	service.now = func() time.Time { return time.Unix(1700000000, 0) }
+	assert.NoError(t, service.writeTokenFiles(), "Unexpected error writing token files")
+
+	jwks := getJWKS(t, router)
+	for file, subject := range map[string]string{
+		filepath.Join(dir, projectName, "ecs-local", "token"): fmt.Sprintf("compose:%s:ecs-local", projectName),
+		filepath.Join(dir, containerName2, "token"):           "container:" + containerName2,
+	} {
+		token, err := ioutil.ReadFile(file)
+		assert.NoError(t, err, "Expected token file %s", file)
+		info, err := os.Stat(file)
+		assert.NoError(t, err, "Expected token file %s", file)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Expected token file to be readable only by its owner")
+		claims := verifyJWT(t, string(token), jwks)
+		assert.Equal(t, subject, claims.Subject, "Expected subject to match")
+		assert.Equal(t, int64(1700000000), claims.IssuedAt, "Expected issue time to match")
+	}
+	_, err = os.Stat(filepath.Join(filepath.Dir(dir), "escape"))
+	assert.True(t, os.IsNotExist(err), "Expected no token outside the token directory")
+}
+
+func TestOIDCWriteTokenFilesWithOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing the owner of token files requires root")
+	}
+
+	service, dockerMock, _ := newOIDCIssuerInTest(t)
+	dir, err := ioutil.TempDir("", "oidc")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	service.tokenDirectory = dir
+
+	owned := testingutils.BaseDockerContainer(containerName1, longID1).WithLabel(oidcTokenOwnerLabel, "1000:1001").Get()
+	invalid := testingutils.BaseDockerContainer(containerName2, longID2).WithLabel(oidcTokenOwnerLabel, "pudding").Get()
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{owned, invalid}, nil)
+	assert.NoError(t, service.writeTokenFiles(), "Unexpected error writing token files")
+
+	for path, mode := range map[string]os.FileMode{
+		filepath.Join(dir, containerName1):          0700,
+		filepath.Join(dir, containerName1, "token"): 0600,
+	} {
+		info, err := os.Stat(path)
+		assert.NoError(t, err, "Expected %s", path)
+		stat := info.Sys().(*syscall.Stat_t)
+		assert.Equal(t, mode, info.Mode().Perm(), "Expected mode of %s to match", path)
+		assert.Equal(t, uint32(1000), stat.Uid, "Expected %s to belong to the uid in the label", path)
+		assert.Equal(t, uint32(1001), stat.Gid, "Expected %s to belong to the gid in the label", path)
+	}
+	_, err = os.Stat(filepath.Join(dir, containerName2, "token"))
+	assert.True(t, os.IsNotExist(err), "Expected no token for a container with an invalid owner")
+}
+
+func TestTokenFileOwner(t *testing.T) {
+	for owner, expected := range map[string][]int{
+		"":          {-1, -1},
+		"1000":      {1000, -1},
+		"1000:1001": {1000, 1001},
+	} {
+		container := testingutils.BaseDockerContainer(containerName1, longID1).WithLabel(oidcTokenOwnerLabel, owner).Get()
+		uid, gid, err := tokenFileOwner(&container)
+		assert.NoError(t, err, "Unexpected error for owner %q", owner)
+		assert.Equal(t, expected, []int{uid, gid}, "Expected uid and gid for owner %q", owner)
+	}
+
+	for _, owner := range []string{"clyde", "-1", "1000:", "1000:1001:1002"} {
+		container := testingutils.BaseDockerContainer(containerName1, longID1).WithLabel(oidcTokenOwnerLabel, owner).Get()
+		_, _, err := tokenFileOwner(&container)
+		assert.Error(t, err, "Expected an error for owner %q", owner)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// getPodIdentityHandler returns a handler which vends credentials in the format of the EKS Pod Identity Agent,
+// for the role in the labels of the container that made the request.
+// Like the agent, it always requires a token in the Authorization header, so a token must be configured for the role.
+func (service *CredentialService) getPodIdentityHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received Pod Identity credentials request")
+
+		container, err := service.findTaskRoleContainer(getCallerIP(r))
+		auditCaller(r, container)
+		if err != nil {
+			return err
+		}
+
+		var expected string
+		if service.authorization != nil {
+			expected = service.authorization.expectedToken(service.taskRoleKeys(container)...)
+		}
+		if expected == "" {
+			return HTTPError{
+				Code: http.StatusUnauthorized,
+				Err:  fmt.Errorf("Pod Identity credentials require a token: set %s, %s or a token for the role in AuthorizationTokens on the Local Endpoints container", config.AuthorizationTokenVar, config.AuthorizationTokenFileVar),
+			}
+		}
+		if err := checkToken(r, expected); err != nil {
+			return err
+		}
+
+		response, err := service.getTaskRoleCredentialsForContainer(container)
+		if err != nil {
+			return err
+		}
+
+		auditCredentials(r, response)
+		podIdentityResponse, err := newPodIdentityCredentialResponse(response)
+		if err != nil {
+			return err
+		}
+
+		writeJSONResponse(w, podIdentityResponse)
+		return nil
+	}
+}
+
+func newPodIdentityCredentialResponse(response *CredentialResponse) (*PodIdentityCredentialResponse, error) {
+	roleArn, err := arn.Parse(response.RoleArn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find the account of role %s", response.RoleArn)
+	}
+
+	return &PodIdentityCredentialResponse{
+		AccessKeyID:     response.AccessKeyID,
+		SecretAccessKey: response.SecretAccessKey,
+		Token:           response.Token,
+		AccountID:       roleArn.AccountID,
+		Expiration:      response.Expiration,
+	}, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker/mock_docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// httptest.NewRequest sets this as the remote address
+	testRequestIP = "192.0.2.1"
+	podRoleARN    = "arn:aws:iam::222222222222:role/clyde_pod_role"
+)
+
+func newPodIdentityRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/v1/credentials", nil)
+	if token != "" {
+		req.Header.Set(authorizationHeader, token)
+	}
+	return req
+}
+
+func TestGetPodIdentityCredentials(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+
+	credsService := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+	credsService.authorization = &authorization{
+		token: authToken,
+	}
+
+	caller := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, testRequestIP).WithLabel(taskRoleArnLabel, podRoleARN).Get()
+	expiration, _ := time.Parse(CredentialExpirationTimeFormat, "2049-11-10T23:00:00Z")
+
+	gomock.InOrder(
+		dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{caller}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, podRoleARN, aws.StringValue(input.RoleArn), "Expected role ARN to match")
+		}).Return(&sts.AssumeRoleOutput{
+			Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String(accessKey),
+				SecretAccessKey: aws.String(secretKey),
+				SessionToken:    aws.String(sessionToken),
+				Expiration:      &expiration,
+			},
+		}, nil),
+	)
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, newPodIdentityRequest(authToken))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+	fields := map[string]string{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &fields)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+	assert.Equal(t, map[string]string{
+		"AccessKeyId":     accessKey,
+		"SecretAccessKey": secretKey,
+		"Token":           sessionToken,
+		"AccountId":       "222222222222",
+		"Expiration":      "2049-11-10T23:00:00Z",
+	}, fields, "Expected the Pod Identity Agent response format")
+}
+
+func TestGetPodIdentityCredentialsUnauthorized(t *testing.T) {
+	var testCases = []struct {
+		name  string
+		auth  *authorization
+		token string
+	}{
+		{
+			name: "missing token without configured token",
+			auth: &authorization{},
+		},
+		{
+			name:  "any token without configured token",
+			auth:  &authorization{},
+			token: "pudding",
+		},
+		{
+			name:  "any token without authorization",
+			token: "pudding",
+		},
+		{
+			name: "missing token",
+			auth: &authorization{token: authToken},
+		},
+		{
+			name:  "invalid token",
+			auth:  &authorization{token: authToken},
+			token: "pudding",
+		},
+		{
+			name: "global token for role with its own token",
+			auth: &authorization{
+				token:      authToken,
+				roleTokens: map[string]string{podRoleARN: roleAuthToken},
+			},
+			token: authToken,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			iamMock, stsMock := setupMocks(t)
+			dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+
+			credsService := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+			credsService.authorization = testCase.auth
+
+			caller := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, testRequestIP).WithLabel(taskRoleArnLabel, podRoleARN).Get()
+			dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{caller}, nil).AnyTimes()
+
+			router := mux.NewRouter()
+			credsService.SetupRoutes(router)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, newPodIdentityRequest(testCase.token))
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected status code to match")
+		})
+	}
+}
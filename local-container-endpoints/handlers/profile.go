@@ -0,0 +1,205 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// profileCacheKeyPrefix begins the keys of credentials cached for profiles
+const profileCacheKeyPrefix = "profile:"
+
+// profileSessions creates and caches a session for each profile in the shared AWS config files.
+// Each session's credentials provider caches and refreshes that profile's credentials, whether they
+// come from a source profile and role, SSO, or a credential process.
+type profileSessions struct {
+	lock         sync.Mutex
+	sessions     map[string]*session.Session
+	newSession   func(profile string) (*session.Session, error)
+	newSTSClient func(sess *session.Session) stsiface.STSAPI
+}
+
+func newProfileSessions(newSession func(profile string) (*session.Session, error)) *profileSessions {
+	return &profileSessions{
+		sessions:   make(map[string]*session.Session),
+		newSession: newSession,
+		newSTSClient: func(sess *session.Session) stsiface.STSAPI {
+			return newSTSClient(sess)
+		},
+	}
+}
+
+// get returns the session for the profile, creating it on first use
+func (profiles *profileSessions) get(profile string) (*session.Session, error) {
+	profiles.lock.Lock()
+	defer profiles.lock.Unlock()
+
+	if sess, ok := profiles.sessions[profile]; ok {
+		return sess, nil
+	}
+
+	// the SDK falls back to the default credential chain for a profile which does not exist
+	exists, err := sharedConfigProfileExists(profile)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, HTTPError{
+			Code: http.StatusNotFound,
+			Err:  fmt.Errorf("Profile %s is not in the shared AWS config files", profile),
+		}
+	}
+
+	logrus.Debugf("Creating a session for profile %s", profile)
+	sess, err := profiles.newSession(profile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create a session for profile %s", profile)
+	}
+
+	profiles.sessions[profile] = sess
+	return sess, nil
+}
+
+// clear drops every session, so that they are created again from the shared AWS config files
+func (profiles *profileSessions) clear() {
+	profiles.lock.Lock()
+	defer profiles.lock.Unlock()
+	profiles.sessions = make(map[string]*session.Session)
+}
+
+// sharedConfigFiles returns the paths of the shared config and credentials files, in the same way as the SDK
+func sharedConfigFiles() (configFile, credentialsFile string) {
+	home, _ := os.UserHomeDir()
+	configFile = utils.GetValue(filepath.Join(home, ".aws", "config"), "AWS_CONFIG_FILE")
+	credentialsFile = utils.GetValue(filepath.Join(home, ".aws", "credentials"), "AWS_SHARED_CREDENTIALS_FILE")
+	return configFile, credentialsFile
+}
+
+// sharedConfigProfileExists returns true if the profile has a section in either of the shared config files
+func sharedConfigProfileExists(profile string) (bool, error) {
+	configFile, credentialsFile := sharedConfigFiles()
+	for _, file := range []string{configFile, credentialsFile} {
+		profiles, err := readSharedConfigProfiles(file, file == configFile)
+		if err != nil {
+			return false, err
+		}
+		if _, ok := profiles[profile]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// readSharedConfigProfiles returns the settings of each profile in a shared config file, or nothing if it does not exist.
+// Profiles are "[profile name]" in the config file, except for the default profile, and "[name]" in the credentials file.
+func readSharedConfigProfiles(file string, isConfigFile bool) (map[string]map[string]string, error) {
+	profiles := make(map[string]map[string]string)
+	bits, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return profiles, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read shared AWS config file")
+	}
+
+	var settings map[string]string
+	for _, line := range strings.Split(string(bits), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			settings = nil
+			section := strings.TrimSpace(strings.Trim(line, "[]"))
+			if isConfigFile && section != "default" {
+				if !strings.HasPrefix(section, "profile ") {
+					continue
+				}
+				section = strings.TrimSpace(strings.TrimPrefix(section, "profile "))
+			}
+			if profiles[section] == nil {
+				profiles[section] = make(map[string]string)
+			}
+			settings = profiles[section]
+			continue
+		}
+
+		if settings == nil || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if i := strings.Index(line, "="); i > 0 {
+			settings[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return profiles, nil
+}
+
+// getProfileCredentials returns temporary credentials for the profile: the credentials from the profile if they
+// are already temporary, as for role, SSO and most credential process profiles, or else a session token
+func (service *CredentialService) getProfileCredentials(profile string) (*CredentialResponse, error) {
+	logrus.Debugf("Requesting credentials for profile %s", profile)
+
+	cacheKey := profileCacheKeyPrefix + profile
+	if service.offline != nil {
+		_, stsClient, _ := service.clients()
+		return service.getSessionToken(stsClient, cacheKey)
+	}
+	if service.profiles == nil {
+		return nil, HTTPError{
+			Code: http.StatusNotFound,
+			Err:  fmt.Errorf("Profile credentials are not available"),
+		}
+	}
+
+	sess, err := service.profiles.get(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get credentials for profile %s", profile)
+	}
+	if creds.SessionToken != "" {
+		return sessionCredentials(sess.Config.Credentials)
+	}
+
+	return service.getSessionToken(service.profiles.newSTSClient(sess), cacheKey)
+}
+
+// getProfileHandler returns a handler which vends temporary credentials for a profile in the shared AWS config files
+func (service *CredentialService) getProfileHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received profile credentials request")
+
+		response, err := service.getProfileCredentials(mux.Vars(r)["profile"])
+		if err != nil {
+			return err
+		}
+
+		auditCredentials(r, response)
+		writeJSONResponse(w, response)
+		return nil
+	}
+}
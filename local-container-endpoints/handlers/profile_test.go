@@ -0,0 +1,170 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/offline"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSharedConfig = `[default]
+region = us-west-2
+
+[profile dev]
+region = us-east-1
+
+[sso-session corp]
+sso_region = us-east-1
+`
+	testSharedCredentials = `[dev]
+aws_access_key_id = AKIDDEV
+aws_secret_access_key = devsecret
+
+[temporary]
+aws_access_key_id = ASIATEMPORARY
+aws_secret_access_key = temporarysecret
+aws_session_token = temporarytoken
+`
+)
+
+// setupSharedConfigFiles writes the shared config files and points the SDK at them
+func setupSharedConfigFiles(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "profiles")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+
+	configFile := filepath.Join(dir, "config")
+	credentialsFile := filepath.Join(dir, "credentials")
+	assert.NoError(t, ioutil.WriteFile(configFile, []byte(testSharedConfig), 0644), "Unexpected error writing config")
+	assert.NoError(t, ioutil.WriteFile(credentialsFile, []byte(testSharedCredentials), 0644), "Unexpected error writing credentials")
+
+	os.Setenv("AWS_CONFIG_FILE", configFile)
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	return func() {
+		os.Unsetenv("AWS_CONFIG_FILE")
+		os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
+		os.RemoveAll(dir)
+	}
+}
+
+// newProfileServiceInTest returns a service which calls sts:GetSessionToken for profiles at most once
+func newProfileServiceInTest(t *testing.T) *CredentialService {
+	iamMock, stsMock := setupMocks(t)
+	_, profileSTSMock := setupMocks(t)
+
+	service := newCredentialServiceInTest(iamMock, stsMock)
+	service.profiles = newProfileSessions(func(profile string) (*session.Session, error) {
+		return session.NewSessionWithOptions(session.Options{
+			Profile:           profile,
+			SharedConfigState: session.SharedConfigEnable,
+		})
+	})
+	service.profiles.newSTSClient = func(sess *session.Session) stsiface.STSAPI {
+		return profileSTSMock
+	}
+
+	expiration := time.Now().Add(time.Hour)
+	profileSTSMock.EXPECT().GetSessionToken(gomock.Any()).Return(&sts.GetSessionTokenOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil).MaxTimes(1)
+	return service
+}
+
+func TestGetProfileCredentialsSessionToken(t *testing.T) {
+	defer setupSharedConfigFiles(t)()
+	service := newProfileServiceInTest(t)
+
+	// the session token is only requested once
+	for i := 0; i < 2; i++ {
+		response, err := service.getProfileCredentials("dev")
+		assert.NoError(t, err, "Unexpected error calling getProfileCredentials")
+		assert.Equal(t, accessKey, response.AccessKeyID, "Expected access key to match")
+		assert.Equal(t, sessionToken, response.Token, "Expected session token to match")
+	}
+}
+
+func TestGetProfileCredentialsTemporary(t *testing.T) {
+	defer setupSharedConfigFiles(t)()
+	service := newProfileServiceInTest(t)
+
+	response, err := service.getProfileCredentials("temporary")
+	assert.NoError(t, err, "Unexpected error calling getProfileCredentials")
+	assert.Equal(t, "ASIATEMPORARY", response.AccessKeyID, "Expected the profile's credentials")
+	assert.Equal(t, "temporarytoken", response.Token, "Expected the profile's session token")
+	assert.NotEmpty(t, response.Expiration, "Expected an expiration")
+}
+
+func TestGetProfileCredentialsUnknownProfile(t *testing.T) {
+	defer setupSharedConfigFiles(t)()
+	service := newProfileServiceInTest(t)
+
+	router := mux.NewRouter()
+	service.SetupRoutes(router)
+
+	// sso-session sections are not profiles
+	for _, profile := range []string{"production", "corp"} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/profile/"+profile, nil))
+		assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected status code for %s", profile)
+	}
+}
+
+func TestSharedConfigProfileExists(t *testing.T) {
+	defer setupSharedConfigFiles(t)()
+
+	for profile, expected := range map[string]bool{
+		"default":    true,
+		"dev":        true,
+		"temporary":  true,
+		"corp":       false,
+		"production": false,
+	} {
+		exists, err := sharedConfigProfileExists(profile)
+		assert.NoError(t, err, "Unexpected error calling sharedConfigProfileExists")
+		assert.Equal(t, expected, exists, "Expected existence of %s to match", profile)
+	}
+}
+
+func TestGetProfileCredentialsOffline(t *testing.T) {
+	service := NewOfflineCredentialService(offline.New(callerAccountID, 0))
+
+	dev, err := service.getProfileCredentials("dev")
+	assert.NoError(t, err, "Unexpected error calling getProfileCredentials")
+	production, err := service.getProfileCredentials("production")
+	assert.NoError(t, err, "Unexpected error calling getProfileCredentials")
+	assert.NotEqual(t, dev.AccessKeyID, production.AccessKeyID, "Expected different credentials for each profile")
+
+	again, err := service.getProfileCredentials("dev")
+	assert.NoError(t, err, "Unexpected error calling getProfileCredentials")
+	assert.Equal(t, dev.AccessKeyID, again.AccessKeyID, "Expected cached credentials")
+}
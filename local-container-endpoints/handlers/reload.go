@@ -0,0 +1,146 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// getCredentialsReloadInterval returns how often to check for changes to the base credentials, or 0 to never check
+func getCredentialsReloadInterval() time.Duration {
+	intervalStr := utils.GetValue(fmt.Sprintf("%ds", config.DefaultCredentialsReloadInterval), config.CredentialsReloadIntervalVar)
+	interval, err := utils.ParseDuration(intervalStr)
+
+	if err != nil || interval < 0 {
+		logrus.Warnf(
+			"Could not parse %s value, defaulting to %d seconds: %s",
+			config.CredentialsReloadIntervalVar, config.DefaultCredentialsReloadInterval, intervalStr)
+		interval = config.DefaultCredentialsReloadInterval * time.Second
+	}
+
+	return interval
+}
+
+// baseCredentialsFingerprint returns a hash of the files which the default credential chain reads: the shared config
+// and credentials files, the web identity token file, and the SSO token cache which 'aws sso login' writes to.
+// The AWS_ environment variables can not change while the process runs, so changing them needs a restart.
+func baseCredentialsFingerprint() string {
+	hash := sha256.New()
+
+	configFile, credentialsFile := sharedConfigFiles()
+	files := []string{configFile, credentialsFile}
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" {
+		files = append(files, tokenFile)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		ssoCache := filepath.Join(home, ".aws", "sso", "cache")
+		if entries, err := ioutil.ReadDir(ssoCache); err == nil {
+			for _, entry := range entries {
+				files = append(files, filepath.Join(ssoCache, entry.Name()))
+			}
+		}
+	}
+
+	for _, file := range files {
+		fmt.Fprintln(hash, file)
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(hash, "missing")
+			continue
+		}
+		io.Copy(hash, f)
+		f.Close()
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// watchBaseCredentials reloads the base credentials whenever their fingerprint changes
+func (service *CredentialService) watchBaseCredentials(interval time.Duration) {
+	logrus.Debugf("Checking for changes to the base credentials every %s", interval)
+	fingerprint := baseCredentialsFingerprint()
+
+	go func() {
+		for range time.Tick(interval) {
+			next := baseCredentialsFingerprint()
+			if next == fingerprint {
+				continue
+			}
+			fingerprint = next
+
+			logrus.Info("The shared AWS config files or credentials changed, reloading the base credentials")
+			service.reloadBaseCredentials()
+		}
+	}()
+}
+
+// reloadBaseCredentials rebuilds the base session and clients, and the profile sessions, and drops the credentials
+// cached for the profiles, whose keys may have changed even if the base identity did not. If the base identity changed,
+// the credentials which were derived from the old identity are dropped: the cache, the caller identity, the roles
+// found in its account and the MFA session.
+func (service *CredentialService) reloadBaseCredentials() error {
+	sess, err := service.newSession()
+	if err != nil {
+		logrus.Errorf("Failed to reload the base credentials, continuing with the old ones: %s", err)
+		return err
+	}
+	service.setSession(sess)
+	if service.profiles != nil {
+		service.profiles.clear()
+	}
+	service.cache.clearPrefix(profileCacheKeyPrefix)
+
+	_, stsClient, _ := service.clients()
+	identity := service.identity
+	identity.lock.Lock()
+	defer identity.lock.Unlock()
+
+	previous := identity.arn
+	identity.arn, identity.partition, identity.account = "", "", ""
+	output, err := stsClient.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		logrus.Warnf("Failed to get the identity of the reloaded credentials: %s", err)
+	} else if err = identity.set(output); err != nil {
+		logrus.Warn(err)
+	}
+
+	if previous != "" && identity.arn == previous {
+		logrus.Infof("Reloaded the base credentials for %s", previous)
+		return nil
+	}
+
+	current := identity.arn
+	if current == "" {
+		current = "an unknown identity"
+	}
+	service.cache.clear()
+	identity.rolesWithPaths = nil
+	if service.mfa != nil {
+		service.mfa.end()
+	}
+	logrus.Infof("Reloaded the base credentials for %s, and dropped the credentials derived from the previous identity", current)
+	return nil
+}
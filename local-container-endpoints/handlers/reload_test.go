@@ -0,0 +1,121 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var credentialScopeRegex = regexp.MustCompile(`Credential=(\w+)/`)
+
+// newFakeSTSServer answers sts:GetCallerIdentity with a user named after the access key which signed the request
+func newFakeSTSServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessKey := ""
+		if match := credentialScopeRegex.FindStringSubmatch(r.Header.Get("Authorization")); match != nil {
+			accessKey = match[1]
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:iam::111111111111:user/%s</Arn>
+    <UserId>%s</UserId>
+    <Account>111111111111</Account>
+  </GetCallerIdentityResult>
+  <ResponseMetadata>
+    <RequestId>01234567-89ab-cdef-0123-456789abcdef</RequestId>
+  </ResponseMetadata>
+</GetCallerIdentityResponse>`, accessKey, accessKey)
+	}))
+}
+
+func writeDefaultCredentials(t *testing.T, path, accessKeyID string) {
+	credentials := fmt.Sprintf("[default]\naws_access_key_id = %s\naws_secret_access_key = secret\n", accessKeyID)
+	err := ioutil.WriteFile(path, []byte(credentials), 0644)
+	assert.NoError(t, err, "Unexpected error writing credentials")
+}
+
+func TestReloadBaseCredentials(t *testing.T) {
+	server := newFakeSTSServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "reload")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	credentialsFile := filepath.Join(dir, "credentials")
+	writeDefaultCredentials(t, credentialsFile, "AKIDALICE")
+
+	for key, value := range map[string]string{
+		"AWS_CONFIG_FILE":             filepath.Join(dir, "config"),
+		"AWS_SHARED_CREDENTIALS_FILE": credentialsFile,
+		"AWS_REGION":                  "us-west-2",
+		config.STSCustomEndpointVar:   server.URL,
+	} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	service, err := newAWSCredentialService()
+	assert.NoError(t, err, "Unexpected error creating service")
+
+	_, account, err := service.callerAccount()
+	assert.NoError(t, err, "Unexpected error calling callerAccount")
+	assert.Equal(t, "111111111111", account, "Expected account to match")
+	assert.Equal(t, "arn:aws:iam::111111111111:user/AKIDALICE", service.identity.arn, "Expected the identity of the first credentials")
+
+	fetches := 0
+	fetch := func() (*CredentialResponse, time.Time, error) {
+		fetches++
+		return &CredentialResponse{AccessKeyID: accessKey}, time.Now().Add(time.Hour), nil
+	}
+	service.cache.get("role:clyde", fetch)
+	service.cache.get(profileCacheKeyPrefix+"clyde", fetch)
+
+	// the same identity with rotated secrets keeps the cached credentials, except for those of profiles
+	fingerprint := baseCredentialsFingerprint()
+	err = ioutil.WriteFile(credentialsFile, []byte("[default]\naws_access_key_id = AKIDALICE\naws_secret_access_key = rotated\n"), 0644)
+	assert.NoError(t, err, "Unexpected error writing credentials")
+	assert.NotEqual(t, fingerprint, baseCredentialsFingerprint(), "Expected the fingerprint to change")
+
+	err = service.reloadBaseCredentials()
+	assert.NoError(t, err, "Unexpected error reloading credentials")
+	service.cache.get("role:clyde", fetch)
+	assert.Equal(t, 2, fetches, "Expected cached credentials to be kept")
+	service.cache.get(profileCacheKeyPrefix+"clyde", fetch)
+	assert.Equal(t, 3, fetches, "Expected cached profile credentials to be dropped")
+
+	// a new identity drops them
+	writeDefaultCredentials(t, credentialsFile, "AKIDBOB")
+	err = service.reloadBaseCredentials()
+	assert.NoError(t, err, "Unexpected error reloading credentials")
+	assert.Equal(t, "arn:aws:iam::111111111111:user/AKIDBOB", service.identity.arn, "Expected the identity of the new credentials")
+	service.cache.get("role:clyde", fetch)
+	assert.Equal(t, 4, fetches, "Expected cached credentials to be dropped")
+
+	_, _, currentSession := service.clients()
+	value, err := currentSession.Config.Credentials.Get()
+	assert.NoError(t, err, "Unexpected error getting credentials")
+	assert.Equal(t, "AKIDBOB", value.AccessKeyID, "Expected the session to use the new credentials")
+}
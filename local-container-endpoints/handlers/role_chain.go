@@ -0,0 +1,86 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/docker/docker/api/types"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// STS limits sessions obtained by role chaining to one hour
+const chainedSessionDurationInS = 3600
+
+// getRoleChainHandler returns a handler which vends credentials for the last role in a configured chain
+func (service *CredentialService) getRoleChainHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received role chain credentials request")
+
+		vars := mux.Vars(r)
+		response, err := service.getRoleChainCredentials(vars["chain"], service.lookupCallerContainer(r))
+		if err != nil {
+			return err
+		}
+
+		auditCredentials(r, response)
+		writeJSONResponse(w, response)
+		return nil
+	}
+}
+
+// getRoleChainCredentials assumes each role in the chain with the credentials of the previous role,
+// starting from the base credentials. Each hop is cached separately, so that chains which share
+// a prefix also share the credentials for it.
+func (service *CredentialService) getRoleChainCredentials(chainName string, caller *types.Container) (*CredentialResponse, error) {
+	roleArns := service.credsConfig.RoleChains[chainName]
+	if len(roleArns) == 0 {
+		return nil, HTTPError{
+			Code: http.StatusNotFound,
+			Err:  fmt.Errorf("Role chain %s is not defined in the credentials configuration", chainName),
+		}
+	}
+
+	_, stsClient, err := service.baseClients()
+	if err != nil {
+		return nil, err
+	}
+
+	var response *CredentialResponse
+	cacheKey := "chain"
+	for hop, roleArn := range roleArns {
+		// sessions after the first hop are limited by role chaining rather than by the role
+		var maxSessionDuration int64
+		if hop > 0 {
+			_, stsClient = service.clientsWithCredentials(credentials.NewStaticCredentials(response.AccessKeyID, response.SecretAccessKey, response.Token))
+			maxSessionDuration = chainedSessionDurationInS
+		}
+		input, err := service.newAssumeRoleInput(roleArn, roleNameFromArn(roleArn), caller, maxSessionDuration)
+		if err != nil {
+			return nil, err
+		}
+
+		cacheKey = fmt.Sprintf("%s>%s", cacheKey, input.String())
+		response, err = service.assumeRole(stsClient, cacheKey, input)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to assume role %s in chain %s", roleArn, chainName)
+		}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	hubRoleARN      = "arn:aws:iam::111111111111:role/hub"
+	workloadRoleARN = "arn:aws:iam::222222222222:role/workload"
+	hubAccessKey    = "HUBAKID"
+	chainName       = "clyde-chain"
+)
+
+func TestGetRoleChainCredentials(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	_, hubSTSMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.credsConfig = &config.CredentialsConfig{
+		RoleDefaults: config.RoleConfig{
+			DurationSeconds: 7200,
+		},
+		RoleChains: map[string][]string{
+			chainName: {hubRoleARN, workloadRoleARN},
+		},
+	}
+	credsService.newClients = func(creds *credentials.Credentials) (iamiface.IAMAPI, stsiface.STSAPI) {
+		value, err := creds.Get()
+		assert.NoError(t, err, "Unexpected error getting hub credentials")
+		assert.Equal(t, hubAccessKey, value.AccessKeyID, "Expected the next hop to use the hub credentials")
+		return nil, hubSTSMock
+	}
+
+	expiration := time.Now().Add(time.Hour)
+
+	gomock.InOrder(
+		iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn:                aws.String(hubRoleARN),
+				MaxSessionDuration: aws.Int64(43200),
+			},
+		}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, hubRoleARN, aws.StringValue(input.RoleArn), "Expected hub role ARN to match")
+			assert.Equal(t, int64(7200), aws.Int64Value(input.DurationSeconds), "Expected the first hop to use the configured duration")
+		}).Return(&sts.AssumeRoleOutput{
+			Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String(hubAccessKey),
+				SecretAccessKey: aws.String(secretKey),
+				SessionToken:    aws.String(sessionToken),
+				Expiration:      &expiration,
+			},
+		}, nil).Times(1),
+		hubSTSMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, workloadRoleARN, aws.StringValue(input.RoleArn), "Expected workload role ARN to match")
+			assert.Equal(t, "ecs-local-workload", aws.StringValue(input.RoleSessionName), "Expected session name to match")
+			assert.Equal(t, int64(3600), aws.Int64Value(input.DurationSeconds), "Expected chained session to be limited to one hour")
+		}).Return(&sts.AssumeRoleOutput{
+			Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String(accessKey),
+				SecretAccessKey: aws.String(secretKey),
+				SessionToken:    aws.String(sessionToken),
+				Expiration:      &expiration,
+			},
+		}, nil).Times(1),
+	)
+
+	// the second request is served from the cache
+	for i := 0; i < 2; i++ {
+		response, err := credsService.getRoleChainCredentials(chainName, nil)
+		assert.NoError(t, err, "Unexpected error calling getRoleChainCredentials")
+		assert.Equal(t, accessKey, response.AccessKeyID, "Expected access key to match")
+		assert.Equal(t, workloadRoleARN, response.RoleArn, "Expected role ARN to match")
+	}
+}
+
+func TestGetRoleChainCredentialsUndefinedChain(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	_, err := credsService.getRoleChainCredentials(chainName, nil)
+	assert.Error(t, err, "Expected error for an undefined chain")
+	assert.Equal(t, http.StatusNotFound, err.(HTTPError).Status(), "Expected status code to match")
+}
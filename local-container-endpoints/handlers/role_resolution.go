@@ -0,0 +1,217 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var accountIDRegex = regexp.MustCompile(`^\d{12}$`)
+
+// callerIdentity caches the result of sts:GetCallerIdentity for the base credentials
+type callerIdentity struct {
+	lock      sync.Mutex
+	arn       string
+	partition string
+	account   string
+	// rolesWithPaths are the roles in the account which STS denied without a path, by name, as found with iam:GetRole
+	rolesWithPaths map[string]roleWithPath
+}
+
+// roleWithPath is a role whose ARN could not be built from its name
+type roleWithPath struct {
+	arn                string
+	maxSessionDuration int64
+}
+
+// roleWithPath returns the role with a path which has the given name, if one was found
+func (identity *callerIdentity) roleWithPath(roleName string) (roleWithPath, bool) {
+	identity.lock.Lock()
+	defer identity.lock.Unlock()
+	role, ok := identity.rolesWithPaths[roleName]
+	return role, ok
+}
+
+// setRoleWithPath records the role with a path which has the given name, so that later requests assume it directly
+func (identity *callerIdentity) setRoleWithPath(roleName string, role roleWithPath) {
+	identity.lock.Lock()
+	defer identity.lock.Unlock()
+	if identity.rolesWithPaths == nil {
+		identity.rolesWithPaths = make(map[string]roleWithPath)
+	}
+	identity.rolesWithPaths[roleName] = role
+}
+
+// set records the identity from the sts:GetCallerIdentity output; the lock must be held
+func (identity *callerIdentity) set(output *sts.GetCallerIdentityOutput) error {
+	callerArn, err := arn.Parse(aws.StringValue(output.Arn))
+	if err != nil {
+		return errors.Wrap(err, "failed to parse caller identity ARN")
+	}
+
+	identity.arn = aws.StringValue(output.Arn)
+	identity.partition = callerArn.Partition
+	identity.account = aws.StringValue(output.Account)
+	return nil
+}
+
+func getRoleResolution() string {
+	mode := utils.GetValue(config.DefaultRoleResolution, config.RoleResolutionVar)
+	switch mode {
+	case config.RoleResolutionIAM, config.RoleResolutionCallerIdentity:
+		return mode
+	default:
+		logrus.Warnf("Unknown %s value %s, defaulting to %s", config.RoleResolutionVar, mode, config.DefaultRoleResolution)
+		return config.DefaultRoleResolution
+	}
+}
+
+// callerAccount returns the partition and account ID of the base credentials
+func (service *CredentialService) callerAccount() (string, string, error) {
+	identity := service.identity
+	identity.lock.Lock()
+	defer identity.lock.Unlock()
+
+	if identity.account != "" {
+		return identity.partition, identity.account, nil
+	}
+
+	_, stsClient, _ := service.clients()
+	output, err := stsClient.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to get caller identity")
+	}
+	if err = identity.set(output); err != nil {
+		return "", "", err
+	}
+	return identity.partition, identity.account, nil
+}
+
+// roleArnInAccount builds the ARN of a role without a path in the given account
+func (service *CredentialService) roleArnInAccount(account, roleName string) (string, error) {
+	partition, callerAccount, err := service.callerAccount()
+	if err != nil {
+		return "", err
+	}
+	if account == "" {
+		account = callerAccount
+	}
+
+	return arn.ARN{
+		Partition: partition,
+		Service:   iam.ServiceName,
+		AccountID: account,
+		Resource:  "role/" + roleName,
+	}.String(), nil
+}
+
+// aliasAccount returns the account ID for an alias from the credentials configuration, or else the value as it is
+func (service *CredentialService) aliasAccount(accountOrAlias string) string {
+	if aliasAccount, ok := service.credsConfig.AccountAliases[accountOrAlias]; ok {
+		return aliasAccount
+	}
+	return accountOrAlias
+}
+
+// accountRoleArn returns the ARN of a role in the account given by ID or by an alias from the credentials configuration
+func (service *CredentialService) accountRoleArn(accountOrAlias, roleName string) (string, error) {
+	account := service.aliasAccount(accountOrAlias)
+	if !accountIDRegex.MatchString(account) {
+		return "", HTTPError{
+			Code: http.StatusNotFound,
+			Err:  fmt.Errorf("%s is neither an AWS account ID nor an account alias in the credentials configuration", accountOrAlias),
+		}
+	}
+
+	return service.roleArnInAccount(account, roleName)
+}
+
+// getRoleCredentialsFromCallerIdentity assumes the role with the given name in the account of the base credentials,
+// without calling iam:GetRole. The ARN is built for a role without a path, so if STS denies access, the role is
+// looked up with iam:GetRole in case it has one, and the ARN it has is used for every later request.
+func (service *CredentialService) getRoleCredentialsFromCallerIdentity(roleName string, caller *types.Container) (*CredentialResponse, error) {
+	if role, ok := service.identity.roleWithPath(roleName); ok {
+		return service.getRoleCredentialsFromArn(role.arn, roleName, caller, role.maxSessionDuration)
+	}
+
+	roleArn, err := service.roleArnInAccount("", roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := service.getRoleCredentialsFromArn(roleArn, roleName, caller, 0)
+	if aerr, ok := errors.Cause(err).(awserr.Error); !ok || aerr.Code() != "AccessDenied" {
+		return response, err
+	}
+
+	logrus.Debugf("Access denied assuming %s, looking up the role in case it has a path", roleArn)
+	iamClient, _, clientErr := service.baseClients()
+	if clientErr != nil {
+		return nil, err
+	}
+	output, getRoleErr := iamClient.GetRole(&iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if getRoleErr != nil {
+		logrus.Debugf("Failed to look up role %s: %s", roleName, getRoleErr)
+		return nil, err
+	}
+	if aws.StringValue(output.Role.Arn) == roleArn {
+		return nil, err
+	}
+
+	role := roleWithPath{
+		arn:                aws.StringValue(output.Role.Arn),
+		maxSessionDuration: aws.Int64Value(output.Role.MaxSessionDuration),
+	}
+	service.identity.setRoleWithPath(roleName, role)
+	return service.getRoleCredentialsFromArn(role.arn, roleName, caller, role.maxSessionDuration)
+}
+
+// getAccountRoleHandler returns a handler which vends credentials for a role in an account given by ID or alias
+func (service *CredentialService) getAccountRoleHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received role credentials request using account")
+
+		vars := mux.Vars(r)
+		roleArn, err := service.accountRoleArn(vars["account"], vars["role"])
+		if err != nil {
+			return err
+		}
+
+		response, err := service.getRoleCredentialsFromArn(roleArn, vars["role"], service.lookupCallerContainer(r), 0)
+		if err != nil {
+			return err
+		}
+
+		auditCredentials(r, response)
+		writeJSONResponse(w, response)
+		return nil
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/sts/mock_stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	callerAccountID = "111111111111"
+	callerARN       = "arn:aws-us-gov:iam::111111111111:user/clyde"
+	callerRoleARN   = "arn:aws-us-gov:iam::111111111111:role/clyde_task_role"
+)
+
+func expectGetCallerIdentity(stsMock *mock_stsiface.MockSTSAPI) *gomock.Call {
+	return stsMock.EXPECT().GetCallerIdentity(gomock.Any()).Return(&sts.GetCallerIdentityOutput{
+		Account: aws.String(callerAccountID),
+		Arn:     aws.String(callerARN),
+	}, nil).Times(1)
+}
+
+func assumeRoleOutput(expiration time.Time) *sts.AssumeRoleOutput {
+	return &sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}
+}
+
+func TestGetRoleCredentialsFromCallerIdentity(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.roleResolution = config.RoleResolutionCallerIdentity
+
+	expiration := time.Now().Add(time.Hour)
+
+	// the caller identity is only requested once
+	gomock.InOrder(
+		expectGetCallerIdentity(stsMock),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, callerRoleARN, aws.StringValue(input.RoleArn), "Expected role ARN to match")
+		}).Return(assumeRoleOutput(expiration), nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, "arn:aws-us-gov:iam::111111111111:role/pudding", aws.StringValue(input.RoleArn), "Expected role ARN to match")
+		}).Return(assumeRoleOutput(expiration), nil),
+	)
+
+	response, err := credsService.getRoleCredentials(roleName, nil)
+	assert.NoError(t, err, "Unexpected error calling getRoleCredentials")
+	assert.Equal(t, callerRoleARN, response.RoleArn, "Expected role ARN to match")
+
+	response, err = credsService.getRoleCredentials("pudding", nil)
+	assert.NoError(t, err, "Unexpected error calling getRoleCredentials")
+	assert.Equal(t, accessKey, response.AccessKeyID, "Expected access key to match")
+}
+
+func TestGetRoleCredentialsFromCallerIdentityRoleWithPath(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.roleResolution = config.RoleResolutionCallerIdentity
+
+	const pathRoleARN = "arn:aws-us-gov:iam::111111111111:role/service-role/clyde_task_role"
+	expiration := time.Now().Add(time.Hour)
+
+	gomock.InOrder(
+		expectGetCallerIdentity(stsMock),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Return(nil, awserr.New("AccessDenied", "not authorized to perform sts:AssumeRole", nil)),
+		iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn: aws.String(pathRoleARN),
+			},
+		}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, pathRoleARN, aws.StringValue(input.RoleArn), "Expected role ARN to match")
+		}).Return(assumeRoleOutput(expiration), nil),
+	)
+
+	response, err := credsService.getRoleCredentials(roleName, nil)
+	assert.NoError(t, err, "Unexpected error calling getRoleCredentials")
+	assert.Equal(t, pathRoleARN, response.RoleArn, "Expected role ARN to match")
+}
+
+func TestGetRoleCredentialsFromCallerIdentityRoleWithPathIsRemembered(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.roleResolution = config.RoleResolutionCallerIdentity
+
+	const pathRoleARN = "arn:aws-us-gov:iam::111111111111:role/service-role/clyde_task_role"
+	expiration := time.Now().Add(time.Hour)
+
+	// STS denies the ARN without a path, and IAM finds the role, only for the first request
+	gomock.InOrder(
+		expectGetCallerIdentity(stsMock),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Return(nil, awserr.New("AccessDenied", "not authorized to perform sts:AssumeRole", nil)).Times(1),
+		iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn: aws.String(pathRoleARN),
+			},
+		}, nil).Times(1),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, pathRoleARN, aws.StringValue(input.RoleArn), "Expected role ARN to match")
+		}).Return(assumeRoleOutput(expiration), nil).Times(1),
+	)
+
+	for i := 0; i < 5; i++ {
+		response, err := credsService.getRoleCredentials(roleName, nil)
+		assert.NoError(t, err, "Unexpected error calling getRoleCredentials")
+		assert.Equal(t, pathRoleARN, response.RoleArn, "Expected role ARN to match")
+	}
+}
+
+func TestGetRoleCredentialsFromCallerIdentityWithoutIAMAccess(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.roleResolution = config.RoleResolutionCallerIdentity
+
+	gomock.InOrder(
+		expectGetCallerIdentity(stsMock),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Return(nil, awserr.New("AccessDenied", "not authorized to perform sts:AssumeRole", nil)),
+		iamMock.EXPECT().GetRole(gomock.Any()).Return(nil, awserr.New("AccessDenied", "not authorized to perform iam:GetRole", nil)),
+	)
+
+	_, err := credsService.getRoleCredentials(roleName, nil)
+	assert.Error(t, err, "Expected error calling getRoleCredentials")
+	assert.Contains(t, err.Error(), "sts:AssumeRole", "Expected the error from STS")
+}
+
+func TestGetAccountRoleCredentials(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.credsConfig = &config.CredentialsConfig{
+		AccountAliases: map[string]string{
+			"sandbox": "222222222222",
+		},
+	}
+
+	expiration := time.Now().Add(time.Hour)
+
+	gomock.InOrder(
+		expectGetCallerIdentity(stsMock),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, "arn:aws-us-gov:iam::222222222222:role/app", aws.StringValue(input.RoleArn), "Expected role ARN to match")
+		}).Return(assumeRoleOutput(expiration), nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, "arn:aws-us-gov:iam::333333333333:role/app", aws.StringValue(input.RoleArn), "Expected role ARN to match")
+		}).Return(assumeRoleOutput(expiration), nil),
+	)
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	for _, path := range []string{"/role/sandbox/app", "/role/333333333333/app/"} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+		response := &CredentialResponse{}
+		err := json.Unmarshal(recorder.Body.Bytes(), response)
+		assert.NoError(t, err, "Unexpected error unmarshalling response")
+		assert.Equal(t, accessKey, response.AccessKeyID, "Expected access key to match")
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/role/production/app", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected status code for an unknown alias")
+}
+
+func TestGetAccountRoleCredentialsUnauthorized(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.credsConfig = &config.CredentialsConfig{
+		AccountAliases: map[string]string{
+			"sandbox": "222222222222",
+		},
+	}
+	credsService.authorization = &authorization{
+		token: authToken,
+		roleTokens: map[string]string{
+			"arn:aws-us-gov:iam::222222222222:role/app": roleAuthToken,
+		},
+	}
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	// no AWS APIs are called before the request is authorized
+	for _, path := range []string{"/role/sandbox/app", "/role/222222222222/app", "/role/333333333333/app"} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected status code for %s to match", path)
+	}
+
+	// the role ARN token is only required for the role in its account
+	for _, path := range []string{"/role/sandbox/app", "/role/222222222222/app"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(authorizationHeader, authToken)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected status code for %s to match", path)
+	}
+
+	expiration := time.Now().Add(time.Hour)
+	gomock.InOrder(
+		expectGetCallerIdentity(stsMock),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Return(assumeRoleOutput(expiration), nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Return(assumeRoleOutput(expiration), nil),
+	)
+	for path, token := range map[string]string{"/role/sandbox/app": roleAuthToken, "/role/333333333333/app": authToken} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(authorizationHeader, token)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code for %s to match", path)
+	}
+}
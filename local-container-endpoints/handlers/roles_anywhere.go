@@ -0,0 +1,105 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/rolesanywhere"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// getRolesAnywhereHandler returns a handler which vends credentials for a configured IAM Roles Anywhere certificate
+func (service *CredentialService) getRolesAnywhereHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received IAM Roles Anywhere credentials request")
+
+		response, err := service.getRolesAnywhereCredentials(mux.Vars(r)["profile"])
+		if err != nil {
+			return err
+		}
+
+		auditCredentials(r, response)
+		writeJSONResponse(w, response)
+		return nil
+	}
+}
+
+// getRolesAnywhereCredentials exchanges the configured certificate for credentials with IAM Roles Anywhere, which needs no base credentials.
+// The certificate is read on every request, and credentials are cached for each certificate, so a renewed certificate is used right away.
+func (service *CredentialService) getRolesAnywhereCredentials(name string) (*CredentialResponse, error) {
+	profile, ok := service.credsConfig.RolesAnywhere[name]
+	if !ok {
+		return nil, HTTPError{
+			Code: http.StatusNotFound,
+			Err:  fmt.Errorf("IAM Roles Anywhere profile %s is not defined in the credentials configuration", name),
+		}
+	}
+	for setting, value := range map[string]string{
+		"Certificate":    profile.Certificate,
+		"PrivateKey":     profile.PrivateKey,
+		"TrustAnchorArn": profile.TrustAnchorArn,
+		"ProfileArn":     profile.ProfileArn,
+		"RoleArn":        profile.RoleArn,
+	} {
+		if value == "" {
+			return nil, fmt.Errorf("IAM Roles Anywhere profile %s has no %s", name, setting)
+		}
+	}
+
+	signer, err := rolesanywhere.LoadSigner(profile.Certificate, profile.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load the certificate for IAM Roles Anywhere profile %s", name)
+	}
+
+	input := &rolesanywhere.CreateSessionInput{
+		ProfileArn:      profile.ProfileArn,
+		RoleArn:         profile.RoleArn,
+		RoleSessionName: profile.SessionName,
+		TrustAnchorArn:  profile.TrustAnchorArn,
+	}
+	if profile.DurationSeconds > 0 {
+		input.DurationSeconds = aws.Int64(profile.DurationSeconds)
+	}
+
+	certificateHash := sha256.Sum256(signer.Certificate.Raw)
+	cacheKey := fmt.Sprintf("roles-anywhere:%s:%s", name, hex.EncodeToString(certificateHash[:]))
+	return service.cache.get(cacheKey, func() (*CredentialResponse, time.Time, error) {
+		logrus.Debugf("Creating an IAM Roles Anywhere session for role %s with certificate %s", profile.RoleArn, signer.Certificate.Subject)
+		output, err := service.rolesAnywhere.CreateSession(input, signer)
+		if err != nil {
+			return nil, time.Time{}, errors.Wrapf(err, "failed to create IAM Roles Anywhere session for profile %s", name)
+		}
+		if len(output.CredentialSet) == 0 || output.CredentialSet[0].Credentials == nil {
+			return nil, time.Time{}, fmt.Errorf("IAM Roles Anywhere returned no credentials for profile %s", name)
+		}
+
+		creds := output.CredentialSet[0].Credentials
+		return &CredentialResponse{
+			AccessKeyID:     creds.AccessKeyID,
+			SecretAccessKey: creds.SecretAccessKey,
+			RoleArn:         profile.RoleArn,
+			Token:           creds.SessionToken,
+			Expiration:      creds.Expiration.Format(CredentialExpirationTimeFormat),
+			sessionName:     profile.SessionName,
+		}, creds.Expiration, nil
+	})
+}
@@ -0,0 +1,304 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/offline"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/rolesanywhere"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	trustAnchorARN       = "arn:aws:rolesanywhere:us-west-2:111111111111:trust-anchor/4b38e2d3-4a39-4c4d-9aa3-2c2a1b1e2d3f"
+	rolesAnywhereProfile = "arn:aws:rolesanywhere:us-west-2:111111111111:profile/2ac4ec7f-3c5a-4b5b-8b35-bb8b6c1d6e7f"
+	certificateSerial    = 123456789
+)
+
+var authorizationRegex = regexp.MustCompile(`^(AWS4-X509-[A-Z]+-SHA256) Credential=([0-9]+)/([0-9]{8}/[a-z0-9-]+/rolesanywhere/aws4_request), SignedHeaders=([a-z0-9;-]+), Signature=([0-9a-f]+)$`)
+
+// writeCertificate writes a self-signed certificate for the key, followed by a chain certificate if chain is set,
+// and the key to PEM files
+func writeCertificate(t *testing.T, dir string, key crypto.Signer, chain bool) (string, string) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(certificateSerial),
+		Subject:      pkix.Name{CommonName: "workload.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.NoError(t, err, "Unexpected error creating certificate")
+	certificates := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if chain {
+		certificates = append(certificates, certificates...)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err, "Unexpected error marshalling key")
+
+	certificatePath := filepath.Join(dir, "certificate.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	assert.NoError(t, ioutil.WriteFile(certificatePath, certificates, 0644), "Unexpected error writing certificate")
+	assert.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600), "Unexpected error writing key")
+	return certificatePath, keyPath
+}
+
+// newRolesAnywhereServer returns a stand-in for IAM Roles Anywhere which verifies the signature of each request
+// with the certificate in it, and records the bodies
+func newRolesAnywhereServer(t *testing.T) (*httptest.Server, *[]rolesanywhere.CreateSessionInput) {
+	var inputs []rolesanywhere.CreateSessionInput
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/sessions", r.URL.Path, "Expected the CreateSession path")
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err, "Unexpected error reading body")
+
+		matches := authorizationRegex.FindStringSubmatch(r.Header.Get("Authorization"))
+		if !assert.Len(t, matches, 6, "Expected a SigV4-X509 Authorization header: %s", r.Header.Get("Authorization")) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		algorithm, serial, scope, signedHeaders := matches[1], matches[2], matches[3], matches[4]
+		der, err := base64.StdEncoding.DecodeString(r.Header.Get("X-Amz-X509"))
+		assert.NoError(t, err, "Unexpected error decoding certificate")
+		certificate, err := x509.ParseCertificate(der)
+		assert.NoError(t, err, "Unexpected error parsing certificate")
+		assert.Equal(t, certificate.SerialNumber.String(), serial, "Expected the certificate's serial number")
+		assert.Contains(t, scope, "/us-west-2/", "Expected the region of the trust anchor")
+
+		assert.Equal(t, "content-type;host;x-amz-date;x-amz-x509", strings.TrimSuffix(signedHeaders, ";x-amz-x509-chain"), "Expected signed headers to match")
+		canonicalRequest := rolesanywhere.CanonicalRequest(r, body, strings.Split(signedHeaders, ";"))
+		requestHash := sha256.Sum256([]byte(canonicalRequest))
+		stringToSign := strings.Join([]string{algorithm, r.Header.Get("X-Amz-Date"), scope, hex.EncodeToString(requestHash[:])}, "\n")
+		digest := sha256.Sum256([]byte(stringToSign))
+		signature, err := hex.DecodeString(matches[5])
+		assert.NoError(t, err, "Unexpected error decoding signature")
+		switch key := certificate.PublicKey.(type) {
+		case *rsa.PublicKey:
+			assert.Equal(t, "AWS4-X509-RSA-SHA256", algorithm, "Expected the RSA algorithm")
+			assert.NoError(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature), "Expected a valid signature")
+		case *ecdsa.PublicKey:
+			assert.Equal(t, "AWS4-X509-ECDSA-SHA256", algorithm, "Expected the ECDSA algorithm")
+			assert.True(t, ecdsa.VerifyASN1(key, digest[:], signature), "Expected a valid signature")
+		}
+
+		input := rolesanywhere.CreateSessionInput{}
+		assert.NoError(t, json.Unmarshal(body, &input), "Unexpected error parsing body")
+		inputs = append(inputs, input)
+		if input.RoleArn != roleARN {
+			w.Header().Set("X-Amzn-Errortype", "AccessDeniedException:")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message": "Unable to assume role"}`)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"credentialSet": [{"credentials": {"accessKeyId": "%s", "secretAccessKey": "%s", "sessionToken": "%s", "expiration": "%s"}, "roleArn": "%s"}], "subjectArn": "arn:aws:rolesanywhere:us-west-2:111111111111:subject/1"}`,
+			accessKey, secretKey, sessionToken, time.Now().Add(time.Hour).UTC().Format(time.RFC3339), roleARN)
+	}))
+	return server, &inputs
+}
+
+func newRolesAnywhereServiceInTest(t *testing.T, server *httptest.Server, certificatePath, keyPath string) *CredentialService {
+	iamMock, stsMock := setupMocks(t)
+	service := newCredentialServiceInTest(iamMock, stsMock)
+	service.rolesAnywhere = rolesanywhere.New(endpoints.ResolverFunc(func(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		return endpoints.ResolvedEndpoint{URL: server.URL}, nil
+	}))
+	service.credsConfig = &config.CredentialsConfig{
+		RolesAnywhere: map[string]config.RolesAnywhereConfig{
+			"workload": {
+				Certificate:     certificatePath,
+				PrivateKey:      keyPath,
+				TrustAnchorArn:  trustAnchorARN,
+				ProfileArn:      rolesAnywhereProfile,
+				RoleArn:         roleARN,
+				DurationSeconds: 900,
+			},
+			"denied": {
+				Certificate:    certificatePath,
+				PrivateKey:     keyPath,
+				TrustAnchorArn: trustAnchorARN,
+				ProfileArn:     rolesAnywhereProfile,
+				RoleArn:        "arn:aws:iam::111111111111:role/other",
+			},
+			"no-role": {
+				Certificate:    certificatePath,
+				PrivateKey:     keyPath,
+				TrustAnchorArn: trustAnchorARN,
+				ProfileArn:     rolesAnywhereProfile,
+			},
+		},
+	}
+	return service
+}
+
+func TestGetRolesAnywhereCredentialsWithRSAKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roles-anywhere")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err, "Unexpected error generating key")
+	certificatePath, keyPath := writeCertificate(t, dir, key, false)
+
+	server, inputs := newRolesAnywhereServer(t)
+	defer server.Close()
+	service := newRolesAnywhereServiceInTest(t, server, certificatePath, keyPath)
+
+	// a session is only created once while its credentials are valid
+	for i := 0; i < 2; i++ {
+		response, err := service.getRolesAnywhereCredentials("workload")
+		assert.NoError(t, err, "Unexpected error calling getRolesAnywhereCredentials")
+		assert.Equal(t, accessKey, response.AccessKeyID, "Expected access key to match")
+		assert.Equal(t, secretKey, response.SecretAccessKey, "Expected secret key to match")
+		assert.Equal(t, sessionToken, response.Token, "Expected session token to match")
+	}
+
+	assert.Len(t, *inputs, 1, "Expected one CreateSession request")
+	input := (*inputs)[0]
+	assert.Equal(t, trustAnchorARN, input.TrustAnchorArn, "Expected trust anchor to match")
+	assert.Equal(t, rolesAnywhereProfile, input.ProfileArn, "Expected profile to match")
+	assert.Equal(t, roleARN, input.RoleArn, "Expected role to match")
+	assert.Equal(t, int64(900), *input.DurationSeconds, "Expected duration to match")
+}
+
+func TestGetRolesAnywhereCredentialsWithECKeyAndChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roles-anywhere")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "Unexpected error generating key")
+	certificatePath, keyPath := writeCertificate(t, dir, key, true)
+
+	signer, err := rolesanywhere.LoadSigner(certificatePath, keyPath)
+	assert.NoError(t, err, "Unexpected error loading signer")
+	assert.Len(t, signer.Chain, 1, "Expected the second certificate in the chain")
+
+	server, inputs := newRolesAnywhereServer(t)
+	defer server.Close()
+	service := newRolesAnywhereServiceInTest(t, server, certificatePath, keyPath)
+
+	response, err := service.getRolesAnywhereCredentials("workload")
+	assert.NoError(t, err, "Unexpected error calling getRolesAnywhereCredentials")
+	assert.Equal(t, accessKey, response.AccessKeyID, "Expected access key to match")
+	assert.Len(t, *inputs, 1, "Expected one CreateSession request")
+}
+
+func TestGetRolesAnywhereCredentialsUnauthorized(t *testing.T) {
+	server, inputs := newRolesAnywhereServer(t)
+	defer server.Close()
+
+	for name, roleTokens := range map[string]map[string]string{
+		"token for role ARN":  {roleARN: roleAuthToken},
+		"token for role name": {roleName: roleAuthToken},
+	} {
+		t.Run(name, func(t *testing.T) {
+			service := newRolesAnywhereServiceInTest(t, server, "/certs/workload.pem", "/certs/workload.key")
+			service.authorization = &authorization{
+				token:      authToken,
+				roleTokens: roleTokens,
+			}
+			router := mux.NewRouter()
+			service.SetupRoutes(router)
+
+			// the global token does not reach a role which has its own token
+			request := httptest.NewRequest(http.MethodGet, "/roles-anywhere/workload", nil)
+			request.Header.Set(authorizationHeader, authToken)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected status code to match")
+		})
+	}
+	assert.Len(t, *inputs, 0, "Expected no CreateSession requests")
+}
+
+func TestGetRolesAnywhereCredentialsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roles-anywhere")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err, "Unexpected error generating key")
+	certificatePath, keyPath := writeCertificate(t, dir, key, false)
+
+	server, _ := newRolesAnywhereServer(t)
+	defer server.Close()
+	service := newRolesAnywhereServiceInTest(t, server, certificatePath, keyPath)
+
+	_, err = service.getRolesAnywhereCredentials("undefined")
+	assert.Error(t, err, "Expected error for an undefined profile")
+	status, _ := errorStatus(err)
+	assert.Equal(t, http.StatusNotFound, status, "Expected status code 404")
+
+	_, err = service.getRolesAnywhereCredentials("denied")
+	assert.Error(t, err, "Expected error when the role cannot be assumed")
+	assert.Contains(t, err.Error(), "AccessDeniedException", "Expected the error type from IAM Roles Anywhere")
+
+	_, err = service.getRolesAnywhereCredentials("no-role")
+	assert.Error(t, err, "Expected error for a profile without a role")
+}
+
+func TestGetRolesAnywhereCredentialsOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roles-anywhere")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err, "Unexpected error generating key")
+	certificatePath, keyPath := writeCertificate(t, dir, key, false)
+
+	client := offline.New(callerAccountID, 0)
+	service := NewOfflineCredentialService(client)
+	service.credsConfig = &config.CredentialsConfig{
+		RolesAnywhere: map[string]config.RolesAnywhereConfig{
+			"workload": {
+				Certificate:    certificatePath,
+				PrivateKey:     keyPath,
+				TrustAnchorArn: trustAnchorARN,
+				ProfileArn:     rolesAnywhereProfile,
+				RoleArn:        roleARN,
+			},
+		},
+	}
+
+	response, err := service.getRolesAnywhereCredentials("workload")
+	assert.NoError(t, err, "Unexpected error calling getRolesAnywhereCredentials")
+	record := client.Lookup(response.AccessKeyID)
+	assert.NotNil(t, record, "Expected the credentials to be recorded")
+	assert.Equal(t, "CreateSession", record.Operation, "Expected the operation to match")
+	assert.Equal(t, roleARN, record.RoleArn, "Expected the role ARN to match")
+}
@@ -0,0 +1,359 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sso"
+	"github.com/aws/aws-sdk-go/service/ssooidc"
+	"github.com/aws/aws-sdk-go/service/ssooidc/ssooidciface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/useragent"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ssoClientName         = "amazon-ecs-local-container-endpoints"
+	ssoClientType         = "public"
+	ssoDeviceCodeGrant    = "urn:ietf:params:oauth:grant-type:device_code"
+	ssoDefaultPollSeconds = 5
+	ssoSlowDownSeconds    = 5
+)
+
+// ssoLogin runs the IAM Identity Center (SSO) device authorization flow for the base profile when its cached SSO token
+// has expired, which is what 'aws sso login' does. The new token is written to the SSO token cache, where the SDK reads it.
+type ssoLogin struct {
+	newOIDCClient func(region string) ssooidciface.SSOOIDCAPI
+	// onLogin is called once the new token is in the cache
+	onLogin func()
+
+	lock                    sync.Mutex
+	inProgress              bool
+	startURL                string
+	userCode                string
+	verificationURI         string
+	verificationURIComplete string
+	expiration              time.Time
+	lastError               string
+}
+
+// SSOLoginStatusResponse is used to marshal the JSON response for the SSO login admin endpoint
+type SSOLoginStatusResponse struct {
+	StartURL                string `json:",omitempty"`
+	InProgress              bool
+	UserCode                string `json:",omitempty"`
+	VerificationURI         string `json:",omitempty"`
+	VerificationURIComplete string `json:",omitempty"`
+	Expiration              string `json:",omitempty"`
+	Error                   string `json:",omitempty"`
+}
+
+// ssoTokenCacheEntry is the format of the SSO token cache files which the SDK reads
+type ssoTokenCacheEntry struct {
+	StartURL    string `json:"startUrl"`
+	Region      string `json:"region"`
+	AccessToken string `json:"accessToken"`
+	ExpiresAt   string `json:"expiresAt"`
+}
+
+func newSSOLogin(sessionConfig aws.Config, onLogin func()) *ssoLogin {
+	return &ssoLogin{
+		newOIDCClient: func(region string) ssooidciface.SSOOIDCAPI {
+			// the device authorization APIs are not signed
+			sessionConfig := withHTTPClient(sessionConfig)
+			sess := session.Must(session.NewSession(&sessionConfig, &aws.Config{
+				Region:      aws.String(region),
+				Credentials: credentials.AnonymousCredentials,
+			}))
+			client := ssooidc.New(sess)
+			client.Handlers.Build.PushBackNamed(useragent.CustomUserAgentHandler())
+			return client
+		},
+		onLogin: onLogin,
+	}
+}
+
+// isSSOTokenError returns true if the error, or an error it wraps, means that the cached SSO token is missing, has expired,
+// or was rejected by the SSO portal
+func isSSOTokenError(err error) bool {
+	aerr, ok := errors.Cause(err).(awserr.Error)
+	if !ok {
+		return false
+	}
+	if aerr.Code() == ssocreds.ErrCodeSSOProviderInvalidToken || aerr.Code() == sso.ErrCodeUnauthorizedException {
+		return true
+	}
+
+	// the errors of a credential chain are batched
+	if batch, ok := aerr.(awserr.BatchedErrors); ok {
+		for _, origErr := range batch.OrigErrs() {
+			if isSSOTokenError(origErr) {
+				return true
+			}
+		}
+		return false
+	}
+	return aerr.OrigErr() != nil && isSSOTokenError(aerr.OrigErr())
+}
+
+// baseProfileSSOSettings returns the SSO start URL and region of the base profile in the shared config file
+func baseProfileSSOSettings() (startURL, region string, err error) {
+	profile := utils.GetValue(utils.GetValue("default", "AWS_DEFAULT_PROFILE"), "AWS_PROFILE")
+	configFile, _ := sharedConfigFiles()
+	profiles, err := readSharedConfigProfiles(configFile, true)
+	if err != nil {
+		return "", "", err
+	}
+
+	settings := profiles[profile]
+	// the SDK does not read [sso-session] sections, so neither does the login
+	if settings["sso_session"] != "" && settings["sso_start_url"] == "" {
+		return "", "", fmt.Errorf("Profile %s uses sso_session, which is not supported: set sso_start_url and sso_region on the profile in %s", profile, configFile)
+	}
+	startURL, region = settings["sso_start_url"], settings["sso_region"]
+	if startURL == "" || region == "" {
+		return "", "", fmt.Errorf("Profile %s does not have sso_start_url and sso_region in %s", profile, configFile)
+	}
+	return startURL, region, nil
+}
+
+// ssoTokenCacheFile returns the path of the SSO token cache file for the start URL, in the same way as the SDK
+func ssoTokenCacheFile(startURL string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	hash := sha1.Sum([]byte(startURL))
+	return filepath.Join(home, ".aws", "sso", "cache", strings.ToLower(hex.EncodeToString(hash[:]))+".json"), nil
+}
+
+func (login *ssoLogin) status() *SSOLoginStatusResponse {
+	login.lock.Lock()
+	defer login.lock.Unlock()
+	return login.statusLocked()
+}
+
+// statusLocked must be called with the lock held
+func (login *ssoLogin) statusLocked() *SSOLoginStatusResponse {
+	response := &SSOLoginStatusResponse{
+		StartURL:   login.startURL,
+		InProgress: login.inProgress,
+		Error:      login.lastError,
+	}
+	if login.inProgress {
+		response.UserCode = login.userCode
+		response.VerificationURI = login.verificationURI
+		response.VerificationURIComplete = login.verificationURIComplete
+		response.Expiration = login.expiration.Format(CredentialExpirationTimeFormat)
+	}
+	return response
+}
+
+// start begins the device authorization flow, unless a login is already waiting for approval
+func (login *ssoLogin) start() (*SSOLoginStatusResponse, error) {
+	login.lock.Lock()
+	defer login.lock.Unlock()
+	if login.inProgress && time.Now().Before(login.expiration) {
+		return login.statusLocked(), nil
+	}
+
+	startURL, region, err := baseProfileSSOSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	client := login.newOIDCClient(region)
+	registration, err := client.RegisterClient(&ssooidc.RegisterClientInput{
+		ClientName: aws.String(ssoClientName),
+		ClientType: aws.String(ssoClientType),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to register with IAM Identity Center")
+	}
+
+	authorization, err := client.StartDeviceAuthorization(&ssooidc.StartDeviceAuthorizationInput{
+		ClientId:     registration.ClientId,
+		ClientSecret: registration.ClientSecret,
+		StartUrl:     aws.String(startURL),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start the IAM Identity Center device authorization")
+	}
+
+	login.inProgress = true
+	login.startURL = startURL
+	login.userCode = aws.StringValue(authorization.UserCode)
+	login.verificationURI = aws.StringValue(authorization.VerificationUri)
+	login.verificationURIComplete = aws.StringValue(authorization.VerificationUriComplete)
+	login.expiration = time.Now().Add(time.Duration(aws.Int64Value(authorization.ExpiresIn)) * time.Second)
+	login.lastError = ""
+
+	logrus.Warnf("The IAM Identity Center session for %s has expired. To log in again, open %s and confirm the code %s",
+		startURL, login.verificationURIComplete, login.userCode)
+	go login.poll(client, registration, authorization, startURL, region)
+
+	return login.statusLocked(), nil
+}
+
+// poll calls sso-oidc:CreateToken at the interval the service asks for, until the user approves or denies the login,
+// or the device code expires
+func (login *ssoLogin) poll(client ssooidciface.SSOOIDCAPI, registration *ssooidc.RegisterClientOutput,
+	authorization *ssooidc.StartDeviceAuthorizationOutput, startURL, region string) {
+	interval := time.Duration(aws.Int64Value(authorization.Interval)) * time.Second
+	if interval <= 0 {
+		interval = ssoDefaultPollSeconds * time.Second
+	}
+
+	err := func() error {
+		for {
+			login.lock.Lock()
+			expiration := login.expiration
+			login.lock.Unlock()
+			if !time.Now().Add(interval).Before(expiration) {
+				return fmt.Errorf("The IAM Identity Center login was not approved before the code %s expired", aws.StringValue(authorization.UserCode))
+			}
+			time.Sleep(interval)
+
+			token, err := client.CreateToken(&ssooidc.CreateTokenInput{
+				ClientId:     registration.ClientId,
+				ClientSecret: registration.ClientSecret,
+				DeviceCode:   authorization.DeviceCode,
+				GrantType:    aws.String(ssoDeviceCodeGrant),
+			})
+			if aerr, ok := err.(awserr.Error); ok {
+				switch aerr.Code() {
+				case ssooidc.ErrCodeAuthorizationPendingException:
+					continue
+				case ssooidc.ErrCodeSlowDownException:
+					interval += ssoSlowDownSeconds * time.Second
+					continue
+				}
+			}
+			if err != nil {
+				return errors.Wrap(err, "failed to get an IAM Identity Center token")
+			}
+
+			return writeSSOToken(&ssoTokenCacheEntry{
+				StartURL:    startURL,
+				Region:      region,
+				AccessToken: aws.StringValue(token.AccessToken),
+				ExpiresAt:   time.Now().Add(time.Duration(aws.Int64Value(token.ExpiresIn)) * time.Second).UTC().Format(time.RFC3339),
+			})
+		}
+	}()
+
+	login.lock.Lock()
+	login.inProgress = false
+	if err != nil {
+		login.lastError = err.Error()
+	}
+	login.lock.Unlock()
+
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	logrus.Infof("Logged in to IAM Identity Center at %s", startURL)
+	if login.onLogin != nil {
+		login.onLogin()
+	}
+}
+
+// writeSSOToken writes the token to the SSO token cache, which must be writable for the login to take effect
+func writeSSOToken(entry *ssoTokenCacheEntry) error {
+	file, err := ssoTokenCacheFile(entry.StartURL)
+	if err != nil {
+		return err
+	}
+	bits, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return errors.Wrap(err, "failed to create the SSO token cache; the .aws directory must not be mounted read only")
+	}
+	if err = ioutil.WriteFile(file, bits, 0600); err != nil {
+		return errors.Wrap(err, "failed to write the SSO token cache; the .aws directory must not be mounted read only")
+	}
+	return nil
+}
+
+// requireSSOSession starts a new IAM Identity Center login when a request fails because the base profile's SSO token has
+// expired, and replaces the SDK's error with the instructions for approving the login
+func (service *CredentialService) requireSSOSession(handler func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		err := handler(w, r)
+		if err == nil || service.sso == nil || !isSSOTokenError(err) {
+			return err
+		}
+
+		status, loginErr := service.sso.start()
+		if loginErr != nil {
+			return HTTPError{
+				Code: http.StatusUnauthorized,
+				Err:  fmt.Errorf("The IAM Identity Center session has expired, and a new login could not be started: %s", loginErr),
+			}
+		}
+		return HTTPError{
+			Code: http.StatusUnauthorized,
+			Err: fmt.Errorf("The IAM Identity Center session for %s has expired: to log in again, open %s and confirm the code %s, or see 'GET %s'",
+				status.StartURL, status.VerificationURIComplete, status.UserCode, config.SSOLoginAdminPath),
+		}
+	}
+}
+
+// getSSOLoginHandler returns a handler which reports the status of the IAM Identity Center login on GET,
+// and starts a new login on POST
+func (service *CredentialService) getSSOLoginHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if service.sso == nil {
+			return HTTPError{
+				Code: http.StatusNotFound,
+				Err:  fmt.Errorf("IAM Identity Center login is only available when the base credentials come from the shared AWS config files"),
+			}
+		}
+
+		if r.Method == http.MethodPost {
+			logrus.Debug("Received IAM Identity Center login request")
+			response, err := service.sso.start()
+			if err != nil {
+				return err
+			}
+			writeJSONResponse(w, response)
+			return nil
+		}
+
+		writeJSONResponse(w, service.sso.status())
+		return nil
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSSOStartURL    = "https://example.awsapps.com/start"
+	testSSOUserCode    = "ABCD-EFGH"
+	testSSOAccessToken = "ssoaccesstoken"
+	testSSOAccessKey   = "ASIASSOEXAMPLE"
+	testSSOConfig      = `[default]
+region = us-west-2
+sso_start_url = https://example.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 111111111111
+sso_role_name = Developer
+`
+)
+
+// fakeSSOServer stands in for the SSO OIDC service and the SSO portal. Logins are pending until approve is called.
+type fakeSSOServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	approved bool
+}
+
+func newFakeSSOServer() *fakeSSOServer {
+	fake := &fakeSSOServer{}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		writeError := func(status int, code string) {
+			w.Header().Set("X-Amzn-ErrorType", code)
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"error":"%s"}`, code)
+		}
+
+		switch r.URL.Path {
+		case "/client/register":
+			fmt.Fprint(w, `{"clientId":"client","clientSecret":"secret","clientSecretExpiresAt":4102444800}`)
+		case "/device_authorization":
+			fmt.Fprintf(w, `{"deviceCode":"device","userCode":"%s","verificationUri":"%s/device","verificationUriComplete":"%s/device?user_code=%s","expiresIn":600,"interval":1}`,
+				testSSOUserCode, fake.URL, fake.URL, testSSOUserCode)
+		case "/token":
+			fake.lock.Lock()
+			approved := fake.approved
+			fake.lock.Unlock()
+			if !approved {
+				writeError(http.StatusBadRequest, "AuthorizationPendingException")
+				return
+			}
+			fmt.Fprintf(w, `{"accessToken":"%s","expiresIn":28800,"tokenType":"Bearer"}`, testSSOAccessToken)
+		case "/federation/credentials":
+			if r.Header.Get("X-Amz-Sso_bearer_token") != testSSOAccessToken {
+				writeError(http.StatusUnauthorized, "UnauthorizedException")
+				return
+			}
+			fmt.Fprintf(w, `{"roleCredentials":{"accessKeyId":"%s","secretAccessKey":"secret","sessionToken":"token","expiration":%d}}`,
+				testSSOAccessKey, time.Now().Add(time.Hour).UnixNano()/int64(time.Millisecond))
+		default:
+			writeError(http.StatusNotFound, "ResourceNotFoundException")
+		}
+	}))
+	return fake
+}
+
+func (fake *fakeSSOServer) approve() {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.approved = true
+}
+
+func TestIsSSOTokenError(t *testing.T) {
+	invalidToken := awserr.New(ssocreds.ErrCodeSSOProviderInvalidToken, "the SSO session has expired or is invalid", nil)
+
+	assert.True(t, isSSOTokenError(invalidToken), "Expected an invalid token to be detected")
+	assert.True(t, isSSOTokenError(errors.Wrap(invalidToken, "failed to get credentials")), "Expected a wrapped invalid token to be detected")
+	assert.True(t, isSSOTokenError(awserr.New("RequestError", "send request failed", invalidToken)), "Expected a nested invalid token to be detected")
+	assert.True(t, isSSOTokenError(awserr.NewBatchError("NoCredentialProviders", "no valid providers in chain", []error{invalidToken})), "Expected an invalid token in a chain to be detected")
+	assert.False(t, isSSOTokenError(awserr.New("AccessDenied", "not authorized", nil)), "Expected other errors to be ignored")
+	assert.False(t, isSSOTokenError(fmt.Errorf("failed")), "Expected other errors to be ignored")
+}
+
+func TestBaseProfileSSOSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sso")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config")
+	os.Setenv("AWS_CONFIG_FILE", configFile)
+	defer os.Unsetenv("AWS_CONFIG_FILE")
+
+	assert.NoError(t, ioutil.WriteFile(configFile, []byte(testSSOConfig), 0644), "Unexpected error writing config")
+	startURL, region, err := baseProfileSSOSettings()
+	assert.NoError(t, err, "Unexpected error reading SSO settings")
+	assert.Equal(t, testSSOStartURL, startURL, "Expected start URL to match")
+	assert.Equal(t, "us-east-1", region, "Expected region to match")
+
+	ssoSessionConfig := "[default]\nsso_session = example\nsso_account_id = 111111111111\nsso_role_name = Developer\n\n[sso-session example]\nsso_start_url = " + testSSOStartURL + "\nsso_region = us-east-1\n"
+	assert.NoError(t, ioutil.WriteFile(configFile, []byte(ssoSessionConfig), 0644), "Unexpected error writing config")
+	_, _, err = baseProfileSSOSettings()
+	assert.Error(t, err, "Expected an error for a profile with sso_session")
+	assert.Contains(t, err.Error(), "sso_session", "Expected the error to explain sso_session is not supported")
+}
+
+func TestSSOLoginHandlerRequiresAuthorization(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.authorization = &authorization{token: authToken}
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, "/admin/sso", nil))
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected status code for %s without the token", method)
+	}
+}
+
+func TestSSODeviceAuthorizationLogin(t *testing.T) {
+	ssoServer := newFakeSSOServer()
+	defer ssoServer.Close()
+	stsServer := newFakeSTSServer()
+	defer stsServer.Close()
+
+	dir, err := ioutil.TempDir("", "sso")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config")
+	assert.NoError(t, ioutil.WriteFile(configFile, []byte(testSSOConfig), 0644), "Unexpected error writing config")
+
+	home := os.Getenv("HOME")
+	defer os.Setenv("HOME", home)
+	os.Setenv("HOME", dir)
+	for key, value := range map[string]string{
+		"AWS_CONFIG_FILE":               configFile,
+		"AWS_SHARED_CREDENTIALS_FILE":   filepath.Join(dir, "credentials"),
+		config.SSOCustomEndpointVar:     ssoServer.URL,
+		config.SSOOIDCCustomEndpointVar: ssoServer.URL,
+		config.STSCustomEndpointVar:     stsServer.URL,
+	} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	service, err := newAWSCredentialService()
+	assert.NoError(t, err, "Unexpected error creating service")
+	router := mux.NewRouter()
+	service.SetupRoutes(router)
+
+	// without a cached token, the request starts a login
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, config.TempCredentialsPath, nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected status code 401")
+	assert.Contains(t, recorder.Body.String(), testSSOUserCode, "Expected the user code in the error")
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, config.SSOLoginAdminPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code 200")
+	status := &SSOLoginStatusResponse{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), status), "Unexpected error decoding status")
+	assert.True(t, status.InProgress, "Expected the login to be in progress")
+	assert.Equal(t, testSSOStartURL, status.StartURL, "Expected the start URL to match")
+	assert.Equal(t, testSSOUserCode, status.UserCode, "Expected the user code to match")
+	assert.Equal(t, ssoServer.URL+"/device?user_code="+testSSOUserCode, status.VerificationURIComplete, "Expected the verification URL to match")
+
+	// once approved, the token is cached and credentials are vended again
+	ssoServer.approve()
+	for i := 0; i < 100 && service.sso.status().InProgress; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	status = service.sso.status()
+	assert.False(t, status.InProgress, "Expected the login to finish")
+	assert.Empty(t, status.Error, "Unexpected login error")
+
+	cacheFile, err := ssoTokenCacheFile(testSSOStartURL)
+	assert.NoError(t, err, "Unexpected error getting the cache file")
+	bits, err := ioutil.ReadFile(cacheFile)
+	assert.NoError(t, err, "Expected the token to be cached")
+	entry := &ssoTokenCacheEntry{}
+	assert.NoError(t, json.Unmarshal(bits, entry), "Unexpected error decoding the cached token")
+	assert.Equal(t, testSSOAccessToken, entry.AccessToken, "Expected the access token to match")
+	assert.Equal(t, "us-east-1", entry.Region, "Expected the region to match")
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, config.TempCredentialsPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code 200")
+	response := &CredentialResponse{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), response), "Unexpected error decoding credentials")
+	assert.Equal(t, testSSOAccessKey, response.AccessKeyID, "Expected the SSO role credentials")
+}
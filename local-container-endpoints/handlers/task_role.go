@@ -0,0 +1,123 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Labels which select the role assumed for a container that requests credentials from the task role path
+const (
+	taskRoleArnLabel  = "ecs-local.task-role-arn"
+	taskRoleNameLabel = "ecs-local.task-role"
+)
+
+// getTaskRoleHandler returns a handler which vends credentials for the role named in the labels of the
+// container that made the request, the same way each ECS task gets exactly its own task role
+func (service *CredentialService) getTaskRoleHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received task role credentials request")
+
+		container, err := service.findTaskRoleContainer(getCallerIP(r))
+		auditCaller(r, container)
+		if err != nil {
+			return err
+		}
+
+		// the role is only known once the caller is found, so its token is checked here rather than by requireAuthorization
+		if err := service.checkAuthorization(r, service.taskRoleKeys(container)...); err != nil {
+			return err
+		}
+
+		response, err := service.getTaskRoleCredentialsForContainer(container)
+		if err != nil {
+			return err
+		}
+
+		auditCredentials(r, response)
+		writeJSONResponse(w, response)
+		return nil
+	}
+}
+
+func (service *CredentialService) getTaskRoleCredentials(callerIP string) (*CredentialResponse, error) {
+	container, err := service.findTaskRoleContainer(callerIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.getTaskRoleCredentialsForContainer(container)
+}
+
+// findTaskRoleContainer returns the container which made the request
+func (service *CredentialService) findTaskRoleContainer(callerIP string) (*types.Container, error) {
+	if service.dockerClient == nil {
+		return nil, fmt.Errorf("Task role credentials require access to the Docker API")
+	}
+
+	container, err := findCallerContainer(service.dockerClient, callerIP)
+	if err != nil {
+		return nil, HTTPError{
+			Code: http.StatusBadRequest,
+			Err:  err,
+		}
+	}
+	return container, nil
+}
+
+// taskRoleKeys returns the role ARN and/or name in the container's labels, which may have their own authorization token
+func (service *CredentialService) taskRoleKeys(container *types.Container) []string {
+	if roleArn := container.Labels[taskRoleArnLabel]; roleArn != "" {
+		return []string{roleArn, roleNameFromArn(roleArn)}
+	}
+	if roleName := container.Labels[taskRoleNameLabel]; roleName != "" {
+		return append(service.authorization.roleArnKeys("", roleName), roleName)
+	}
+	return nil
+}
+
+func (service *CredentialService) getTaskRoleCredentialsForContainer(container *types.Container) (*CredentialResponse, error) {
+	if roleArn := container.Labels[taskRoleArnLabel]; roleArn != "" {
+		logrus.Debugf("Container %s has task role ARN %s", container.ID, roleArn)
+		return service.getRoleCredentialsFromArn(roleArn, roleNameFromArn(roleArn), container, 0)
+	}
+
+	if roleName := container.Labels[taskRoleNameLabel]; roleName != "" {
+		logrus.Debugf("Container %s has task role %s", container.ID, roleName)
+		return service.getRoleCredentials(roleName, container)
+	}
+
+	return nil, HTTPError{
+		Code: http.StatusNotFound,
+		Err:  fmt.Errorf("Container %s has no task role: set the %s or %s label", containerName(container), taskRoleArnLabel, taskRoleNameLabel),
+	}
+}
+
+// roleNameFromArn returns the last segment of the role ARN's resource, which is the role name
+func roleNameFromArn(roleArn string) string {
+	return roleArn[strings.LastIndex(roleArn, "/")+1:]
+}
+
+// containerName returns the container's name without the leading slash, or its ID if it has no name
+func containerName(container *types.Container) string {
+	if len(container.Names) > 0 {
+		return strings.TrimPrefix(container.Names[0], "/")
+	}
+	return container.ID
+}
@@ -0,0 +1,236 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker/mock_docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTaskRoleCredentialsFromArnLabel(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+
+	credsService := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+
+	caller := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).WithLabel(taskRoleArnLabel, roleARN).Get()
+	other := testingutils.BaseDockerContainer(containerName2, longID2).WithNetwork(network1, ipAddress2).WithLabel(taskRoleArnLabel, "arn:aws:iam::111111111111111:role/pudding").Get()
+
+	expiration := time.Now().Add(time.Hour)
+
+	gomock.InOrder(
+		dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{caller, other}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+			assert.Equal(t, roleARN, aws.StringValue(input.RoleArn), "Expected role ARN to match")
+			assert.Equal(t, "ecs-local-"+roleName, aws.StringValue(input.RoleSessionName), "Expected session name to match")
+		}).Return(&sts.AssumeRoleOutput{
+			Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String(accessKey),
+				SecretAccessKey: aws.String(secretKey),
+				SessionToken:    aws.String(sessionToken),
+				Expiration:      &expiration,
+			},
+		}, nil),
+	)
+
+	response, err := credsService.getTaskRoleCredentials(ipAddress1)
+	assert.NoError(t, err, "Unexpected error calling getTaskRoleCredentials")
+	assert.Equal(t, accessKey, response.AccessKeyID, "Expected access key to match")
+	assert.Equal(t, roleARN, response.RoleArn, "Expected role ARN to match")
+}
+
+func TestGetTaskRoleCredentialsFromNameLabel(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+
+	credsService := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+
+	caller := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).WithLabel(taskRoleNameLabel, roleName).Get()
+
+	expiration := time.Now().Add(time.Hour)
+
+	gomock.InOrder(
+		dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{caller}, nil),
+		iamMock.EXPECT().GetRole(gomock.Any()).Do(func(input *iam.GetRoleInput) {
+			assert.Equal(t, roleName, aws.StringValue(input.RoleName), "Expected role name to match")
+		}).Return(&iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn: aws.String(roleARN),
+			},
+		}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Return(&sts.AssumeRoleOutput{
+			Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String(accessKey),
+				SecretAccessKey: aws.String(secretKey),
+				SessionToken:    aws.String(sessionToken),
+				Expiration:      &expiration,
+			},
+		}, nil),
+	)
+
+	response, err := credsService.getTaskRoleCredentials(ipAddress1)
+	assert.NoError(t, err, "Unexpected error calling getTaskRoleCredentials")
+	assert.Equal(t, roleARN, response.RoleArn, "Expected role ARN to match")
+}
+
+func TestGetTaskRoleCredentialsErrors(t *testing.T) {
+	withoutLabel := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).Get()
+	withLabel := testingutils.BaseDockerContainer(containerName2, longID2).WithNetwork(network1, ipAddress2).WithLabel(taskRoleArnLabel, roleARN).Get()
+
+	var testCases = []struct {
+		name           string
+		callerIP       string
+		expectedStatus int
+	}{
+		{
+			name:           "caller without a task role",
+			callerIP:       ipAddress1,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "unknown caller",
+			callerIP:       ipAddress3,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			iamMock, stsMock := setupMocks(t)
+			dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+			credsService := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+
+			dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{withoutLabel, withLabel}, nil)
+
+			_, err := credsService.getTaskRoleCredentials(testCase.callerIP)
+			assert.Error(t, err, "Expected error calling getTaskRoleCredentials")
+			httpErr, ok := err.(HTTPError)
+			assert.True(t, ok, "Expected an HTTPError")
+			assert.Equal(t, testCase.expectedStatus, httpErr.Status(), "Expected status code to match")
+		})
+	}
+}
+
+func TestGetTaskRoleCredentialsUnauthorized(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		labels map[string]string
+		auth   *authorization
+		token  string
+	}{
+		{
+			name:   "missing token",
+			labels: map[string]string{taskRoleArnLabel: roleARN},
+			auth:   &authorization{token: authToken},
+		},
+		{
+			name:   "global token for role ARN with its own token",
+			labels: map[string]string{taskRoleArnLabel: roleARN},
+			auth: &authorization{
+				token:      authToken,
+				roleTokens: map[string]string{roleARN: roleAuthToken},
+			},
+			token: authToken,
+		},
+		{
+			name:   "global token for role name with its own token",
+			labels: map[string]string{taskRoleNameLabel: roleName},
+			auth: &authorization{
+				token:      authToken,
+				roleTokens: map[string]string{roleName: roleAuthToken},
+			},
+			token: authToken,
+		},
+		{
+			name:   "global token for role name with a role ARN token",
+			labels: map[string]string{taskRoleNameLabel: roleName},
+			auth: &authorization{
+				token:      authToken,
+				roleTokens: map[string]string{roleARN: roleAuthToken},
+			},
+			token: authToken,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			iamMock, stsMock := setupMocks(t)
+			dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+
+			credsService := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+			credsService.authorization = testCase.auth
+
+			builder := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, testRequestIP)
+			for label, value := range testCase.labels {
+				builder = builder.WithLabel(label, value)
+			}
+			dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{builder.Get()}, nil)
+
+			router := mux.NewRouter()
+			credsService.SetupRoutes(router)
+			req := httptest.NewRequest(http.MethodGet, "/task-role", nil)
+			if testCase.token != "" {
+				req.Header.Set(authorizationHeader, testCase.token)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected status code to match")
+		})
+	}
+}
+
+func TestGetTaskRoleCredentialsWithRoleToken(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+
+	credsService := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+	credsService.authorization = &authorization{
+		token:      authToken,
+		roleTokens: map[string]string{roleARN: roleAuthToken},
+	}
+
+	caller := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, testRequestIP).WithLabel(taskRoleArnLabel, roleARN).Get()
+	expiration := time.Now().Add(time.Hour)
+	gomock.InOrder(
+		dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{caller}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Return(&sts.AssumeRoleOutput{
+			Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String(accessKey),
+				SecretAccessKey: aws.String(secretKey),
+				SessionToken:    aws.String(sessionToken),
+				Expiration:      &expiration,
+			},
+		}, nil),
+	)
+
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+	req := httptest.NewRequest(http.MethodGet, "/task-role", nil)
+	req.Header.Set(authorizationHeader, roleAuthToken)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+}
@@ -20,4 +20,27 @@ type CredentialResponse struct {
 	RoleArn         string
 	SecretAccessKey string
 	Token           string
+
+	// sessionName is recorded in the audit log, but not returned to containers
+	sessionName string
+}
+
+// PodIdentityCredentialResponse is used to marshal the JSON response in the format of the EKS Pod Identity Agent
+type PodIdentityCredentialResponse struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	AccountID       string `json:"AccountId"`
+	Expiration      string
+}
+
+// IMDSCredentialResponse is used to marshal the JSON response in the format of the EC2 Instance Metadata Service
+type IMDSCredentialResponse struct {
+	Code            string
+	LastUpdated     string
+	Type            string
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      string
 }
@@ -0,0 +1,135 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// getWebIdentityHandler returns a handler which vends credentials for a configured web identity
+func (service *CredentialService) getWebIdentityHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logrus.Debug("Received web identity credentials request")
+
+		response, err := service.getWebIdentityCredentials(mux.Vars(r)["identity"], getCallerIP(r))
+		if err != nil {
+			return err
+		}
+
+		auditCredentials(r, response)
+		writeJSONResponse(w, response)
+		return nil
+	}
+}
+
+// getWebIdentityCredentials assumes the configured role with sts:AssumeRoleWithWebIdentity, which needs no base credentials.
+// The token is read on every request, and credentials are cached for each token, so a rotated token is used right away.
+func (service *CredentialService) getWebIdentityCredentials(name, callerIP string) (*CredentialResponse, error) {
+	identity, ok := service.credsConfig.WebIdentities[name]
+	if !ok {
+		return nil, HTTPError{
+			Code: http.StatusNotFound,
+			Err:  fmt.Errorf("Web identity %s is not defined in the credentials configuration", name),
+		}
+	}
+	if identity.RoleArn == "" {
+		return nil, fmt.Errorf("Web identity %s has no RoleArn", name)
+	}
+
+	token, err := service.readWebIdentityToken(name, identity, callerIP)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionName := identity.SessionName
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("ecs-local-%s", name)
+	}
+	input := &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(identity.RoleArn),
+		RoleSessionName:  aws.String(sessionName),
+		WebIdentityToken: aws.String(token),
+	}
+	if identity.DurationSeconds > 0 {
+		input.DurationSeconds = aws.Int64(identity.DurationSeconds)
+	}
+
+	// the request is not signed, so the client does not need credentials
+	_, stsClient := service.clientsWithCredentials(credentials.AnonymousCredentials)
+	tokenHash := sha256.Sum256([]byte(token))
+	cacheKey := fmt.Sprintf("web-identity:%s:%s", name, hex.EncodeToString(tokenHash[:]))
+	return service.cache.get(cacheKey, func() (*CredentialResponse, time.Time, error) {
+		logrus.Debugf("Assuming role %s with web identity %s", identity.RoleArn, name)
+		output, err := stsClient.AssumeRoleWithWebIdentity(input)
+		if err != nil {
+			return nil, time.Time{}, errors.Wrapf(err, "failed to assume role %s with web identity %s", identity.RoleArn, name)
+		}
+
+		return &CredentialResponse{
+			AccessKeyID:     aws.StringValue(output.Credentials.AccessKeyId),
+			SecretAccessKey: aws.StringValue(output.Credentials.SecretAccessKey),
+			RoleArn:         identity.RoleArn,
+			Token:           aws.StringValue(output.Credentials.SessionToken),
+			Expiration:      output.Credentials.Expiration.Format(CredentialExpirationTimeFormat),
+			sessionName:     sessionName,
+		}, aws.TimeValue(output.Credentials.Expiration), nil
+	})
+}
+
+// readWebIdentityToken reads the token from the Local Endpoints container, or from the container which made the request
+func (service *CredentialService) readWebIdentityToken(name string, identity config.WebIdentityConfig, callerIP string) (string, error) {
+	var bits []byte
+	var err error
+	switch {
+	case identity.TokenFile != "" && identity.ContainerTokenFile != "":
+		return "", fmt.Errorf("Web identity %s must have only one of TokenFile and ContainerTokenFile", name)
+	case identity.TokenFile != "":
+		bits, err = ioutil.ReadFile(identity.TokenFile)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read the token for web identity %s", name)
+		}
+	case identity.ContainerTokenFile != "":
+		container, err := service.findTaskRoleContainer(callerIP)
+		if err != nil {
+			return "", err
+		}
+		bits, err = service.dockerClient.ReadContainerFile(context.Background(), container.ID, identity.ContainerTokenFile)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read the token for web identity %s", name)
+		}
+	default:
+		return "", fmt.Errorf("Web identity %s has neither TokenFile nor ContainerTokenFile", name)
+	}
+
+	token := strings.TrimSpace(string(bits))
+	if token == "" {
+		return "", fmt.Errorf("The token for web identity %s is empty", name)
+	}
+	return token, nil
+}
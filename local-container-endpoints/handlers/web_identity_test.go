@@ -0,0 +1,209 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker/mock_docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/offline"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const containerTokenFile = "/var/run/secrets/eks.amazonaws.com/serviceaccount/token"
+
+func assumeRoleWithWebIdentityOutput() *sts.AssumeRoleWithWebIdentityOutput {
+	expiration := time.Now().Add(time.Hour)
+	return &sts.AssumeRoleWithWebIdentityOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}
+}
+
+func TestGetWebIdentityCredentialsFromTokenFile(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	service := newCredentialServiceInTest(iamMock, stsMock)
+	service.newClients = func(creds *credentials.Credentials) (iamiface.IAMAPI, stsiface.STSAPI) {
+		assert.Equal(t, credentials.AnonymousCredentials, creds, "Expected the STS client to be anonymous")
+		return iamMock, stsMock
+	}
+
+	dir, err := ioutil.TempDir("", "web-identity")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("first-token\n"), 0644), "Unexpected error writing token")
+
+	service.credsConfig = &config.CredentialsConfig{
+		WebIdentities: map[string]config.WebIdentityConfig{
+			"irsa": {
+				RoleArn:         roleARN,
+				TokenFile:       tokenFile,
+				DurationSeconds: 900,
+			},
+		},
+	}
+
+	var tokens []string
+	stsMock.EXPECT().AssumeRoleWithWebIdentity(gomock.Any()).Do(func(input *sts.AssumeRoleWithWebIdentityInput) {
+		assert.Equal(t, roleARN, aws.StringValue(input.RoleArn), "Expected role ARN to match")
+		assert.Equal(t, "ecs-local-irsa", aws.StringValue(input.RoleSessionName), "Expected session name to match")
+		assert.Equal(t, int64(900), aws.Int64Value(input.DurationSeconds), "Expected duration to match")
+		tokens = append(tokens, aws.StringValue(input.WebIdentityToken))
+	}).Return(assumeRoleWithWebIdentityOutput(), nil).Times(2)
+
+	// the role is assumed once per token
+	for i := 0; i < 2; i++ {
+		response, err := service.getWebIdentityCredentials("irsa", ipAddress1)
+		assert.NoError(t, err, "Unexpected error calling getWebIdentityCredentials")
+		assert.Equal(t, accessKey, response.AccessKeyID, "Expected access key to match")
+	}
+
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("second-token\n"), 0644), "Unexpected error writing token")
+	_, err = service.getWebIdentityCredentials("irsa", ipAddress1)
+	assert.NoError(t, err, "Unexpected error calling getWebIdentityCredentials")
+	assert.Equal(t, []string{"first-token", "second-token"}, tokens, "Expected the rotated token to be used")
+}
+
+func TestGetWebIdentityCredentialsFromContainerTokenFile(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+	service := NewCredentialServiceWithClients(iamMock, stsMock, dockerMock, nil)
+	service.newClients = func(creds *credentials.Credentials) (iamiface.IAMAPI, stsiface.STSAPI) {
+		return iamMock, stsMock
+	}
+	service.credsConfig = &config.CredentialsConfig{
+		WebIdentities: map[string]config.WebIdentityConfig{
+			"irsa": {
+				RoleArn:            roleARN,
+				ContainerTokenFile: containerTokenFile,
+				SessionName:        "my-pod",
+			},
+		},
+	}
+
+	caller := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).Get()
+	gomock.InOrder(
+		dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{caller}, nil),
+		dockerMock.EXPECT().ReadContainerFile(gomock.Any(), longID1, containerTokenFile).Return([]byte("container-token"), nil),
+		stsMock.EXPECT().AssumeRoleWithWebIdentity(gomock.Any()).Do(func(input *sts.AssumeRoleWithWebIdentityInput) {
+			assert.Equal(t, "my-pod", aws.StringValue(input.RoleSessionName), "Expected session name to match")
+			assert.Equal(t, "container-token", aws.StringValue(input.WebIdentityToken), "Expected the token from the container")
+			assert.Nil(t, input.DurationSeconds, "Expected the default duration")
+		}).Return(assumeRoleWithWebIdentityOutput(), nil),
+	)
+
+	response, err := service.getWebIdentityCredentials("irsa", ipAddress1)
+	assert.NoError(t, err, "Unexpected error calling getWebIdentityCredentials")
+	assert.Equal(t, accessKey, response.AccessKeyID, "Expected access key to match")
+}
+
+func TestGetWebIdentityCredentialsUnauthorized(t *testing.T) {
+	for name, roleTokens := range map[string]map[string]string{
+		"token for role ARN":  {roleARN: roleAuthToken},
+		"token for role name": {roleName: roleAuthToken},
+	} {
+		t.Run(name, func(t *testing.T) {
+			iamMock, stsMock := setupMocks(t)
+			service := newCredentialServiceInTest(iamMock, stsMock)
+			service.authorization = &authorization{
+				token:      authToken,
+				roleTokens: roleTokens,
+			}
+			service.credsConfig = &config.CredentialsConfig{
+				WebIdentities: map[string]config.WebIdentityConfig{
+					"irsa": {
+						RoleArn:   roleARN,
+						TokenFile: "/var/run/secrets/token",
+					},
+				},
+			}
+			router := mux.NewRouter()
+			service.SetupRoutes(router)
+
+			// the global token does not reach a role which has its own token
+			request := httptest.NewRequest(http.MethodGet, "/web-identity/irsa", nil)
+			request.Header.Set(authorizationHeader, authToken)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected status code to match")
+		})
+	}
+}
+
+func TestGetWebIdentityCredentialsErrors(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	service := newCredentialServiceInTest(iamMock, stsMock)
+	service.credsConfig = &config.CredentialsConfig{
+		WebIdentities: map[string]config.WebIdentityConfig{
+			"no-role":  {TokenFile: "/token"},
+			"no-token": {RoleArn: roleARN},
+			"both":     {RoleArn: roleARN, TokenFile: "/token", ContainerTokenFile: containerTokenFile},
+			"missing":  {RoleArn: roleARN, TokenFile: "/does/not/exist"},
+		},
+	}
+
+	_, err := service.getWebIdentityCredentials("undefined", ipAddress1)
+	assert.Error(t, err, "Expected error for an undefined web identity")
+	status, _ := errorStatus(err)
+	assert.Equal(t, http.StatusNotFound, status, "Expected status code 404")
+
+	for _, name := range []string{"no-role", "no-token", "both", "missing"} {
+		_, err := service.getWebIdentityCredentials(name, ipAddress1)
+		assert.Error(t, err, "Expected error for %s", name)
+	}
+}
+
+func TestGetWebIdentityCredentialsOffline(t *testing.T) {
+	client := offline.New(callerAccountID, 0)
+	service := NewOfflineCredentialService(client)
+
+	dir, err := ioutil.TempDir("", "web-identity")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("token"), 0644), "Unexpected error writing token")
+	service.credsConfig = &config.CredentialsConfig{
+		WebIdentities: map[string]config.WebIdentityConfig{
+			"github": {RoleArn: roleARN, TokenFile: tokenFile},
+		},
+	}
+
+	response, err := service.getWebIdentityCredentials("github", ipAddress1)
+	assert.NoError(t, err, "Unexpected error calling getWebIdentityCredentials")
+	record := client.Lookup(response.AccessKeyID)
+	assert.NotNil(t, record, "Expected the credentials to be recorded")
+	assert.Equal(t, "AssumeRoleWithWebIdentity", record.Operation, "Expected the operation to match")
+	assert.Equal(t, roleARN, record.RoleArn, "Expected the role ARN to match")
+}
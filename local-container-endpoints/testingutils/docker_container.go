@@ -88,6 +88,15 @@ func (apiContainer *DockerContainer) WithComposeProject(projectName string) *Doc
 	return apiContainer
 }
 
+// WithLabel adds a label and returns the container for chaining
+func (apiContainer *DockerContainer) WithLabel(key, value string) *DockerContainer {
+	if apiContainer.container.Labels == nil {
+		apiContainer.container.Labels = make(map[string]string)
+	}
+	apiContainer.container.Labels[key] = value
+	return apiContainer
+}
+
 // WithNetwork adds a Docker Network and returns the container for chaining
 func (apiContainer *DockerContainer) WithNetwork(networkName, ipAddress string) *DockerContainer {
 	if apiContainer.container.NetworkSettings == nil {
@@ -17,7 +17,9 @@ package utils
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Truncate truncates a string
@@ -51,3 +53,18 @@ func GetValue(defaultVal, envVar string) string {
 
 	return defaultVal
 }
+
+// ParseDuration parses a duration (quantity + unit), or a plain number which is taken to be seconds
+func ParseDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err == nil {
+		return duration, nil
+	}
+
+	// If they didn't provide a unit, try to parse this as seconds.
+	seconds, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid duration: %s", value)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -29,6 +29,10 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	logrus.Info(version.String())
 	logrus.Info("Running...")
 	credentialsService, err := handlers.NewCredentialService()
@@ -44,12 +48,24 @@ func main() {
 		logrus.Fatal("Failed to create Metadata Service: ", err)
 	}
 
+	imdsService, err := handlers.NewIMDSService(credentialsService)
+	if err != nil {
+		logrus.Fatal("Failed to create Instance Metadata Service: ", err)
+	}
+
+	oidcIssuerService, err := handlers.NewOIDCIssuerService(credentialsService)
+	if err != nil {
+		logrus.Fatal("Failed to create OIDC Issuer Service: ", err)
+	}
+
 	port := utils.GetValue(config.DefaultPort, config.PortVar)
 
 	router := mux.NewRouter()
 	metadataService.SetupV2Routes(router)
 	metadataService.SetupV3Routes(router)
 	credentialsService.SetupRoutes(router)
+	imdsService.SetupRoutes(router)
+	oidcIssuerService.SetupRoutes(router)
 
 	server := http.Server{
 		Addr:    fmt.Sprintf(":%s", port),